@@ -1,10 +1,12 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // PostgresSyncSpec defines the desired state of PostgresSync
+// +kubebuilder:validation:XValidation:rule="has(self.storage) || (has(self.repositoryURL) && size(self.repositoryURL) > 0)",message="repositoryURL is required unless storage is set"
 type PostgresSyncSpec struct {
 	// StatefulSetRef points to the StatefulSet that this sync watches
 	StatefulSetRef StatefulSetReference `json:"statefulSetRef"`
@@ -13,14 +15,39 @@ type PostgresSyncSpec struct {
 	DatabaseService DatabaseServiceReference `json:"databaseService"`
 
 	// RepositoryURL is the Git repository URL where dumps will be stored
-	RepositoryURL string `json:"repositoryURL"`
+	// Required unless Storage keeps dumps elsewhere
+	// +optional
+	RepositoryURL string `json:"repositoryURL,omitempty"`
 
-	// databaseDumpPath specifies the path within the Git repository where dumps should be stored
+	// databaseDumpPath specifies the path within the Git repository where dumps should be stored, or under the
+	// prefix of the storage. Defaults to "dumps" in Git and to "<namespace>/<name>" in other storages
 	// +optional
 	DatabaseDumpPath string `json:"databaseDumpPath,omitempty"`
 
 	// GitCredentials contains authentication information for Git
-	GitCredentials CredentialReference `json:"gitCredentials"`
+	// +optional
+	GitCredentials CredentialReference `json:"gitCredentials,omitempty"`
+
+	// Git configures how dumps are pushed to the Git repository
+	// +optional
+	Git *GitSpec `json:"git,omitempty"`
+
+	// Storage keeps dumps somewhere else than the Git repository
+	// +optional
+	Storage *StorageSpec `json:"storage,omitempty"`
+
+	// Dump configures how the database is dumped
+	// +optional
+	Dump *DumpSpec `json:"dump,omitempty"`
+
+	// Restore configures which dump is restored into the database
+	// +optional
+	Restore *RestoreSpec `json:"restore,omitempty"`
+
+	// RestoreRequest asks the operator to restore the database from the repository again, even after
+	// the initial restore. It is cleared once the restore starts
+	// +optional
+	RestoreRequest *RestoreRequest `json:"restoreRequest,omitempty"`
 
 	// DatabaseCredentials contains authentication information for the database
 	DatabaseCredentials CredentialReference `json:"databaseCredentials"`
@@ -28,6 +55,735 @@ type PostgresSyncSpec struct {
 	// DumpOnWebhook triggers a database dump when set to true
 	// +optional
 	DumpOnWebhook bool `json:"dumpOnWebhook,omitempty"`
+
+	// DumpRequestID is the ID of the operation requested along with DumpOnWebhook. It is set by the webhook
+	// and reported back in Status.Operations
+	// +optional
+	DumpRequestID string `json:"dumpRequestID,omitempty"`
+
+	// Webhook configures how requests to the dump webhook of this PostgresSync are authenticated
+	// The webhook rejects every request for PostgresSyncs without it
+	// +optional
+	Webhook *WebhookSpec `json:"webhook,omitempty"`
+
+	// Schedule triggers periodic database dumps evaluated by the operator itself
+	// +optional
+	Schedule *ScheduleSpec `json:"schedule,omitempty"`
+
+	// Execution configures where dumps and restores run
+	// +optional
+	Execution *ExecutionSpec `json:"execution,omitempty"`
+
+	// DumpHistoryLimit is how many finished PostgresSyncDumps are kept for this sync
+	// +kubebuilder:default=10
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	DumpHistoryLimit *int32 `json:"dumpHistoryLimit,omitempty"`
+}
+
+// WebhookAuthentication describes how webhook requests are authenticated
+// +kubebuilder:validation:Enum=BearerToken;HMAC
+type WebhookAuthentication string
+
+const (
+	// WebhookAuthenticationBearerToken expects the token in an "Authorization: Bearer" header
+	WebhookAuthenticationBearerToken WebhookAuthentication = "BearerToken"
+
+	// WebhookAuthenticationHMAC expects an HMAC-SHA256 signature of the request, keyed with the token,
+	// in the X-Ceviche-Signature header along with its X-Ceviche-Timestamp
+	WebhookAuthenticationHMAC WebhookAuthentication = "HMAC"
+)
+
+// WebhookSpec defines how requests to the dump webhook are authenticated
+type WebhookSpec struct {
+	// Authentication selects a bearer token or HMAC request signatures. Defaults to BearerToken
+	// +kubebuilder:default=BearerToken
+	// +optional
+	Authentication WebhookAuthentication `json:"authentication,omitempty"`
+
+	// Credentials references the Secret holding the token, or the HMAC key, under the "token" key
+	Credentials CredentialReference `json:"credentials"`
+
+	// TimestampTolerance is how far the timestamp of a signed request may be from the operator's clock
+	// Signed requests older than this are rejected as replays. Defaults to 5 minutes
+	// +optional
+	TimestampTolerance *metav1.Duration `json:"timestampTolerance,omitempty"`
+}
+
+// ExecutionMode describes where dumps and restores run
+// +kubebuilder:validation:Enum=InProcess;Job
+type ExecutionMode string
+
+const (
+	// ExecutionModeInProcess runs dumps and restores inside the operator's reconcile loop
+	ExecutionModeInProcess ExecutionMode = "InProcess"
+
+	// ExecutionModeJob runs dumps and restores in Jobs owned by the PostgresSync
+	ExecutionModeJob ExecutionMode = "Job"
+)
+
+// ExecutionSpec defines where dumps and restores run
+type ExecutionSpec struct {
+	// Mode selects whether dumps and restores run inside the operator or in Jobs
+	// +kubebuilder:default=InProcess
+	// +optional
+	Mode ExecutionMode `json:"mode,omitempty"`
+
+	// Job configures the Jobs created when Mode is Job
+	// +optional
+	Job *JobTemplate `json:"job,omitempty"`
+}
+
+// JobTemplate configures the Jobs running dumps and restores
+type JobTemplate struct {
+	// Image is the container image running the operation, it must contain the operator binary
+	// and the PostgreSQL client tools. Defaults to the operator's --job-image
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// ImagePullPolicy for the Job container
+	// +optional
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// Resources of the Job container
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// NodeSelector constrains the nodes the Job pod can run on
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations of the Job pod
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// ServiceAccountName is the ServiceAccount the Job pod runs as. It needs to read this
+	// PostgresSync and the Secrets it references
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// ActiveDeadlineSeconds bounds how long the Job may run before it is failed
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+
+	// TTLSecondsAfterFinished is how long finished Jobs are kept. Defaults to one day
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+}
+
+// MissedRunPolicy describes how scheduled runs missed while the operator was not running are handled
+// +kubebuilder:validation:Enum=RunOnce;Skip
+type MissedRunPolicy string
+
+const (
+	// MissedRunPolicyRunOnce runs a single catch-up dump for the most recent missed schedule
+	MissedRunPolicyRunOnce MissedRunPolicy = "RunOnce"
+
+	// MissedRunPolicySkip skips all missed schedules and waits for the next one
+	MissedRunPolicySkip MissedRunPolicy = "Skip"
+)
+
+// ScheduleSpec defines when periodic database dumps are taken
+type ScheduleSpec struct {
+	// Cron is a standard five-field cron expression, e.g. "0 3 * * *"
+	// Descriptors such as "@daily" are also accepted
+	// +kubebuilder:validation:MinLength=1
+	Cron string `json:"cron"`
+
+	// TimeZone is the IANA time zone name the cron expression is evaluated in
+	// If empty, UTC is used
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Jitter delays every scheduled run by a stable per-PostgresSync offset of up to this duration,
+	// spreading many syncs that share the same schedule
+	// +optional
+	Jitter *metav1.Duration `json:"jitter,omitempty"`
+
+	// StartingDeadlineSeconds is the deadline in seconds for starting a scheduled dump if it
+	// misses its scheduled time for any reason. Dumps that miss the deadline are skipped
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+
+	// MissedRunPolicy controls what happens when more than one scheduled run was missed,
+	// e.g. because the operator was down. Defaults to RunOnce
+	// +kubebuilder:default=RunOnce
+	// +optional
+	MissedRunPolicy MissedRunPolicy `json:"missedRunPolicy,omitempty"`
+}
+
+// GitSpec defines how dumps are pushed to the Git repository
+// +kubebuilder:validation:XValidation:rule="!has(self.mode) || self.mode != 'pullRequest' || has(self.pullRequest)",message="pullRequest is required by the pullRequest mode"
+type GitSpec struct {
+	// Branch is the branch dumps are pushed to and restored from
+	// It is created as an orphan branch when it doesn't exist on the remote yet
+	// In pullRequest mode it is the base branch of pull requests and must exist
+	// If empty, the remote's default branch is used
+	// +optional
+	Branch string `json:"branch,omitempty"`
+
+	// Mode is push to push dumps to Branch, or pullRequest to push them to a branch of their own and
+	// propose them in a pull request against Branch. Defaults to push
+	// +kubebuilder:default=push
+	// +optional
+	Mode GitMode `json:"mode,omitempty"`
+
+	// Shallow fetches only the last commit of branches instead of their whole history
+	// Restores from a commit SHA then only find commits at the head of a branch or tagged
+	// +optional
+	Shallow bool `json:"shallow,omitempty"`
+
+	// SparseCheckout checks out only DatabaseDumpPath, leaving the rest of the repository untouched
+	// +optional
+	SparseCheckout bool `json:"sparseCheckout,omitempty"`
+
+	// PullRequest configures the pull requests opened in pullRequest mode
+	// +optional
+	PullRequest *PullRequestSpec `json:"pullRequest,omitempty"`
+
+	// Author is the author and committer of dump commits
+	// If empty, commits are authored by Ceviche DB Sync Operator <operator@example.com>
+	// +optional
+	Author *GitAuthor `json:"author,omitempty"`
+
+	// CommitMessage is a Go text/template rendering the message of dump commits, with the variables
+	// .Namespace, .Name, .Database, .Trigger, .Format, .Time, .SchemaChanged, .ChangedTables and .RowCounts
+	// If empty, the message lists the changed tables
+	// +optional
+	CommitMessage string `json:"commitMessage,omitempty"`
+
+	// Trailers are appended to dump commit messages as "Key: value" lines
+	// Values are templates with the same variables as CommitMessage
+	// +listType=map
+	// +listMapKey=key
+	// +optional
+	Trailers []GitTrailer `json:"trailers,omitempty"`
+
+	// Signing signs dump commits, for repositories that require signed commits
+	// +optional
+	Signing *CommitSigningSpec `json:"signing,omitempty"`
+
+	// Lock makes dumps hold a lock on Branch shared with other operator replicas, Jobs and clusters, so
+	// their pushes to it take turns. Dumps of one operator to the same repository and branch always do
+	// +optional
+	Lock *GitLockSpec `json:"lock,omitempty"`
+}
+
+// GitLockType is where the lock on a branch is kept
+// +kubebuilder:validation:Enum=lease;ref
+type GitLockType string
+
+const (
+	// GitLockTypeLease locks the branch with a Lease in the namespace of the PostgresSync
+	GitLockTypeLease GitLockType = "lease"
+
+	// GitLockTypeRef locks the branch with a ref pushed to the remote, refs/cevichedbsync/locks/<branch>
+	GitLockTypeRef GitLockType = "ref"
+)
+
+// GitLockSpec configures the lock dumps hold on the branch while writing to it
+type GitLockSpec struct {
+	// Type is lease, for writers in the same namespace of one cluster, or ref, for writers anywhere
+	// that can push to the repository. Defaults to lease
+	// +kubebuilder:default=lease
+	// +optional
+	Type GitLockType `json:"type,omitempty"`
+
+	// Duration is how long a lock lasts unless renewed, after which a holder that died loses it
+	// Holders renew their lock every third of it. Defaults to 2m
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+}
+
+// CommitSigningType is the kind of key dump commits are signed with
+// +kubebuilder:validation:Enum=openpgp;ssh
+type CommitSigningType string
+
+const (
+	// CommitSigningTypeOpenPGP signs commits with an OpenPGP key, like git commit -S
+	CommitSigningTypeOpenPGP CommitSigningType = "openpgp"
+
+	// CommitSigningTypeSSH signs commits with an SSH key, like git with gpg.format=ssh
+	CommitSigningTypeSSH CommitSigningType = "ssh"
+)
+
+// CommitSigningSpec configures how dump commits are signed
+type CommitSigningSpec struct {
+	// Type is openpgp or ssh. Defaults to openpgp
+	// +kubebuilder:default=openpgp
+	// +optional
+	Type CommitSigningType `json:"type,omitempty"`
+
+	// Key references the signing key: an armored OpenPGP private key or an OpenSSH private key. A key
+	// can be protected by a passphrase stored under the "passphrase" key of the same Secret
+	// For hosts to show commits as verified, the key must belong to the commit author's account
+	Key SecretKeyReference `json:"key"`
+}
+
+// GitMode is how dumps reach the branch of the repository
+// +kubebuilder:validation:Enum=push;pullRequest
+type GitMode string
+
+const (
+	// GitModePush pushes dump commits straight to the branch
+	GitModePush GitMode = "push"
+
+	// GitModePullRequest proposes dump commits in a pull request
+	GitModePullRequest GitMode = "pullRequest"
+)
+
+// GitProvider is the Git hosting service pull requests are opened on
+// +kubebuilder:validation:Enum=github;gitlab;gitea
+type GitProvider string
+
+const (
+	// GitProviderGitHub opens pull requests on GitHub or GitHub Enterprise
+	GitProviderGitHub GitProvider = "github"
+
+	// GitProviderGitLab opens merge requests on GitLab
+	GitProviderGitLab GitProvider = "gitlab"
+
+	// GitProviderGitea opens pull requests on Gitea or Forgejo
+	GitProviderGitea GitProvider = "gitea"
+)
+
+// PullRequestSpec configures the pull (or merge) requests dumps are proposed in
+type PullRequestSpec struct {
+	// Provider is the hosting service whose REST API opens the pull request: github, gitlab or gitea
+	Provider GitProvider `json:"provider"`
+
+	// APIURL is the base URL of the REST API. If empty, it is derived from the repository host:
+	// https://api.github.com for github.com, https://<host>/api/v3 for GitHub Enterprise,
+	// https://<host>/api/v4 for GitLab and https://<host>/api/v1 for Gitea
+	// +optional
+	APIURL string `json:"apiURL,omitempty"`
+
+	// Token references the API token. If empty, the password of the Git credentials is used
+	// +optional
+	Token *SecretKeyReference `json:"token,omitempty"`
+
+	// BranchPrefix prefixes the branch dumps are pushed to, followed by <namespace>/<name>
+	// The branch is replaced by every dump, so an open pull request is updated instead of duplicated
+	// +kubebuilder:default="cevichedbsync/"
+	// +optional
+	BranchPrefix string `json:"branchPrefix,omitempty"`
+
+	// Title is a template rendering the pull request title, with the variables of Git.CommitMessage
+	// +kubebuilder:default="Update database dump of {{ .Namespace }}/{{ .Name }}"
+	// +optional
+	Title string `json:"title,omitempty"`
+
+	// Body is a template rendering the pull request description. If empty, the commit message is used
+	// +optional
+	Body string `json:"body,omitempty"`
+
+	// Labels are added to the pull request
+	// +optional
+	Labels []string `json:"labels,omitempty"`
+
+	// AutoMerge merges the pull request once its checks pass
+	// +optional
+	AutoMerge bool `json:"autoMerge,omitempty"`
+}
+
+// GitAuthor identifies the author of dump commits
+type GitAuthor struct {
+	// Name of the author
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Email of the author
+	// +kubebuilder:validation:MinLength=1
+	Email string `json:"email"`
+}
+
+// GitTrailer is a trailer of dump commit messages, such as Triggered-By
+type GitTrailer struct {
+	// Key is the trailer token
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9][A-Za-z0-9-]*$`
+	Key string `json:"key"`
+
+	// Value is a template rendering the single line value of the trailer
+	// +kubebuilder:validation:MinLength=1
+	Value string `json:"value"`
+}
+
+// StorageSpec configures where dumps are kept instead of the Git repository. Each dump is stored under
+// databaseDumpPath, in a directory named after the time it was taken, which restores use as their ref.
+// OCI registries name dumps by tag or digest instead
+// +kubebuilder:validation:XValidation:rule="(has(self.s3) ? 1 : 0) + (has(self.pvc) ? 1 : 0) + (has(self.oci) ? 1 : 0) == 1",message="exactly one storage must be set"
+// +kubebuilder:validation:XValidation:rule="!has(self.oci) || !has(self.keep)",message="keep isn't supported by the oci storage, expire tags with the retention policy of the registry instead"
+type StorageSpec struct {
+	// S3 keeps dumps in a bucket of an S3 compatible object storage, such as AWS S3, MinIO or Ceph
+	// +optional
+	S3 *S3StorageSpec `json:"s3,omitempty"`
+
+	// PVC keeps dumps in a PersistentVolumeClaim, for clusters without a Git server or an object storage
+	// +optional
+	PVC *PVCStorageSpec `json:"pvc,omitempty"`
+
+	// OCI pushes dumps as artifacts to a repository of an OCI registry. Dumps are named by their tag or digest
+	// instead of the time they were taken
+	// +optional
+	OCI *OCIStorageSpec `json:"oci,omitempty"`
+
+	// Keep is how many dumps are kept, older dumps are deleted once a new dump is stored
+	// If unset, every dump is kept
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Keep *int32 `json:"keep,omitempty"`
+}
+
+// S3StorageSpec defines the bucket dumps are kept in
+type S3StorageSpec struct {
+	// Endpoint is the host, with an optional port, of the S3 API, such as s3.amazonaws.com
+	// It is reached over HTTPS unless prefixed with http://
+	Endpoint string `json:"endpoint"`
+
+	// Region of the bucket, looked up when unset
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// Bucket is the name of the bucket
+	Bucket string `json:"bucket"`
+
+	// Prefix is prepended to the keys of the dumps, before databaseDumpPath
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Credentials references a Secret holding the "accessKeyID" and "secretAccessKey" keys, and optionally
+	// a "sessionToken". It may only be left unset when the operator runs with --s3-ambient-credentials,
+	// credentials are then taken from the environment or the instance metadata
+	// +optional
+	Credentials *CredentialReference `json:"credentials,omitempty"`
+
+	// ServerSideEncryption asks the storage to encrypt the dumps it stores
+	// +optional
+	ServerSideEncryption *S3ServerSideEncryption `json:"serverSideEncryption,omitempty"`
+
+	// PathStyle addresses the bucket in the path of requests instead of the host name, as MinIO and Ceph
+	// usually require
+	// +optional
+	PathStyle bool `json:"pathStyle,omitempty"`
+}
+
+// PVCStorageSpec defines the PersistentVolumeClaim dumps are kept in. Jobs mount the claim themselves, while
+// the operator only reaches claims mounted under its --volume-dir, at <volume-dir>/<claimName>
+type PVCStorageSpec struct {
+	// ClaimName is the name of the PersistentVolumeClaim, in the namespace of the PostgresSync
+	// +kubebuilder:validation:MinLength=1
+	ClaimName string `json:"claimName"`
+
+	// SubPath is the directory of the volume dumps are kept in, before databaseDumpPath
+	// +optional
+	SubPath string `json:"subPath,omitempty"`
+}
+
+// OCIStorageSpec defines the repository of an OCI registry dumps are pushed to. Each dump is an artifact whose
+// layers are the files of the dump, annotated with the database, the time it was taken and its checksum
+type OCIStorageSpec struct {
+	// Repository is the repository dumps are pushed to, without tag, such as registry.example.com/dumps/orders
+	// +kubebuilder:validation:MinLength=1
+	Repository string `json:"repository"`
+
+	// Tag is a Go template of the tag each dump is pushed with, given the same data as commit messages, such as
+	// {{ .Database }}-{{ .Time.Format "20060102" }}. Characters tags can't hold are replaced with "-"
+	// If unset, dumps are tagged with the time they were taken, such as 20261018T030000Z. The latest dump is
+	// also tagged "latest.<namespace>.<name>", which restores use unless told otherwise
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// Credentials references a kubernetes.io/dockerconfigjson Secret holding the credentials of the registry
+	// If unset, the registry is reached anonymously
+	// +optional
+	Credentials *CredentialReference `json:"credentials,omitempty"`
+
+	// PlainHTTP reaches the registry over HTTP instead of HTTPS, such as a local registry:2
+	// +optional
+	PlainHTTP bool `json:"plainHTTP,omitempty"`
+}
+
+// S3EncryptionType is the server-side encryption of stored dumps
+// +kubebuilder:validation:Enum=AES256;"aws:kms"
+type S3EncryptionType string
+
+const (
+	// S3EncryptionAES256 encrypts dumps with keys managed by the storage
+	S3EncryptionAES256 S3EncryptionType = "AES256"
+
+	// S3EncryptionKMS encrypts dumps with a key of the key management service
+	S3EncryptionKMS S3EncryptionType = "aws:kms"
+)
+
+// S3ServerSideEncryption defines how the storage encrypts stored dumps
+// +kubebuilder:validation:XValidation:rule="!has(self.kmsKeyID) || self.type == 'aws:kms'",message="kmsKeyID is only used by the aws:kms type"
+type S3ServerSideEncryption struct {
+	// Type is the encryption applied by the storage
+	Type S3EncryptionType `json:"type"`
+
+	// KMSKeyID is the key dumps are encrypted with by the aws:kms type, the default key of the account
+	// when unset
+	// +optional
+	KMSKeyID string `json:"kmsKeyID,omitempty"`
+}
+
+// DumpFormat is the pg_dump output format
+// +kubebuilder:validation:Enum=plain;custom;directory;tar
+type DumpFormat string
+
+const (
+	// DumpFormatPlain is a plain SQL script stored as dump.sql and restored with psql
+	DumpFormatPlain DumpFormat = "plain"
+
+	// DumpFormatCustom is a pg_dump custom archive stored as dump.dump and restored with pg_restore
+	DumpFormatCustom DumpFormat = "custom"
+
+	// DumpFormatDirectory is a pg_dump directory archive stored as dump/ and restored with pg_restore
+	DumpFormatDirectory DumpFormat = "directory"
+
+	// DumpFormatTar is a pg_dump tar archive stored as dump.tar and restored with pg_restore
+	DumpFormatTar DumpFormat = "tar"
+)
+
+// DumpCompression is the compression applied to dumps
+// +kubebuilder:validation:Enum=none;gzip;zstd
+type DumpCompression string
+
+const (
+	// DumpCompressionNone stores dumps uncompressed
+	DumpCompressionNone DumpCompression = "none"
+
+	// DumpCompressionGzip compresses dumps with gzip
+	DumpCompressionGzip DumpCompression = "gzip"
+
+	// DumpCompressionZstd compresses dumps with zstd
+	DumpCompressionZstd DumpCompression = "zstd"
+)
+
+// DumpSpec defines how the database is dumped
+// +kubebuilder:validation:XValidation:rule="!has(self.compressionLevel) || (has(self.compression) && self.compression == 'gzip' && self.compressionLevel <= 9) || (has(self.compression) && self.compression == 'zstd' && self.compressionLevel <= 22)",message="compressionLevel must be 1-9 for gzip and 1-22 for zstd"
+// +kubebuilder:validation:XValidation:rule="!has(self.masking) || !has(self.format) || self.format == 'plain'",message="masking is only supported with the plain format"
+// +kubebuilder:validation:XValidation:rule="!has(self.layout) || self.layout == 'single' || ((!has(self.format) || self.format == 'plain') && (!has(self.compression) || self.compression == 'none') && !has(self.encryption))",message="the perTable layout requires the plain format without compression or encryption"
+type DumpSpec struct {
+	// Format is the pg_dump output format. Defaults to plain
+	// +kubebuilder:default=plain
+	// +optional
+	Format DumpFormat `json:"format,omitempty"`
+
+	// Layout is how the dump is laid out in the repository. Defaults to single
+	// +kubebuilder:default=single
+	// +optional
+	Layout DumpLayout `json:"layout,omitempty"`
+
+	// Jobs is the number of tables pg_dump dumps in parallel, only used by the directory format
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Jobs *int32 `json:"jobs,omitempty"`
+
+	// Compression compresses dumps. Plain and tar dumps are stored as dump.sql.gz, dump.tar.zst...
+	// while custom and directory dumps are compressed by pg_dump itself, zstd needs pg_dump 16 or later
+	// If empty, plain and tar dumps are stored uncompressed and pg_dump's defaults apply to the others
+	// +optional
+	Compression DumpCompression `json:"compression,omitempty"`
+
+	// CompressionLevel is the compression level, 1-9 for gzip and 1-22 for zstd
+	// If empty, the default level of the algorithm is used
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	CompressionLevel *int32 `json:"compressionLevel,omitempty"`
+
+	// Encryption encrypts dumps before they are pushed to the repository
+	// +optional
+	Encryption *EncryptionSpec `json:"encryption,omitempty"`
+
+	// Schemas only dumps the schemas matching these patterns. Patterns follow pg_dump: * and ? are
+	// wildcards, names are case-folded unless double quoted
+	// +kubebuilder:validation:items:MinLength=1
+	// +optional
+	Schemas []string `json:"schemas,omitempty"`
+
+	// ExcludeSchemas skips the schemas matching these patterns
+	// +kubebuilder:validation:items:MinLength=1
+	// +optional
+	ExcludeSchemas []string `json:"excludeSchemas,omitempty"`
+
+	// IncludeTables only dumps the tables matching these patterns, optionally qualified as schema.table
+	// +kubebuilder:validation:items:MinLength=1
+	// +optional
+	IncludeTables []string `json:"includeTables,omitempty"`
+
+	// ExcludeTables skips the tables matching these patterns
+	// +kubebuilder:validation:items:MinLength=1
+	// +optional
+	ExcludeTables []string `json:"excludeTables,omitempty"`
+
+	// ExcludeTableData dumps the definition of the tables matching these patterns but not their rows,
+	// e.g. audit logs or sessions
+	// +kubebuilder:validation:items:MinLength=1
+	// +optional
+	ExcludeTableData []string `json:"excludeTableData,omitempty"`
+
+	// Masking rewrites column values of the dump before it is committed, e.g. to seed staging from
+	// production. Only supported with the plain format
+	// +optional
+	Masking *MaskingSpec `json:"masking,omitempty"`
+}
+
+// DumpLayout describes how a dump is laid out in the repository
+// +kubebuilder:validation:Enum=single;perTable
+type DumpLayout string
+
+const (
+	// DumpLayoutSingle stores the dump as a single file, or directory for the directory format
+	DumpLayoutSingle DumpLayout = "single"
+
+	// DumpLayoutPerTable stores the schema as SQL and the rows of each table, ordered by primary key, in
+	// a COPY file of their own, so changed rows show up as small diffs
+	DumpLayoutPerTable DumpLayout = "perTable"
+)
+
+// MaskingStrategy is how a masked column value is replaced
+// +kubebuilder:validation:Enum=null;constant;hash;pseudonymize;fakeEmail;fakeName
+type MaskingStrategy string
+
+const (
+	// MaskingStrategyNull replaces values with NULL
+	MaskingStrategyNull MaskingStrategy = "null"
+
+	// MaskingStrategyConstant replaces values with a constant
+	MaskingStrategyConstant MaskingStrategy = "constant"
+
+	// MaskingStrategyHash replaces values with their hex encoded HMAC-SHA256
+	MaskingStrategyHash MaskingStrategy = "hash"
+
+	// MaskingStrategyPseudonymize replaces each letter and digit with a pseudorandom one derived from the
+	// salted value, keeping length and character classes so equal values stay equal across tables
+	MaskingStrategyPseudonymize MaskingStrategy = "pseudonymize"
+
+	// MaskingStrategyFakeEmail replaces values with a fake email address derived from the value
+	MaskingStrategyFakeEmail MaskingStrategy = "fakeEmail"
+
+	// MaskingStrategyFakeName replaces values with a fake full name derived from the value
+	MaskingStrategyFakeName MaskingStrategy = "fakeName"
+)
+
+// MaskingSpec defines how column values are masked in dumps
+// +kubebuilder:validation:XValidation:rule="!self.rules.exists(r, r.strategy in ['hash', 'pseudonymize', 'fakeEmail', 'fakeName']) || has(self.salt)",message="salt is required by the hash, pseudonymize, fakeEmail and fakeName strategies"
+type MaskingSpec struct {
+	// Salt references the secret key values are hashed with by the hash, pseudonymize and fake strategies
+	// Required by them, as without it hashes of guessable values such as emails can be reversed
+	// +optional
+	Salt *SecretKeyReference `json:"salt,omitempty"`
+
+	// Rules lists the masked columns
+	// +kubebuilder:validation:MinItems=1
+	Rules []MaskingRule `json:"rules"`
+}
+
+// MaskingRule masks a column of a table
+// +kubebuilder:validation:XValidation:rule="self.strategy != 'constant' || has(self.value)",message="value is required by the constant strategy"
+type MaskingRule struct {
+	// Table is the masked table, qualified as schema.table. Unqualified names are looked up in public
+	// +kubebuilder:validation:MinLength=1
+	Table string `json:"table"`
+
+	// Column is the masked column, named as stored in the database
+	// +kubebuilder:validation:MinLength=1
+	Column string `json:"column"`
+
+	// Strategy is how values are replaced. NULL values are kept by all strategies but constant
+	Strategy MaskingStrategy `json:"strategy"`
+
+	// Value is the replacement of the constant strategy
+	// +optional
+	Value *string `json:"value,omitempty"`
+}
+
+// EncryptionType is the tool dumps are encrypted for
+// +kubebuilder:validation:Enum=age;openpgp
+type EncryptionType string
+
+const (
+	// EncryptionTypeAge encrypts dumps with age, stored with a .age extension
+	EncryptionTypeAge EncryptionType = "age"
+
+	// EncryptionTypeOpenPGP encrypts dumps with OpenPGP, stored with a .gpg extension
+	EncryptionTypeOpenPGP EncryptionType = "openpgp"
+)
+
+// EncryptionSpec defines how dumps are encrypted
+type EncryptionSpec struct {
+	// Type is age or openpgp. Defaults to age
+	// +kubebuilder:default=age
+	// +optional
+	Type EncryptionType `json:"type,omitempty"`
+
+	// Recipients references the public keys dumps are encrypted to: age recipients, one per line or
+	// comma separated as in .sops.yaml, or armored OpenPGP public keys
+	Recipients KeyReference `json:"recipients"`
+
+	// PrivateKey references the key dumps are decrypted with on restore: age identities as in a SOPS
+	// keys.txt, or an armored OpenPGP private key. An OpenPGP key can be protected by a passphrase stored
+	// under the "passphrase" key of the same Secret
+	// +optional
+	PrivateKey *SecretKeyReference `json:"privateKey,omitempty"`
+
+	// ChecksumKey references the secret the checksums stored next to encrypted dumps are keyed with, so they
+	// can't be used to confirm guesses about the data. Row counts aren't stored with encrypted dumps
+	ChecksumKey SecretKeyReference `json:"checksumKey"`
+}
+
+// KeyReference selects a key of a Secret or a ConfigMap
+// +kubebuilder:validation:XValidation:rule="has(self.secretName) != has(self.configMapName)",message="exactly one of secretName and configMapName must be set"
+type KeyReference struct {
+	// SecretName is the name of the Secret holding the key
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// ConfigMapName is the name of the ConfigMap holding the key
+	// +optional
+	ConfigMapName string `json:"configMapName,omitempty"`
+
+	// Key is the key within the Secret or ConfigMap
+	Key string `json:"key"`
+}
+
+// SecretKeyReference selects a key of a Secret
+type SecretKeyReference struct {
+	// SecretName is the name of the Secret holding the key
+	SecretName string `json:"secretName"`
+
+	// Key is the key within the Secret
+	Key string `json:"key"`
+}
+
+// RestoreSpec defines which dump is restored into the database
+type RestoreSpec struct {
+	// Ref is a branch, tag or commit SHA to restore the dump from, or the name, tag or digest of a dump kept in Storage
+	// If empty, the dump at the head of Git.Branch, or the latest dump in Storage, is restored
+	// +optional
+	Ref string `json:"ref,omitempty"`
+
+	// Jobs is the number of parallel pg_restore jobs, only used by the custom and directory formats
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Jobs *int32 `json:"jobs,omitempty"`
+}
+
+// RestoreRequest defines an on-demand restore
+type RestoreRequest struct {
+	// ID identifies the request and is reported back in Status.Operations
+	// If empty, the operator generates one
+	// +optional
+	ID string `json:"id,omitempty"`
+
+	// Ref is a branch, tag or commit SHA to restore the dump from, or the name, tag or digest of a dump kept in Storage
+	// If empty, Restore.Ref is used, then the head of Git.Branch or the latest dump in Storage
+	// +optional
+	Ref string `json:"ref,omitempty"`
 }
 
 // DatabaseServiceReference defines the service and namespace for database connection
@@ -53,8 +809,96 @@ type CredentialReference struct {
 	SecretName string `json:"secretName"`
 }
 
+// Condition types of a PostgresSync
+const (
+	// ConditionReady is True when the database is initialized and the last operation succeeded
+	ConditionReady = "Ready"
+
+	// ConditionDatabaseReachable is True when the last operation could talk to the database
+	ConditionDatabaseReachable = "DatabaseReachable"
+
+	// ConditionGitReachable is True when the last operation could talk to the Git repository
+	ConditionGitReachable = "GitReachable"
+
+	// ConditionStorageReachable is True when the last operation could talk to the storage dumps are kept in
+	// instead of the Git repository
+	ConditionStorageReachable = "StorageReachable"
+
+	// ConditionRestored is True once the database has been initialized from the repository
+	ConditionRestored = "Restored"
+
+	// ConditionLastDumpSucceeded reflects the outcome of the last dump
+	ConditionLastDumpSucceeded = "LastDumpSucceeded"
+
+	// ConditionMissedSchedule is True when the last evaluation of the schedule skipped scheduled dumps, because
+	// they were past the starting deadline or missed with the Skip policy
+	ConditionMissedSchedule = "MissedSchedule"
+)
+
+// Condition reasons of a PostgresSync
+const (
+	ReasonStatefulSetNotFound = "StatefulSetNotFound"
+	ReasonStatefulSetNotReady = "StatefulSetNotReady"
+	ReasonInvalidSchedule     = "InvalidSchedule"
+	ReasonJobRunning          = "JobRunning"
+	ReasonJobFailed           = "JobFailed"
+	ReasonDumpRestored        = "DumpRestored"
+	ReasonNoDumpFound         = "NoDumpFound"
+	ReasonRestoreFailed       = "RestoreFailed"
+	ReasonDumpSucceeded       = "DumpSucceeded"
+	ReasonDumpUnchanged       = "DumpUnchanged"
+	ReasonDumpFailed          = "DumpFailed"
+	ReasonConnected           = "Connected"
+	ReasonConnectionFailed    = "ConnectionFailed"
+	ReasonOnSchedule          = "OnSchedule"
+	ReasonDeadlineExceeded    = "StartingDeadlineExceeded"
+	ReasonMissedRunsSkipped   = "MissedRunsSkipped"
+)
+
+// OperationStatus reports the progress of an operation requested through the webhook
+type OperationStatus struct {
+	// ID identifies the operation
+	ID string `json:"id"`
+
+	// Type is the kind of operation, dump or restore
+	Type string `json:"type"`
+
+	// Phase is InProgress while the operation runs, then Succeeded or Failed
+	Phase string `json:"phase"`
+
+	// Message explains the outcome of the operation
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Job is the Job running the operation, empty when it runs inside the operator
+	// +optional
+	Job string `json:"job,omitempty"`
+
+	// Dump is the PostgresSyncDump recording a dump operation
+	// +optional
+	Dump string `json:"dump,omitempty"`
+
+	// StartTime is when the operation started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the operation finished
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
 // PostgresSyncStatus defines the observed state of PostgresSync
 type PostgresSyncStatus struct {
+	// ObservedGeneration is the generation of the spec this status reflects
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe the current state of the PostgresSync
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
 	// Phase shows the current phase of the PostgresSync operation
 	Phase string `json:"phase,omitempty"`
 
@@ -64,13 +908,42 @@ type PostgresSyncStatus struct {
 	// LastSyncTime is the timestamp of the last successful dump
 	// +optional
 	LastSyncTime metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// LastScheduledTime is the scheduled time of the last run evaluated from Spec.Schedule
+	// +optional
+	LastScheduledTime *metav1.Time `json:"lastScheduledTime,omitempty"`
+
+	// NextScheduledTime is when the next scheduled dump will be taken, including jitter
+	// +optional
+	NextScheduledTime *metav1.Time `json:"nextScheduledTime,omitempty"`
+
+	// ActiveJob is the name of the Job currently running a dump or restore
+	// +optional
+	ActiveJob string `json:"activeJob,omitempty"`
+
+	// PullRequestURL is the pull request the last changed dump was proposed in, in pullRequest mode
+	// +optional
+	PullRequestURL string `json:"pullRequestURL,omitempty"`
+
+	// LastDump is the name of the PostgresSyncDump recording the last dump run
+	// +optional
+	LastDump string `json:"lastDump,omitempty"`
+
+	// Operations reports the most recent operations requested through the webhook or Spec.RestoreRequest
+	// +listType=map
+	// +listMapKey=id
+	// +optional
+	Operations []OperationStatus `json:"operations,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
 // +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
 // +kubebuilder:printcolumn:name="Message",type="string",JSONPath=".status.message"
 // +kubebuilder:printcolumn:name="Last Sync",type="date",JSONPath=".status.lastSyncTime"
+// +kubebuilder:printcolumn:name="Last Scheduled",type="date",JSONPath=".status.lastScheduledTime"
+// +kubebuilder:printcolumn:name="Next Scheduled",type="date",JSONPath=".status.nextScheduledTime"
 
 // PostgresSync is the Schema for the postgressyncs API
 type PostgresSync struct {
@@ -0,0 +1,106 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DumpTrigger describes what started a dump
+// +kubebuilder:validation:Enum=Webhook;Schedule
+type DumpTrigger string
+
+const (
+	// DumpTriggerWebhook is a dump requested through the webhook or spec.dumpOnWebhook
+	DumpTriggerWebhook DumpTrigger = "Webhook"
+
+	// DumpTriggerSchedule is a dump taken by spec.schedule
+	DumpTriggerSchedule DumpTrigger = "Schedule"
+)
+
+// PostgresSyncDumpSpec identifies the dump run recorded by a PostgresSyncDump
+type PostgresSyncDumpSpec struct {
+	// PostgresSyncName is the name of the PostgresSync the dump was taken for
+	PostgresSyncName string `json:"postgresSyncName"`
+
+	// Trigger is what started the dump
+	Trigger DumpTrigger `json:"trigger"`
+
+	// JobName is the Job running the dump, empty when the dump ran inside the operator
+	// +optional
+	JobName string `json:"jobName,omitempty"`
+}
+
+// PostgresSyncDumpStatus records the outcome of a dump run
+type PostgresSyncDumpStatus struct {
+	// Phase is InProgress while the dump runs, then Succeeded, Failed, or Unchanged when the dump
+	// matched the one already in the repository and nothing was committed
+	Phase string `json:"phase,omitempty"`
+
+	// StartTime is when the dump started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the dump finished
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Duration is how long the dump took
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// Size is the size of the dump in bytes
+	// +optional
+	Size int64 `json:"size,omitempty"`
+
+	// CommitSHA is the commit the dump was pushed in, it can be used as spec.restore.ref
+	// of the PostgresSync to restore this dump
+	// +optional
+	CommitSHA string `json:"commitSHA,omitempty"`
+
+	// Location is where the dump is stored, as <repositoryURL>#<branch>:<path>
+	// +optional
+	Location string `json:"location,omitempty"`
+
+	// PullRequestURL is the pull request the dump was proposed in, in pullRequest mode
+	// +optional
+	PullRequestURL string `json:"pullRequestURL,omitempty"`
+
+	// ChangedTables lists the tables whose rows changed since the previous dump, up to 50
+	// +optional
+	ChangedTables []string `json:"changedTables,omitempty"`
+
+	// Error is why the dump failed
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Sync",type="string",JSONPath=".spec.postgresSyncName"
+// +kubebuilder:printcolumn:name="Trigger",type="string",JSONPath=".spec.trigger"
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Commit",type="string",JSONPath=".status.commitSHA"
+// +kubebuilder:printcolumn:name="Size",type="integer",JSONPath=".status.size"
+// +kubebuilder:printcolumn:name="Duration",type="string",JSONPath=".status.duration"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// PostgresSyncDump records a single dump run of a PostgresSync
+type PostgresSyncDump struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PostgresSyncDumpSpec   `json:"spec,omitempty"`
+	Status PostgresSyncDumpStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// PostgresSyncDumpList contains a list of PostgresSyncDump
+type PostgresSyncDumpList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PostgresSyncDump `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PostgresSyncDump{}, &PostgresSyncDumpList{})
+}
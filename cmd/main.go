@@ -19,6 +19,9 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
+	// Embed the time zone database, the runtime image has none for the time zones of schedules
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -26,9 +29,11 @@ import (
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -43,6 +48,9 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 )
 
+// terminationMessagePath is where Jobs report the result of --run-operation
+const terminationMessagePath = "/dev/termination-log"
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
@@ -55,10 +63,34 @@ func main() {
 	var enableLeaderElection bool
 	var probeAddr string
 	var webhookAddr string
+	var jobImage string
+	var gitCacheDir string
+	var volumeDir string
+	var ambientS3Credentials bool
+	var runOperation string
+	var postgresSync string
+	var restoreRef string
+	var dumpTrigger string
 
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.StringVar(&webhookAddr, "webhook-bind-address", ":8082", "The address the webhook endpoint binds to.")
+	flag.StringVar(&jobImage, "job-image", os.Getenv("JOB_IMAGE"),
+		"The default image of the Jobs running dumps and restores in Job execution mode.")
+	flag.StringVar(&gitCacheDir, "git-cache-dir", os.Getenv("GIT_CACHE_DIR"),
+		"The directory clones of Git repositories are kept in between dumps and restores. "+
+			"If empty, every dump and restore clones the repository again.")
+	flag.StringVar(&volumeDir, "volume-dir", os.Getenv("VOLUME_DIR"),
+		"The directory PersistentVolumeClaims of PVC storages are mounted in, each under its claim name. "+
+			"If empty, PVC storages are only reachable in Job execution mode.")
+	flag.BoolVar(&ambientS3Credentials, "s3-ambient-credentials", false,
+		"Let S3 storages without a credentials Secret use the environment and IAM role of the operator or Job pod. "+
+			"Any PostgresSync can then reach every bucket of that role.")
+	flag.StringVar(&runOperation, "run-operation", "",
+		"Run a single dump or restore of --postgressync and exit instead of starting the manager. Used by Jobs.")
+	flag.StringVar(&postgresSync, "postgressync", "", "The PostgresSync, as namespace/name, --run-operation applies to.")
+	flag.StringVar(&restoreRef, "ref", "", "The Git ref a restore run with --run-operation is taken from.")
+	flag.StringVar(&dumpTrigger, "trigger", "", "What started a dump run with --run-operation, used in commit messages.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -71,6 +103,10 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if runOperation != "" {
+		os.Exit(runSingleOperation(runOperation, postgresSync, restoreRef, dumpTrigger, volumeDir, ambientS3Credentials))
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
@@ -93,8 +129,13 @@ func main() {
 	}()
 
 	if err = (&controller.PostgresSyncReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:               mgr.GetClient(),
+		Scheme:               mgr.GetScheme(),
+		APIReader:            mgr.GetAPIReader(),
+		JobImage:             jobImage,
+		GitCacheDir:          gitCacheDir,
+		VolumeDir:            volumeDir,
+		AmbientS3Credentials: ambientS3Credentials,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "PostgresSync")
 		os.Exit(1)
@@ -116,3 +157,44 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// runSingleOperation runs a dump or restore of a single PostgresSync, reporting the result through
+// the container termination message, and returns the process exit code
+func runSingleOperation(operation, postgresSync, ref, trigger, volumeDir string, ambientS3Credentials bool) int {
+	namespace, name, found := strings.Cut(postgresSync, "/")
+	if !found || namespace == "" || name == "" {
+		setupLog.Error(nil, "--postgressync must be set as namespace/name", "postgressync", postgresSync)
+		return 1
+	}
+
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		return 1
+	}
+
+	reconciler := &controller.PostgresSyncReconciler{
+		Client:               c,
+		Scheme:               scheme,
+		VolumeDir:            volumeDir,
+		AmbientS3Credentials: ambientS3Credentials,
+	}
+	message, err := reconciler.RunOperation(ctrl.SetupSignalHandler(), types.NamespacedName{
+		Namespace: namespace,
+		Name:      name,
+	}, operation, ref, trigger)
+	if err != nil {
+		message = err.Error()
+	}
+
+	if writeErr := os.WriteFile(terminationMessagePath, []byte(message), 0644); writeErr != nil {
+		setupLog.Error(writeErr, "unable to write termination message")
+	}
+
+	if err != nil {
+		setupLog.Error(err, "operation failed", "operation", operation, "postgressync", postgresSync)
+		return 1
+	}
+	setupLog.Info(message, "operation", operation, "postgressync", postgresSync)
+	return 0
+}
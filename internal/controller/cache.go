@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// checkoutRepository checks out the branch of a PostgresSync's repository and returns the worktree with
+// a function releasing it once done. With a cache directory, the worktree is the cached clone of the
+// repository, locked until released, otherwise a temporary clone removed on release
+func (r *PostgresSyncReconciler) checkoutRepository(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, auth transport.AuthMethod) (string, func(), error) {
+	logger := log.FromContext(ctx)
+	options := repositoryCloneOptions(pgSync)
+
+	if r.GitCacheDir == "" {
+		repoDir, err := r.cloneRepository(pgSync.Spec.RepositoryURL, gitBranch(pgSync), auth, options)
+		if err != nil {
+			return "", nil, err
+		}
+		return repoDir, func() {
+			if err := os.RemoveAll(repoDir); err != nil {
+				logger.Error(err, "Failed to remove repo directory")
+			}
+		}, nil
+	}
+
+	repoDir := filepath.Join(r.GitCacheDir, repositoryCacheKey(pgSync.Namespace, pgSync.Spec.RepositoryURL, options.Shallow))
+	lock := r.repositoryLock(repoDir)
+	lock.Lock()
+
+	err := updateCachedRepository(repoDir, pgSync.Spec.RepositoryURL, gitBranch(pgSync), auth, options)
+	var remoteErr remoteUpdateError
+	if errors.As(err, &remoteErr) {
+		// The clone is fine, the remote couldn't be reached or refused the credentials
+		lock.Unlock()
+		return "", nil, fmt.Errorf("failed to update repository: %w", remoteErr.error)
+	}
+	if err != nil {
+		// Start over from a fresh clone, the cache may be missing or broken
+		if !errors.Is(err, git.ErrRepositoryNotExists) {
+			logger.Info("Cloning cached repository again", "path", repoDir, "reason", err.Error())
+		}
+		if err = os.RemoveAll(repoDir); err == nil {
+			err = cloneInto(repoDir, pgSync.Spec.RepositoryURL, gitBranch(pgSync), auth, options)
+		}
+	}
+	if err != nil {
+		_ = os.RemoveAll(repoDir)
+		lock.Unlock()
+		return "", nil, fmt.Errorf("failed to clone repository: %w", err)
+	}
+
+	return repoDir, lock.Unlock, nil
+}
+
+// repositoryCacheKey names the cache directory of a repository for the PostgresSyncs of a namespace, which
+// share credentials. Shallow clones have their own as fetches into them never bring the history back
+func repositoryCacheKey(namespace, repoURL string, shallow bool) string {
+	sum := sha256.Sum256([]byte(namespace + "\x00" + repoURL))
+	key := hex.EncodeToString(sum[:8])
+	if shallow {
+		key += "-shallow"
+	}
+	return key
+}
+
+// repositoryLock returns the lock serializing the operations using the cached clone in repoDir
+func (r *PostgresSyncReconciler) repositoryLock(repoDir string) *sync.Mutex {
+	lock, _ := r.repositoryLocks.LoadOrStore(repoDir, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// remoteUpdateError marks errors talking to the remote while updating a cached clone, which leave the clone intact
+type remoteUpdateError struct{ error }
+
+func (e remoteUpdateError) Unwrap() error { return e.error }
+
+// isTransportError tells whether err comes from reaching the remote or being refused by it
+func isTransportError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, transport.ErrAuthenticationRequired) ||
+		errors.Is(err, transport.ErrAuthorizationFailed) ||
+		errors.Is(err, transport.ErrRepositoryNotFound) ||
+		errors.Is(err, transport.ErrInvalidAuthMethod) ||
+		errors.As(err, &netErr)
+}
+
+// updateCachedRepository fetches the branch into the cached clone in dir and checks it out as the remote has
+// it, dropping whatever an earlier operation left behind
+func updateCachedRepository(dir, repoURL, branch string, auth transport.AuthMethod, options cloneOptions) error {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+
+	branch, branchExists, err := resolveRemoteBranch(repoURL, branch, auth)
+	if err != nil {
+		return remoteUpdateError{err}
+	}
+	if err := writeSparseCheckout(dir, options.SparseDirs); err != nil {
+		return err
+	}
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if !branchExists {
+		if branch == "" {
+			return fmt.Errorf("remote repository has no default branch")
+		}
+		return resetOrphanBranch(repo, dir, branchRef)
+	}
+
+	remoteRef := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branch)
+	err = repo.Fetch(&git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", branchRef, remoteRef))},
+		Depth:      fetchDepth(repo),
+		Auth:       auth,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		err = fmt.Errorf("failed to fetch branch %s: %w", branch, err)
+		if isTransportError(err) {
+			return remoteUpdateError{err}
+		}
+		return err
+	}
+	remoteHead, err := repo.Reference(remoteRef, true)
+	if err != nil {
+		return fmt.Errorf("failed to resolve remote branch %s: %w", branch, err)
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(branchRef, remoteHead.Hash())); err != nil {
+		return fmt.Errorf("failed to update branch %s: %w", branch, err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branchRef)); err != nil {
+		return fmt.Errorf("failed to point HEAD to branch %s: %w", branch, err)
+	}
+	return checkoutTree(repo, remoteHead.Hash(), options.SparseDirs)
+}
+
+// resetOrphanBranch points HEAD of the cached clone in dir to a branch without history and empties the
+// worktree, like initOrphanBranch does for fresh clones
+func resetOrphanBranch(repo *git.Repository, dir string, branchRef plumbing.ReferenceName) error {
+	if err := repo.Storer.RemoveReference(branchRef); err != nil {
+		return fmt.Errorf("failed to remove branch %s: %w", branchRef.Short(), err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branchRef)); err != nil {
+		return fmt.Errorf("failed to point HEAD to branch %s: %w", branchRef.Short(), err)
+	}
+	if err := repo.Storer.SetIndex(&index.Index{Version: 2}); err != nil {
+		return fmt.Errorf("failed to reset index: %w", err)
+	}
+	return clearWorktree(dir)
+}
@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Repository cache", func() {
+	ctx := context.Background()
+	author := commitAuthor(&migrationsv1alpha1.PostgresSync{})
+	var remoteDir string
+	var reconciler *PostgresSyncReconciler
+
+	BeforeEach(func() {
+		remoteDir = GinkgoT().TempDir()
+		_, err := git.PlainInit(remoteDir, true)
+		Expect(err).NotTo(HaveOccurred())
+		reconciler = &PostgresSyncReconciler{GitCacheDir: GinkgoT().TempDir()}
+
+		// Someone else maintains the rest of the repository
+		repoDir, err := reconciler.cloneRepository(remoteDir, "main", nil, cloneOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, repoDir)
+		Expect(os.MkdirAll(filepath.Join(repoDir, "dumps"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("readme"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(repoDir, "dumps", "orders.sql"), []byte("orders"), 0644)).To(Succeed())
+		Expect(reconciler.commitAndPushChanges(repoDir, nil, dumpCommit{Branch: "main", Author: author, Message: "Initial commit"})).To(Succeed())
+	})
+
+	postgresSync := func(branch string) *migrationsv1alpha1.PostgresSync {
+		return &migrationsv1alpha1.PostgresSync{Spec: migrationsv1alpha1.PostgresSyncSpec{
+			RepositoryURL:    remoteDir,
+			DatabaseDumpPath: "dumps",
+			Git:              &migrationsv1alpha1.GitSpec{Branch: branch, Shallow: true, SparseCheckout: true},
+		}}
+	}
+
+	remoteFiles := func(branch string) map[string]string {
+		remote, err := git.PlainOpen(remoteDir)
+		Expect(err).NotTo(HaveOccurred())
+		ref, err := remote.Reference(plumbing.NewBranchReferenceName(branch), true)
+		Expect(err).NotTo(HaveOccurred())
+		commit, err := remote.CommitObject(ref.Hash())
+		Expect(err).NotTo(HaveOccurred())
+		files, err := commit.Files()
+		Expect(err).NotTo(HaveOccurred())
+		contents := make(map[string]string)
+		Expect(files.ForEach(func(file *object.File) error {
+			contents[file.Name], err = file.Contents()
+			return err
+		})).To(Succeed())
+		return contents
+	}
+
+	It("should share a shallow sparse clone between PostgresSyncs of the same repository", func() {
+		repoDir, release, err := reconciler.checkoutRepository(ctx, postgresSync("main"), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(repoDir).To(HavePrefix(reconciler.GitCacheDir))
+		Expect(reconciler.repositoryLock(repoDir).TryLock()).To(BeFalse())
+
+		Expect(filepath.Join(repoDir, "README.md")).NotTo(BeAnExistingFile())
+		Expect(os.ReadFile(filepath.Join(repoDir, "dumps", "orders.sql"))).To(BeEquivalentTo("orders"))
+		repo, err := git.PlainOpen(repoDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(repo.Storer.Shallow()).To(HaveLen(1))
+
+		Expect(os.WriteFile(filepath.Join(repoDir, "dumps", "users.sql"), []byte("users"), 0644)).To(Succeed())
+		Expect(reconciler.commitAndPushChanges(repoDir, nil, dumpCommit{Author: author, Message: "Updated database dump"})).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(repoDir, "dumps", "leftover.sql"), []byte("leftover"), 0644)).To(Succeed())
+		release()
+
+		// A PostgresSync dumping to a new branch starts from an empty worktree in the same clone
+		otherDir, release, err := reconciler.checkoutRepository(ctx, postgresSync("other"), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(otherDir).To(Equal(repoDir))
+		entries, err := os.ReadDir(otherDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+		Expect(os.MkdirAll(filepath.Join(otherDir, "dumps"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(otherDir, "dumps", "other.sql"), []byte("other"), 0644)).To(Succeed())
+		Expect(reconciler.commitAndPushChanges(otherDir, nil, dumpCommit{Author: author, Message: "Updated database dump"})).To(Succeed())
+		release()
+		Expect(remoteFiles("other")).To(Equal(map[string]string{"dumps/other.sql": "other"}))
+
+		// Back on main, the worktree has what the remote has, fetched incrementally
+		repoDir, release, err = reconciler.checkoutRepository(ctx, postgresSync("main"), nil)
+		Expect(err).NotTo(HaveOccurred())
+		defer release()
+		Expect(filepath.Join(repoDir, "dumps", "leftover.sql")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(repoDir, "dumps", "other.sql")).NotTo(BeAnExistingFile())
+		Expect(os.ReadFile(filepath.Join(repoDir, "dumps", "users.sql"))).To(BeEquivalentTo("users"))
+
+		Expect(os.Remove(filepath.Join(repoDir, "dumps", "orders.sql"))).To(Succeed())
+		Expect(reconciler.commitAndPushChanges(repoDir, nil, dumpCommit{Author: author, Message: "Updated database dump"})).To(Succeed())
+		Expect(remoteFiles("main")).To(Equal(map[string]string{"README.md": "readme", "dumps/users.sql": "users"}))
+	})
+
+	It("should propose one dump after another from the cached clone", func() {
+		api := newFakeAPI(map[string]string{
+			"GET /repos/acme/db/pulls?base=main&head=acme%3Acevichedbsync%2Fprod%2Forders&state=open": `[]`,
+			"POST /repos/acme/db/pulls": `{"number": 1, "html_url": "https://github.com/acme/db/pull/1"}`,
+		})
+		provider, err := newPullRequestProvider(&migrationsv1alpha1.PullRequestSpec{Provider: migrationsv1alpha1.GitProviderGitHub, APIURL: api.URL + "/"},
+			"https://git.example.com/acme/db.git", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+		title, err := parseTemplate("spec.git.pullRequest.title", defaultPullRequestTitle)
+		Expect(err).NotTo(HaveOccurred())
+		pgSync := postgresSync("main")
+		pgSync.Name, pgSync.Namespace = "orders", "prod"
+		proposer := &pullRequestProposer{provider: provider, branch: pullRequestBranch(pgSync), title: title}
+
+		for _, content := range []string{"second", "third"} {
+			repoDir, release, err := reconciler.checkoutRepository(ctx, pgSync, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.WriteFile(filepath.Join(repoDir, "dumps", "orders.sql"), []byte(content), 0644)).To(Succeed())
+			_, err = reconciler.proposeDump(ctx, proposer, repoDir, nil, dumpCommit{Author: author, Message: "Updated database dump\n"},
+				commitMessageData{Namespace: "prod", Name: "orders"})
+			release()
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(remoteFiles("cevichedbsync/prod/orders")).To(HaveKeyWithValue("dumps/orders.sql", "third"))
+		Expect(remoteFiles("main")).To(HaveKeyWithValue("dumps/orders.sql", "orders"))
+	})
+
+	It("should clone broken caches again", func() {
+		repoDir, release, err := reconciler.checkoutRepository(ctx, postgresSync("main"), nil)
+		Expect(err).NotTo(HaveOccurred())
+		release()
+		Expect(os.RemoveAll(filepath.Join(repoDir, ".git", "objects"))).To(Succeed())
+
+		repoDir, release, err = reconciler.checkoutRepository(ctx, postgresSync("main"), nil)
+		Expect(err).NotTo(HaveOccurred())
+		defer release()
+		Expect(os.ReadFile(filepath.Join(repoDir, "dumps", "orders.sql"))).To(BeEquivalentTo("orders"))
+	})
+
+	It("should keep the cache when the remote can't be reached", func() {
+		repoDir, release, err := reconciler.checkoutRepository(ctx, postgresSync("main"), nil)
+		Expect(err).NotTo(HaveOccurred())
+		release()
+
+		Expect(os.Rename(remoteDir, remoteDir+".moved")).To(Succeed())
+		_, _, err = reconciler.checkoutRepository(ctx, postgresSync("main"), nil)
+		Expect(err).To(MatchError(ContainSubstring("failed to update repository")))
+		Expect(os.ReadFile(filepath.Join(repoDir, "dumps", "orders.sql"))).To(BeEquivalentTo("orders"))
+		Expect(reconciler.repositoryLock(repoDir).TryLock()).To(BeTrue())
+		reconciler.repositoryLock(repoDir).Unlock()
+
+		Expect(os.Rename(remoteDir+".moved", remoteDir)).To(Succeed())
+		_, release, err = reconciler.checkoutRepository(ctx, postgresSync("main"), nil)
+		Expect(err).NotTo(HaveOccurred())
+		release()
+
+		// PostgresSyncs of other namespaces, with their own credentials, have their own clone
+		pgSync := postgresSync("main")
+		pgSync.Namespace = "other"
+		otherDir, release, err := reconciler.checkoutRepository(ctx, pgSync, nil)
+		Expect(err).NotTo(HaveOccurred())
+		release()
+		Expect(otherDir).NotTo(Equal(repoDir))
+	})
+
+	It("should clone to temporary directories without a cache", func() {
+		reconciler.GitCacheDir = ""
+		repoDir, release, err := reconciler.checkoutRepository(ctx, postgresSync("main"), nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filepath.Join(repoDir, "README.md")).NotTo(BeAnExistingFile())
+		Expect(checkoutRef(repoDir, "main", nil)).To(Succeed())
+		Expect(filepath.Join(repoDir, "README.md")).NotTo(BeAnExistingFile())
+		Expect(os.ReadFile(filepath.Join(repoDir, "dumps", "orders.sql"))).To(BeEquivalentTo("orders"))
+		release()
+		Expect(repoDir).NotTo(BeADirectory())
+	})
+})
@@ -0,0 +1,336 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	// checksumsFile stores the content checksums of the dump in the dumps directory
+	checksumsFile = "checksums.json"
+
+	// maxChangedTables is how many changed tables are listed in commit messages and statuses
+	maxChangedTables = 50
+)
+
+// dumpChecksums identifies the content of a dump independently of how it is compressed or encrypted
+type dumpChecksums struct {
+	// Files lists the files the dump is stored as, so switching formats is a change too
+	Files []string `json:"files"`
+	// Schema is the SHA-256 of everything but table rows
+	Schema string `json:"schema"`
+	// Tables maps qualified table names to the SHA-256 of their rows
+	Tables map[string]string `json:"tables"`
+	// Rows maps qualified table names to their number of rows
+	Rows map[string]int64 `json:"rows,omitempty"`
+	// Settings is the SHA-256 of the encryption recipients and masking rules, which change the stored dump
+	// without changing the data it was taken from
+	Settings string `json:"settings,omitempty"`
+}
+
+// dumpChanges describes how a dump differs from the previous one
+type dumpChanges struct {
+	// Changed is false when the dump is identical to the previous one
+	Changed bool
+	// Schema reports a change outside table rows
+	Schema bool
+	// Settings reports a change of the encryption recipients or masking rules
+	Settings bool
+	// Tables lists the tables whose rows changed, were added or removed
+	Tables []string
+}
+
+// readChecksums reads the checksums of the dump in dumpsDir, nil when there are none or they are unreadable
+func readChecksums(dumpsDir string) *dumpChecksums {
+	data, err := os.ReadFile(filepath.Join(dumpsDir, checksumsFile))
+	if err != nil {
+		return nil
+	}
+	var checksums dumpChecksums
+	if err := json.Unmarshal(data, &checksums); err != nil {
+		return nil
+	}
+	return &checksums
+}
+
+// writeChecksums writes the checksums of the dump in dumpsDir
+func writeChecksums(dumpsDir string, checksums *dumpChecksums) error {
+	data, err := json.MarshalIndent(checksums, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checksums: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dumpsDir, checksumsFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write checksums: %w", err)
+	}
+	return nil
+}
+
+// compare returns the changes from the previous checksums, everything has changed without them
+func (c *dumpChecksums) compare(previous *dumpChecksums) dumpChanges {
+	var changes dumpChanges
+	if previous == nil {
+		changes.Changed = true
+		changes.Schema = true
+		for table := range c.Tables {
+			changes.Tables = append(changes.Tables, table)
+		}
+		sort.Strings(changes.Tables)
+		return changes
+	}
+
+	changes.Schema = c.Schema != previous.Schema
+	changes.Settings = c.Settings != previous.Settings
+	for table, checksum := range c.Tables {
+		if previous.Tables[table] != checksum {
+			changes.Tables = append(changes.Tables, table)
+		}
+	}
+	for table := range previous.Tables {
+		if _, ok := c.Tables[table]; !ok {
+			changes.Tables = append(changes.Tables, table)
+		}
+	}
+	sort.Strings(changes.Tables)
+	changes.Changed = changes.Schema || changes.Settings || len(changes.Tables) > 0 || !slices.Equal(c.Files, previous.Files)
+	return changes
+}
+
+// commitMessage returns the commit message listing the changes
+func (c dumpChanges) commitMessage() string {
+	var b strings.Builder
+	b.WriteString("Updated database dump\n")
+	if c.Schema || c.Settings || len(c.Tables) > 0 {
+		b.WriteString("\n")
+	}
+	if c.Schema {
+		b.WriteString("Schema changed\n")
+	}
+	if c.Settings {
+		b.WriteString("Encryption or masking changed\n")
+	}
+	if len(c.Tables) > 0 {
+		b.WriteString("Changed tables:\n")
+		for _, table := range c.listedTables() {
+			fmt.Fprintf(&b, "- %s\n", table)
+		}
+		if more := len(c.Tables) - maxChangedTables; more > 0 {
+			fmt.Fprintf(&b, "- and %d more\n", more)
+		}
+	}
+	return b.String()
+}
+
+// listedTables returns the changed tables reported in commit messages and statuses
+func (c dumpChanges) listedTables() []string {
+	if len(c.Tables) > maxChangedTables {
+		return c.Tables[:maxChangedTables]
+	}
+	return c.Tables
+}
+
+// seal replaces the checksums with their HMAC-SHA256 under key and drops the row counts, so the checksums
+// stored next to an encrypted dump reveal nothing about its data
+func (c *dumpChecksums) seal(key []byte) {
+	keyed := func(checksum string) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(checksum))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	c.Schema = keyed(c.Schema)
+	for table, checksum := range c.Tables {
+		c.Tables[table] = keyed(checksum)
+	}
+	c.Rows = nil
+}
+
+// settingsFingerprint combines the fingerprints of the encryption recipients and of the masker, empty
+// when dumps are neither encrypted nor masked
+func settingsFingerprint(recipients string, mask *masker) string {
+	masking := mask.fingerprint()
+	if recipients == "" && masking == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(recipients + "\n" + masking))
+	return hex.EncodeToString(sum[:])
+}
+
+// plainChecksums computes the checksums of a plain dump: the rows of each COPY block are checksummed
+// by table, every other line goes into the schema checksum
+func plainChecksums(r io.Reader) (*dumpChecksums, error) {
+	schema := sha256.New()
+	tables := make(map[string]hash.Hash)
+	rows := make(map[string]int64)
+
+	reader := bufio.NewReader(r)
+	var current hash.Hash
+	var table string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read dump: %w", err)
+		}
+
+		switch {
+		case current != nil && (line == "\\.\n" || line == "\\."):
+			current = nil
+		case current != nil:
+			current.Write([]byte(line))
+			rows[table]++
+		case restrictLine(line):
+			// The key changes with every run, it would change the checksum of every dump
+		default:
+			schema.Write([]byte(line))
+			if name, _, ok := parseCopyStatement(line); ok {
+				if tables[name] == nil {
+					tables[name] = sha256.New()
+				}
+				table, current = name, tables[name]
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	checksums := &dumpChecksums{Schema: hex.EncodeToString(schema.Sum(nil)), Tables: make(map[string]string, len(tables)), Rows: make(map[string]int64, len(tables))}
+	for name, h := range tables {
+		checksums.Tables[name] = hex.EncodeToString(h.Sum(nil))
+		checksums.Rows[name] = rows[name]
+	}
+	return checksums, nil
+}
+
+// fileChecksums computes the checksums of the plain dump at path
+func fileChecksums(path string) (*dumpChecksums, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dump: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+	return plainChecksums(file)
+}
+
+// archiveChecksums computes the checksums of a custom, directory or tar archive from the plain SQL
+// pg_restore turns it into, the archive itself embeds the time it was taken
+func archiveChecksums(path string) (*dumpChecksums, error) {
+	cmd := exec.Command("pg_restore", "--file=-", path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	checksums, err := plainChecksums(stdout)
+	// Drain the output so pg_restore can exit even if reading failed
+	_, _ = io.Copy(io.Discard, stdout)
+	if waitErr := cmd.Wait(); waitErr != nil {
+		return nil, fmt.Errorf("failed to read archive: %w, output: %s", waitErr, stderr.String())
+	}
+	return checksums, err
+}
+
+// tableChecksums computes the checksums of the per-table dump in dumpsDir from its files
+func tableChecksums(dumpsDir string) (*dumpChecksums, error) {
+	data, err := os.ReadFile(filepath.Join(dumpsDir, tableManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read table manifest: %w", err)
+	}
+	var manifest tableManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode table manifest: %w", err)
+	}
+
+	schema := sha256.New()
+	for _, name := range []string{tableManifestFile, tableSchemaFile, tableSequencesFile, tablePostDataFile} {
+		if err := hashScript(schema, filepath.Join(dumpsDir, name)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	checksums := &dumpChecksums{Schema: hex.EncodeToString(schema.Sum(nil)), Tables: make(map[string]string), Rows: make(map[string]int64)}
+	for _, table := range manifest.Tables {
+		if table.File == "" {
+			continue
+		}
+		// COPY text escapes newlines in values, so every line is a row
+		h := sha256.New()
+		var rows lineCounter
+		if err := hashFile(io.MultiWriter(h, &rows), filepath.Join(dumpsDir, table.File)); err != nil {
+			return nil, err
+		}
+		name := table.Schema + "." + table.Name
+		checksums.Tables[name] = hex.EncodeToString(h.Sum(nil))
+		checksums.Rows[name] = int64(rows)
+	}
+	return checksums, nil
+}
+
+// restrictLine reports whether line is one of the \restrict and \unrestrict commands pg_dump 17.6 and later
+// wrap scripts in, with a random key
+func restrictLine(line string) bool {
+	return strings.HasPrefix(line, "\\restrict ") || strings.HasPrefix(line, "\\unrestrict ")
+}
+
+// hashScript writes the lines of the SQL script at path to w, except \restrict and \unrestrict commands
+func hashScript(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close() //nolint:errcheck
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+		}
+		if !restrictLine(line) {
+			if _, err := io.WriteString(w, line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// hashFile writes the content of the file at path to w
+func hashFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close() //nolint:errcheck
+	if _, err := io.Copy(w, file); err != nil {
+		return fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// lineCounter counts the lines written to it
+type lineCounter int64
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	*c += lineCounter(bytes.Count(p, []byte{'\n'}))
+	return len(p), nil
+}
@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Dump checksums", func() {
+	const dump = `CREATE TABLE public.users (id integer, email text);
+
+COPY public.users (id, email) FROM stdin;
+1	alice@example.com
+\.
+
+COPY public.orders (id) FROM stdin;
+10
+\.
+`
+
+	checksum := func(content string) *dumpChecksums {
+		checksums, err := plainChecksums(strings.NewReader(content))
+		Expect(err).NotTo(HaveOccurred())
+		return checksums
+	}
+
+	It("should checksum the rows of each table apart from the schema", func() {
+		checksums := checksum(dump)
+		Expect(checksums.Tables).To(HaveKey("public.users"))
+		Expect(checksums.Tables).To(HaveKey("public.orders"))
+		Expect(checksums.Rows).To(Equal(map[string]int64{"public.users": 1, "public.orders": 1}))
+
+		changed := checksum(strings.Replace(dump, "alice", "bob", 1))
+		Expect(changed.Schema).To(Equal(checksums.Schema))
+		Expect(changed.Tables["public.orders"]).To(Equal(checksums.Tables["public.orders"]))
+		Expect(changed.Tables["public.users"]).NotTo(Equal(checksums.Tables["public.users"]))
+
+		altered := checksum(strings.Replace(dump, "email text", "email varchar", 1))
+		Expect(altered.Schema).NotTo(Equal(checksums.Schema))
+		Expect(altered.Tables).To(Equal(checksums.Tables))
+	})
+
+	It("should ignore the random restrict key of pg_dump 17.6 and later", func() {
+		restricted := func(key string) string {
+			return "\\restrict " + key + "\n" + dump + "\\unrestrict " + key + "\n"
+		}
+		checksums := checksum(restricted("aBc123"))
+		Expect(checksums).To(Equal(checksum(dump)))
+		Expect(checksum(restricted("XyZ789"))).To(Equal(checksums))
+
+		dumpsDir := GinkgoT().TempDir()
+		manifest, err := json.Marshal(tableManifest{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(dumpsDir, tableManifestFile), manifest, 0644)).To(Succeed())
+		tableSum := func(key string) string {
+			for _, name := range []string{tableSchemaFile, tablePostDataFile} {
+				script := "\\restrict " + key + "\nCREATE TABLE users ();\n\\unrestrict " + key + "\n"
+				Expect(os.WriteFile(filepath.Join(dumpsDir, name), []byte(script), 0644)).To(Succeed())
+			}
+			checksums, err := tableChecksums(dumpsDir)
+			Expect(err).NotTo(HaveOccurred())
+			return checksums.Schema
+		}
+		Expect(tableSum("aBc123")).To(Equal(tableSum("XyZ789")))
+	})
+
+	It("should list the tables that changed", func() {
+		previous := checksum(dump)
+		previous.Files = []string{"dump.sql"}
+
+		current := checksum(dump)
+		current.Files = []string{"dump.sql"}
+		Expect(current.compare(previous).Changed).To(BeFalse())
+
+		current.Files = []string{"dump.sql.gz"}
+		Expect(current.compare(previous)).To(Equal(dumpChanges{Changed: true}))
+
+		current = checksum(strings.Replace(dump, "10\n", "11\n", 1) + "COPY public.audit (id) FROM stdin;\n\\.\n")
+		current.Files = previous.Files
+		changes := current.compare(previous)
+		Expect(changes.Changed).To(BeTrue())
+		Expect(changes.Tables).To(Equal([]string{"public.audit", "public.orders"}))
+		Expect(changes.commitMessage()).To(Equal("Updated database dump\n\nSchema changed\nChanged tables:\n- public.audit\n- public.orders\n"))
+
+		delete(current.Tables, "public.users")
+		Expect(current.compare(previous).Tables).To(ContainElement("public.users"))
+	})
+
+	It("should report a change of the encryption recipients or masking rules", func() {
+		rules := []migrationsv1alpha1.MaskingRule{{Table: "users", Column: "email", Strategy: migrationsv1alpha1.MaskingStrategyHash}}
+		previous := checksum(dump)
+		previous.Settings = settingsFingerprint("recipients", newMasker(rules, []byte("salt")))
+		Expect(previous.Settings).NotTo(BeEmpty())
+		Expect(settingsFingerprint("", nil)).To(BeEmpty())
+
+		current := checksum(dump)
+		current.Settings = settingsFingerprint("recipients", newMasker(rules, []byte("salt")))
+		Expect(current.compare(previous).Changed).To(BeFalse())
+
+		for _, settings := range []string{
+			settingsFingerprint("rotated", newMasker(rules, []byte("salt"))),
+			settingsFingerprint("recipients", newMasker(rules, []byte("pepper"))),
+			settingsFingerprint("recipients", nil),
+		} {
+			current.Settings = settings
+			changes := current.compare(previous)
+			Expect(changes).To(Equal(dumpChanges{Changed: true, Settings: true}))
+			Expect(changes.commitMessage()).To(ContainSubstring("Encryption or masking changed"))
+		}
+	})
+
+	It("should key the checksums of encrypted dumps", func() {
+		plain := checksum(dump)
+		sealed := checksum(dump)
+		sealed.seal([]byte("checksum-key"))
+		Expect(sealed.Rows).To(BeNil())
+		Expect(sealed.Schema).NotTo(Equal(plain.Schema))
+		Expect(sealed.Tables["public.users"]).NotTo(Equal(plain.Tables["public.users"]))
+
+		again := checksum(dump)
+		again.seal([]byte("checksum-key"))
+		Expect(again.compare(sealed).Changed).To(BeFalse())
+		changed := checksum(strings.Replace(dump, "alice", "bob", 1))
+		changed.seal([]byte("checksum-key"))
+		Expect(changed.compare(sealed).Tables).To(Equal([]string{"public.users"}))
+		other := checksum(dump)
+		other.seal([]byte("other-key"))
+		Expect(other.compare(sealed).Schema).To(BeTrue())
+	})
+
+	It("should report every table of a first dump", func() {
+		changes := checksum(dump).compare(nil)
+		Expect(changes.Changed).To(BeTrue())
+		Expect(changes.Tables).To(Equal([]string{"public.orders", "public.users"}))
+	})
+
+	It("should cap the listed tables", func() {
+		changes := dumpChanges{Changed: true}
+		for i := 0; i < maxChangedTables+3; i++ {
+			changes.Tables = append(changes.Tables, "public.t")
+		}
+		Expect(changes.listedTables()).To(HaveLen(maxChangedTables))
+		Expect(changes.commitMessage()).To(HaveSuffix("- and 3 more\n"))
+	})
+
+	It("should checksum per-table dumps from their files", func() {
+		dumpsDir := GinkgoT().TempDir()
+		Expect(os.Mkdir(filepath.Join(dumpsDir, tableDataDir), 0755)).To(Succeed())
+		manifest, err := json.Marshal(tableManifest{Tables: []manifestTable{
+			{Schema: "public", Name: "users", File: "data/public.users.copy"},
+			{Schema: "public", Name: "sessions"},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(dumpsDir, tableManifestFile), manifest, 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dumpsDir, tableSchemaFile), []byte("CREATE TABLE users ();\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dumpsDir, "data/public.users.copy"), []byte("1\n"), 0644)).To(Succeed())
+
+		checksums, err := tableChecksums(dumpsDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(checksums.Tables).To(HaveLen(1))
+		Expect(checksums.Rows).To(Equal(map[string]int64{"public.users": 1}))
+
+		Expect(writeChecksums(dumpsDir, checksums)).To(Succeed())
+		Expect(readChecksums(dumpsDir)).To(Equal(checksums))
+
+		Expect(os.WriteFile(filepath.Join(dumpsDir, "data/public.users.copy"), []byte("2\n"), 0644)).To(Succeed())
+		changed, err := tableChecksums(dumpsDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed.compare(checksums).Tables).To(Equal([]string{"public.users"}))
+	})
+
+	It("should keep checksums when removing the previous dump", func() {
+		dumpsDir := GinkgoT().TempDir()
+		Expect(writeChecksums(dumpsDir, &dumpChecksums{Files: []string{"dump.sql"}})).To(Succeed())
+		Expect(removeDumpArtifacts(dumpsDir)).To(Succeed())
+		Expect(readChecksums(dumpsDir)).NotTo(BeNil())
+		Expect(readChecksums(GinkgoT().TempDir())).To(BeNil())
+	})
+})
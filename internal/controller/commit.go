@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// Author of dump commits when spec.git.author is empty
+const (
+	defaultAuthorName  = "Ceviche DB Sync Operator"
+	defaultAuthorEmail = "operator@example.com"
+)
+
+// commitAuthor returns the author and committer of the dump commits of a PostgresSync
+func commitAuthor(pgSync *cevichev1alpha1.PostgresSync) *object.Signature {
+	author := &object.Signature{Name: defaultAuthorName, Email: defaultAuthorEmail, When: time.Now()}
+	if pgSync.Spec.Git != nil && pgSync.Spec.Git.Author != nil {
+		author.Name = pgSync.Spec.Git.Author.Name
+		author.Email = pgSync.Spec.Git.Author.Email
+	}
+	return author
+}
+
+// commitMessageData holds the variables of commit message templates
+type commitMessageData struct {
+	Namespace     string
+	Name          string
+	Database      string
+	Trigger       string
+	Format        string
+	Time          time.Time
+	SchemaChanged bool
+	ChangedTables []string
+	RowCounts     map[string]int64
+}
+
+// commitTemplate renders the messages of dump commits
+type commitTemplate struct {
+	message  *template.Template
+	trailers []commitTrailer
+}
+
+// commitTrailer is a parsed spec.git.trailers entry
+type commitTrailer struct {
+	key   string
+	value *template.Template
+}
+
+// commitTemplateFuncs are the functions available to commit message templates besides the builtin ones
+var commitTemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// parseCommitTemplate parses the commit message and trailer templates of a PostgresSync, so mistakes are
+// reported before dumping anything
+func parseCommitTemplate(pgSync *cevichev1alpha1.PostgresSync) (*commitTemplate, error) {
+	tmpl := &commitTemplate{}
+	if pgSync.Spec.Git == nil {
+		return tmpl, nil
+	}
+
+	if text := pgSync.Spec.Git.CommitMessage; text != "" {
+		message, err := parseTemplate("spec.git.commitMessage", text)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.message = message
+	}
+
+	for i, trailer := range pgSync.Spec.Git.Trailers {
+		value, err := parseTemplate(fmt.Sprintf("spec.git.trailers[%d]", i), trailer.Value)
+		if err != nil {
+			return nil, err
+		}
+		tmpl.trailers = append(tmpl.trailers, commitTrailer{key: trailer.Key, value: value})
+	}
+	return tmpl, nil
+}
+
+// parseTemplate parses a template of the spec, field is the path of the template in the spec
+func parseTemplate(field, text string) (*template.Template, error) {
+	tmpl, err := template.New(field).Funcs(commitTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", field, err)
+	}
+	return tmpl, nil
+}
+
+// render renders the commit message, defaultMessage is used when there is no message template
+func (t *commitTemplate) render(data commitMessageData, defaultMessage string) (string, error) {
+	message := defaultMessage
+	if t.message != nil {
+		var b bytes.Buffer
+		if err := t.message.Execute(&b, data); err != nil {
+			return "", fmt.Errorf("failed to render commit message: %w", err)
+		}
+		message = b.String()
+	}
+	message = strings.TrimRight(message, " \t\n")
+	if message == "" {
+		return "", fmt.Errorf("commit message is empty")
+	}
+
+	// Trailers rendering to nothing are left out, so templates can add them conditionally
+	var trailers []string
+	for _, trailer := range t.trailers {
+		var value bytes.Buffer
+		if err := trailer.value.Execute(&value, data); err != nil {
+			return "", fmt.Errorf("failed to render trailer %s: %w", trailer.key, err)
+		}
+		line := strings.TrimSpace(value.String())
+		if strings.Contains(line, "\n") {
+			return "", fmt.Errorf("trailer %s renders to more than one line", trailer.key)
+		}
+		if line != "" {
+			trailers = append(trailers, trailer.key+": "+line)
+		}
+	}
+	if len(trailers) == 0 {
+		return message + "\n", nil
+	}
+	return message + "\n\n" + strings.Join(trailers, "\n") + "\n", nil
+}
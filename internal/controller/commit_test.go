@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Commit messages", func() {
+	var pgSync *migrationsv1alpha1.PostgresSync
+	data := commitMessageData{
+		Namespace:     "prod",
+		Name:          "orders",
+		Database:      "shop",
+		Trigger:       string(migrationsv1alpha1.DumpTriggerSchedule),
+		Format:        "plain",
+		Time:          time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+		ChangedTables: []string{"public.orders", "public.users"},
+		RowCounts:     map[string]int64{"public.orders": 12, "public.users": 3},
+	}
+
+	BeforeEach(func() {
+		pgSync = &migrationsv1alpha1.PostgresSync{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "prod"},
+			Spec:       migrationsv1alpha1.PostgresSyncSpec{Git: &migrationsv1alpha1.GitSpec{}},
+		}
+	})
+
+	It("should author commits as the operator by default", func() {
+		author := commitAuthor(&migrationsv1alpha1.PostgresSync{})
+		Expect(author.Name).To(Equal("Ceviche DB Sync Operator"))
+		Expect(author.Email).To(Equal("operator@example.com"))
+
+		pgSync.Spec.Git.Author = &migrationsv1alpha1.GitAuthor{Name: "DB Bot", Email: "db-bot@corp.com"}
+		author = commitAuthor(pgSync)
+		Expect(author.Name).To(Equal("DB Bot"))
+		Expect(author.Email).To(Equal("db-bot@corp.com"))
+	})
+
+	It("should use the default message without a template", func() {
+		tmpl, err := parseCommitTemplate(pgSync)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tmpl.render(data, "Updated database dump\n")).To(Equal("Updated database dump\n"))
+	})
+
+	It("should render the message and trailers", func() {
+		pgSync.Spec.Git.CommitMessage = `Dump {{ .Database }} from {{ .Namespace }}/{{ .Name }}
+
+{{ range .ChangedTables }}- {{ . }}: {{ index $.RowCounts . }} rows
+{{ end }}`
+		pgSync.Spec.Git.Trailers = []migrationsv1alpha1.GitTrailer{
+			{Key: "Triggered-By", Value: "{{ .Trigger }}"},
+			{Key: "Schema-Changed", Value: "{{ if .SchemaChanged }}yes{{ end }}"},
+			{Key: "Dumped-At", Value: `{{ .Time.Format "2006-01-02" }}`},
+		}
+		tmpl, err := parseCommitTemplate(pgSync)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(tmpl.render(data, "unused")).To(Equal(`Dump shop from prod/orders
+
+- public.orders: 12 rows
+- public.users: 3 rows
+
+Triggered-By: Schedule
+Dumped-At: 2025-03-01
+`))
+	})
+
+	It("should reject invalid templates before dumping", func() {
+		pgSync.Spec.Git.CommitMessage = "{{ .Database "
+		_, err := parseCommitTemplate(pgSync)
+		Expect(err).To(MatchError(ContainSubstring("spec.git.commitMessage")))
+
+		pgSync.Spec.Git.CommitMessage = ""
+		pgSync.Spec.Git.Trailers = []migrationsv1alpha1.GitTrailer{{Key: "A", Value: "{{ end }}"}}
+		_, err = parseCommitTemplate(pgSync)
+		Expect(err).To(MatchError(ContainSubstring("spec.git.trailers[0]")))
+	})
+
+	It("should fail on unknown variables, empty messages and multi-line trailers", func() {
+		pgSync.Spec.Git.CommitMessage = "{{ .Unknown }}"
+		tmpl, err := parseCommitTemplate(pgSync)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tmpl.render(data, "")
+		Expect(err).To(MatchError(ContainSubstring("Unknown")))
+
+		pgSync.Spec.Git.CommitMessage = "{{ if false }}x{{ end }}"
+		tmpl, err = parseCommitTemplate(pgSync)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tmpl.render(data, "")
+		Expect(err).To(MatchError(ContainSubstring("empty")))
+
+		pgSync.Spec.Git.CommitMessage = ""
+		pgSync.Spec.Git.Trailers = []migrationsv1alpha1.GitTrailer{{Key: "Tables", Value: `{{ join .ChangedTables "\n" }}`}}
+		tmpl, err = parseCommitTemplate(pgSync)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = tmpl.render(data, "Updated database dump\n")
+		Expect(err).To(MatchError(ContainSubstring("more than one line")))
+	})
+})
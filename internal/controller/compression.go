@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// Magic bytes at the start of compressed files
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// dumpCompressions lists the compressions of dump files, in the order their dumps are looked for when restoring
+var dumpCompressions = []cevichev1alpha1.DumpCompression{
+	cevichev1alpha1.DumpCompressionNone,
+	cevichev1alpha1.DumpCompressionGzip,
+	cevichev1alpha1.DumpCompressionZstd,
+}
+
+// dumpCompression returns the compression and level dumps of the PostgresSync are taken with, a level of
+// zero stands for the default level of the algorithm
+func dumpCompression(pgSync *cevichev1alpha1.PostgresSync) (cevichev1alpha1.DumpCompression, int) {
+	if pgSync.Spec.Dump == nil {
+		return "", 0
+	}
+	level := 0
+	if pgSync.Spec.Dump.CompressionLevel != nil {
+		level = int(*pgSync.Spec.Dump.CompressionLevel)
+	}
+	return pgSync.Spec.Dump.Compression, level
+}
+
+// compressedInGo reports whether dumps of the given format are compressed by the operator rather than pg_dump
+func compressedInGo(format cevichev1alpha1.DumpFormat) bool {
+	return format == cevichev1alpha1.DumpFormatPlain || format == cevichev1alpha1.DumpFormatTar
+}
+
+// compressionExtension returns the file extension of the given compression
+func compressionExtension(compression cevichev1alpha1.DumpCompression) string {
+	switch compression {
+	case cevichev1alpha1.DumpCompressionGzip:
+		return ".gz"
+	case cevichev1alpha1.DumpCompressionZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// pgDumpCompressionArgs returns the pg_dump arguments compressing custom and directory archives
+func pgDumpCompressionArgs(compression cevichev1alpha1.DumpCompression, level int) []string {
+	switch compression {
+	case cevichev1alpha1.DumpCompressionNone:
+		return []string{"--compress=0"}
+	case cevichev1alpha1.DumpCompressionGzip:
+		if level == 0 {
+			return nil
+		}
+		return []string{"--compress=" + strconv.Itoa(level)}
+	case cevichev1alpha1.DumpCompressionZstd:
+		if level == 0 {
+			return []string{"--compress=zstd"}
+		}
+		return []string{"--compress=zstd:" + strconv.Itoa(level)}
+	default:
+		return nil
+	}
+}
+
+// compressFile compresses the file at path into path plus the extension of the compression, removes
+// the original and returns the new path
+func compressFile(path string, compression cevichev1alpha1.DumpCompression, level int) (string, error) {
+	extension := compressionExtension(compression)
+	if extension == "" {
+		return path, nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open dump: %w", err)
+	}
+	defer in.Close() //nolint:errcheck
+
+	compressedPath := path + extension
+	out, err := os.Create(compressedPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create compressed dump: %w", err)
+	}
+	defer out.Close() //nolint:errcheck
+
+	var writer io.WriteCloser
+	switch compression {
+	case cevichev1alpha1.DumpCompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		writer, err = gzip.NewWriterLevel(out, level)
+	case cevichev1alpha1.DumpCompressionZstd:
+		options := []zstd.EOption{}
+		if level != 0 {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		writer, err = zstd.NewWriter(out, options...)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s writer: %w", compression, err)
+	}
+
+	if _, err := io.Copy(writer, in); err != nil {
+		return "", fmt.Errorf("failed to compress dump: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to compress dump: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return "", fmt.Errorf("failed to write compressed dump: %w", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("failed to remove uncompressed dump: %w", err)
+	}
+	return compressedPath, nil
+}
+
+// detectCompression returns the compression of the file at path, from its extension or else its magic bytes
+func detectCompression(path string) (cevichev1alpha1.DumpCompression, error) {
+	for _, compression := range dumpCompressions {
+		if extension := compressionExtension(compression); extension != "" && strings.HasSuffix(path, extension) {
+			return compression, nil
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open dump: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	header := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("failed to read dump: %w", err)
+	}
+	switch {
+	case bytes.HasPrefix(header[:n], gzipMagic):
+		return cevichev1alpha1.DumpCompressionGzip, nil
+	case bytes.HasPrefix(header[:n], zstdMagic):
+		return cevichev1alpha1.DumpCompressionZstd, nil
+	default:
+		return cevichev1alpha1.DumpCompressionNone, nil
+	}
+}
+
+// decompressFile decompresses the file at path if it is compressed and returns the path of the
+// decompressed file, which is written to workDir without its extension
+func decompressFile(path, workDir string) (string, error) {
+	compression, err := detectCompression(path)
+	if err != nil {
+		return "", err
+	}
+	if compression == cevichev1alpha1.DumpCompressionNone {
+		return path, nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open dump: %w", err)
+	}
+	defer in.Close() //nolint:errcheck
+
+	var reader io.Reader
+	switch compression {
+	case cevichev1alpha1.DumpCompressionGzip:
+		gzipReader, err := gzip.NewReader(in)
+		if err != nil {
+			return "", fmt.Errorf("failed to read gzip dump: %w", err)
+		}
+		defer gzipReader.Close() //nolint:errcheck
+		reader = gzipReader
+	case cevichev1alpha1.DumpCompressionZstd:
+		zstdReader, err := zstd.NewReader(in)
+		if err != nil {
+			return "", fmt.Errorf("failed to read zstd dump: %w", err)
+		}
+		defer zstdReader.Close()
+		reader = zstdReader
+	}
+
+	// The decrypted dump may already be in workDir under the same name
+	decompressedPath := filepath.Join(workDir, strings.TrimSuffix(filepath.Base(path), compressionExtension(compression)))
+	if decompressedPath == path {
+		decompressedPath = path + ".decompressed"
+	}
+	out, err := os.Create(decompressedPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create decompressed dump: %w", err)
+	}
+	defer out.Close() //nolint:errcheck
+
+	if _, err := io.Copy(out, reader); err != nil {
+		return "", fmt.Errorf("failed to decompress %s dump: %w", compression, err)
+	}
+	if err := out.Close(); err != nil {
+		return "", fmt.Errorf("failed to write decompressed dump: %w", err)
+	}
+	return decompressedPath, nil
+}
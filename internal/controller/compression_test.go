@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Dump compression", func() {
+	dump := strings.Repeat("INSERT INTO t VALUES (1);\n", 1000)
+
+	DescribeTable("should compress dumps and decompress them on restore",
+		func(compression migrationsv1alpha1.DumpCompression, level int, extension string) {
+			dumpsDir := GinkgoT().TempDir()
+			path := filepath.Join(dumpsDir, "dump.sql")
+			Expect(os.WriteFile(path, []byte(dump), 0644)).To(Succeed())
+
+			compressedPath, err := compressFile(path, compression, level)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(compressedPath).To(Equal(path + extension))
+			Expect(os.Stat(compressedPath)).To(HaveField("Size()", BeNumerically("<", len(dump))))
+
+			found, format := findDumpArtifact(dumpsDir, migrationsv1alpha1.DumpFormatPlain)
+			Expect(found).To(Equal(compressedPath))
+			Expect(format).To(Equal(migrationsv1alpha1.DumpFormatPlain))
+
+			workDir := GinkgoT().TempDir()
+			decompressedPath, err := decompressFile(found, workDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decompressedPath).To(Equal(filepath.Join(workDir, "dump.sql")))
+			Expect(os.ReadFile(decompressedPath)).To(BeEquivalentTo(dump))
+			entries, err := os.ReadDir(dumpsDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+		},
+		Entry("gzip", migrationsv1alpha1.DumpCompressionGzip, 0, ".gz"),
+		Entry("gzip with a level", migrationsv1alpha1.DumpCompressionGzip, 9, ".gz"),
+		Entry("zstd", migrationsv1alpha1.DumpCompressionZstd, 0, ".zst"),
+		Entry("zstd with a level", migrationsv1alpha1.DumpCompressionZstd, 19, ".zst"),
+	)
+
+	It("should detect compressed dumps without an extension by their magic bytes", func() {
+		dumpsDir := GinkgoT().TempDir()
+		path := filepath.Join(dumpsDir, "dump.sql")
+		Expect(os.WriteFile(path, []byte(dump), 0644)).To(Succeed())
+		compressedPath, err := compressFile(path, migrationsv1alpha1.DumpCompressionZstd, 0)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Rename(compressedPath, path)).To(Succeed())
+
+		Expect(detectCompression(path)).To(Equal(migrationsv1alpha1.DumpCompressionZstd))
+		decompressedPath, err := decompressFile(path, GinkgoT().TempDir())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.ReadFile(decompressedPath)).To(BeEquivalentTo(dump))
+	})
+
+	It("should leave compressing archives to pg_dump", func() {
+		level := int32(5)
+		pgSync := &migrationsv1alpha1.PostgresSync{Spec: migrationsv1alpha1.PostgresSyncSpec{
+			Dump: &migrationsv1alpha1.DumpSpec{
+				Format:           migrationsv1alpha1.DumpFormatCustom,
+				Compression:      migrationsv1alpha1.DumpCompressionZstd,
+				CompressionLevel: &level,
+			},
+		}}
+		Expect(dumpArgs(pgSync)).To(Equal([]string{"--format=custom", "--compress=zstd:5"}))
+
+		pgSync.Spec.Dump.Format = migrationsv1alpha1.DumpFormatTar
+		Expect(dumpArgs(pgSync)).To(Equal([]string{"--format=tar"}))
+	})
+})
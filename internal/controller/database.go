@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// databaseConnection holds the parameters to connect to the database of a PostgresSync
+type databaseConnection struct {
+	host     string
+	port     string
+	name     string
+	user     string
+	password string
+}
+
+// getDatabaseConnection reads the connection parameters of a PostgresSync from its spec and credentials Secret
+func (r *PostgresSyncReconciler) getDatabaseConnection(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) (*databaseConnection, error) {
+	// Get database credentials
+	dbSecret := &corev1.Secret{}
+	dbSecretKey := types.NamespacedName{
+		Name:      pgSync.Spec.DatabaseCredentials.SecretName,
+		Namespace: pgSync.Namespace,
+	}
+	if err := r.Get(ctx, dbSecretKey, dbSecret); err != nil {
+		return nil, fmt.Errorf("failed to get database credentials: %w", err)
+	}
+
+	// Build connection parameters
+	// Now using the service and service namespace from the CRD
+	host := pgSync.Spec.DatabaseService.Name
+	if host == "" {
+		return nil, fmt.Errorf("database service name is required")
+	}
+
+	// If service namespace is provided, use it for FQDN
+	if pgSync.Spec.DatabaseService.Namespace != "" {
+		host = fmt.Sprintf("%s.%s.svc.cluster.local", host, pgSync.Spec.DatabaseService.Namespace)
+	}
+
+	port := string(dbSecret.Data["port"])
+	if port == "" {
+		port = "5432" // Default PostgreSQL port
+	}
+
+	dbName := string(dbSecret.Data["database"])
+	if dbName == "" {
+		return nil, fmt.Errorf("database name is required in secret")
+	}
+
+	dbUser := string(dbSecret.Data["username"])
+	if dbUser == "" {
+		return nil, fmt.Errorf("database username is required in secret")
+	}
+
+	dbPassword := string(dbSecret.Data["password"])
+	if dbPassword == "" {
+		return nil, fmt.Errorf("database password is required in secret")
+	}
+
+	return &databaseConnection{
+		host:     host,
+		port:     port,
+		name:     dbName,
+		user:     dbUser,
+		password: dbPassword,
+	}, nil
+}
+
+// args returns the connection arguments shared by psql and pg_dump
+func (c *databaseConnection) args() []string {
+	return []string{
+		"-h", c.host,
+		"-p", c.port,
+		"-U", c.user,
+		"-d", c.name,
+	}
+}
+
+// env returns the environment for PostgreSQL client commands
+func (c *databaseConnection) env() []string {
+	return append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", c.password))
+}
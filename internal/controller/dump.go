@@ -0,0 +1,228 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// defaultDumpHistoryLimit is how many finished PostgresSyncDumps are kept when the spec doesn't say otherwise
+const defaultDumpHistoryLimit = 10
+
+// dumpResult describes a dump pushed to the repository, or stored in another storage
+type dumpResult struct {
+	CommitSHA string `json:"commitSHA,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Location  string `json:"location,omitempty"`
+	// Unchanged is set when the dump matched the committed one, CommitSHA is then the commit holding it
+	Unchanged      bool     `json:"unchanged,omitempty"`
+	ChangedTables  []string `json:"changedTables,omitempty"`
+	PullRequestURL string   `json:"pullRequestURL,omitempty"`
+}
+
+// describeDump describes the dump at path, relative to the repository cloned in repoDir, as of its HEAD commit
+func describeDump(repoDir, repoURL, path string) (*dumpResult, error) {
+	size, err := dumpSize(filepath.Join(repoDir, path))
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get HEAD: %w", err)
+	}
+
+	return &dumpResult{
+		CommitSHA: head.Hash().String(),
+		Size:      size,
+		Location:  fmt.Sprintf("%s#%s:%s", repoURL, head.Name().Short(), filepath.ToSlash(path)),
+	}, nil
+}
+
+// runDump dumps the database inside the operator, recording the run in a PostgresSyncDump whose name is returned
+// along with the result of the dump. The name is empty when the run couldn't be recorded
+func (r *PostgresSyncReconciler) runDump(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, trigger cevichev1alpha1.DumpTrigger) (string, *dumpResult, error) {
+	// Recording is bookkeeping, a missing record must not fail the dump
+	name, err := r.recordDumpStarted(ctx, pgSync, trigger, "")
+	if err != nil {
+		log.FromContext(ctx).Error(err, "unable to record dump")
+	}
+
+	result, err := r.createDatabaseDump(ctx, pgSync, trigger)
+	r.finishDumpRecord(ctx, pgSync, name, result, err, metav1.Now())
+	return name, result, err
+}
+
+// startDumpJob dumps the database in a Job, recording the run in a PostgresSyncDump named after the Job
+func (r *PostgresSyncReconciler) startDumpJob(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, trigger cevichev1alpha1.DumpTrigger) error {
+	if err := r.startJob(ctx, pgSync, OperationDump, "", trigger); err != nil {
+		return err
+	}
+
+	// Recording is bookkeeping and the Job is already running, a missing record must not fail the dump
+	if _, err := r.recordDumpStarted(ctx, pgSync, trigger, pgSync.Status.ActiveJob); err != nil {
+		log.FromContext(ctx).Error(err, "unable to record dump", "job", pgSync.Status.ActiveJob)
+	}
+	return nil
+}
+
+// recordDumpStarted creates the PostgresSyncDump of a dump run and returns its name. Dumps running in a Job
+// are recorded under the name of the Job
+func (r *PostgresSyncReconciler) recordDumpStarted(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, trigger cevichev1alpha1.DumpTrigger, jobName string) (string, error) {
+	now := metav1.Now()
+	name := jobName
+	if name == "" {
+		name = operationName(pgSync, OperationDump, now.Time)
+	}
+
+	dump := &cevichev1alpha1.PostgresSyncDump{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: pgSync.Namespace,
+			Labels: map[string]string{
+				postgresSyncLabel: pgSync.Name,
+			},
+		},
+		Spec: cevichev1alpha1.PostgresSyncDumpSpec{
+			PostgresSyncName: pgSync.Name,
+			Trigger:          trigger,
+			JobName:          jobName,
+		},
+	}
+	if err := controllerutil.SetControllerReference(pgSync, dump, r.Scheme); err != nil {
+		return "", fmt.Errorf("failed to set PostgresSyncDump owner: %w", err)
+	}
+	if err := r.Create(ctx, dump); err != nil {
+		return "", fmt.Errorf("failed to create PostgresSyncDump: %w", err)
+	}
+
+	dump.Status = cevichev1alpha1.PostgresSyncDumpStatus{
+		Phase:     PhaseInProgress,
+		StartTime: &now,
+	}
+	if err := r.Status().Update(ctx, dump); err != nil {
+		return "", fmt.Errorf("failed to update PostgresSyncDump status: %w", err)
+	}
+
+	pgSync.Status.LastDump = name
+	return name, nil
+}
+
+// finishDumpRecord records the outcome of a dump run in its PostgresSyncDump and prunes the dump history.
+// Failures are only logged, the outcome is reported by the PostgresSync status either way. Runs that couldn't be
+// recorded have no name and are skipped
+func (r *PostgresSyncReconciler) finishDumpRecord(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, name string, result *dumpResult, dumpErr error, completionTime metav1.Time) {
+	logger := log.FromContext(ctx)
+	if name == "" {
+		return
+	}
+
+	// The record was just created, read it from the API server rather than a cache that may lag behind
+	var reader client.Reader = r.Client
+	if r.APIReader != nil {
+		reader = r.APIReader
+	}
+	key := types.NamespacedName{Name: name, Namespace: pgSync.Namespace}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		dump := &cevichev1alpha1.PostgresSyncDump{}
+		if err := reader.Get(ctx, key, dump); err != nil {
+			return err
+		}
+		finishDump(dump, result, dumpErr, completionTime)
+		return r.Status().Update(ctx, dump)
+	})
+	if err != nil {
+		// Jobs started before dumps were recorded have no PostgresSyncDump
+		if !errors.IsNotFound(err) {
+			logger.Error(err, "unable to update PostgresSyncDump status", "name", name)
+		}
+		return
+	}
+
+	if err := r.pruneDumpHistory(ctx, pgSync); err != nil {
+		logger.Error(err, "unable to prune dump history")
+	}
+}
+
+// finishDump sets the outcome of a dump run in the status of its PostgresSyncDump
+func finishDump(dump *cevichev1alpha1.PostgresSyncDump, result *dumpResult, dumpErr error, completionTime metav1.Time) {
+	dump.Status.CompletionTime = &completionTime
+	if dump.Status.StartTime != nil {
+		dump.Status.Duration = &metav1.Duration{Duration: completionTime.Sub(dump.Status.StartTime.Time).Round(time.Second)}
+	}
+	if dumpErr != nil {
+		dump.Status.Phase = PhaseFailed
+		dump.Status.Error = dumpErr.Error()
+	} else if result != nil && result.Unchanged {
+		dump.Status.Phase = PhaseUnchanged
+	} else {
+		dump.Status.Phase = PhaseSucceeded
+	}
+	if result != nil {
+		dump.Status.Size = result.Size
+		dump.Status.CommitSHA = result.CommitSHA
+		dump.Status.Location = result.Location
+		dump.Status.ChangedTables = result.ChangedTables
+		dump.Status.PullRequestURL = result.PullRequestURL
+	}
+}
+
+// pruneDumpHistory deletes the oldest finished PostgresSyncDumps beyond the history limit of the PostgresSync
+func (r *PostgresSyncReconciler) pruneDumpHistory(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) error {
+	limit := defaultDumpHistoryLimit
+	if pgSync.Spec.DumpHistoryLimit != nil {
+		limit = int(*pgSync.Spec.DumpHistoryLimit)
+	}
+
+	var dumps cevichev1alpha1.PostgresSyncDumpList
+	if err := r.List(ctx, &dumps, client.InNamespace(pgSync.Namespace), client.MatchingLabels{
+		postgresSyncLabel: pgSync.Name,
+	}); err != nil {
+		return fmt.Errorf("failed to list PostgresSyncDumps: %w", err)
+	}
+
+	for _, dump := range dumpsToPrune(dumps.Items, limit) {
+		if err := r.Delete(ctx, &dump); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete PostgresSyncDump %s: %w", dump.Name, err)
+		}
+	}
+	return nil
+}
+
+// dumpsToPrune returns the finished dumps beyond the newest limit ones, dumps still in progress are always kept
+func dumpsToPrune(dumps []cevichev1alpha1.PostgresSyncDump, limit int) []cevichev1alpha1.PostgresSyncDump {
+	var finished []cevichev1alpha1.PostgresSyncDump
+	for _, dump := range dumps {
+		if dump.Status.Phase == PhaseSucceeded || dump.Status.Phase == PhaseFailed || dump.Status.Phase == PhaseUnchanged {
+			finished = append(finished, dump)
+		}
+	}
+	if len(finished) <= limit {
+		return nil
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		if !finished[i].CreationTimestamp.Equal(&finished[j].CreationTimestamp) {
+			return finished[j].CreationTimestamp.Before(&finished[i].CreationTimestamp)
+		}
+		return finished[i].Name > finished[j].Name
+	})
+	return finished[limit:]
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Dump history", func() {
+	It("should describe the pushed dump", func() {
+		remoteDir := GinkgoT().TempDir()
+		_, err := git.PlainInit(remoteDir, true)
+		Expect(err).NotTo(HaveOccurred())
+
+		reconciler := &PostgresSyncReconciler{}
+		repoDir, err := reconciler.cloneRepository(remoteDir, "dumps", nil, cloneOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, repoDir)
+		Expect(os.MkdirAll(filepath.Join(repoDir, "db"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(repoDir, "db", "dump.sql"), []byte("SELECT 1;"), 0644)).To(Succeed())
+		Expect(reconciler.commitAndPushChanges(repoDir, nil, dumpCommit{Branch: "dumps", Author: commitAuthor(&migrationsv1alpha1.PostgresSync{}), Message: "Updated database dump"})).To(Succeed())
+
+		result, err := describeDump(repoDir, remoteDir, filepath.Join("db", "dump.sql"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Size).To(Equal(int64(len("SELECT 1;"))))
+		Expect(result.CommitSHA).To(HaveLen(40))
+		Expect(result.Location).To(Equal(remoteDir + "#dumps:db/dump.sql"))
+	})
+
+	It("should prune the oldest finished dumps beyond the limit", func() {
+		now := time.Now()
+		dump := func(name, phase string, age time.Duration) migrationsv1alpha1.PostgresSyncDump {
+			return migrationsv1alpha1.PostgresSyncDump{
+				ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(now.Add(-age))},
+				Status:     migrationsv1alpha1.PostgresSyncDumpStatus{Phase: phase},
+			}
+		}
+		dumps := []migrationsv1alpha1.PostgresSyncDump{
+			dump("oldest", PhaseFailed, 4*time.Hour),
+			dump("running", PhaseInProgress, 3*time.Hour),
+			dump("old", PhaseSucceeded, 2*time.Hour),
+			dump("newest", PhaseSucceeded, time.Hour),
+		}
+
+		names := func(dumps []migrationsv1alpha1.PostgresSyncDump) []string {
+			var names []string
+			for _, dump := range dumps {
+				names = append(names, dump.Name)
+			}
+			return names
+		}
+		Expect(names(dumpsToPrune(dumps, 1))).To(Equal([]string{"old", "oldest"}))
+		Expect(names(dumpsToPrune(dumps, 3))).To(BeEmpty())
+		Expect(names(dumpsToPrune(dumps, 0))).To(ConsistOf("newest", "old", "oldest"))
+	})
+
+	It("should finish dump records even when the cache lags behind", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(migrationsv1alpha1.AddToScheme(scheme)).To(Succeed())
+		apiServer := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&migrationsv1alpha1.PostgresSyncDump{}).Build()
+		// The cache hasn't seen the record yet
+		cached := interceptor.NewClient(apiServer, interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if _, ok := obj.(*migrationsv1alpha1.PostgresSyncDump); ok {
+					return apierrors.NewNotFound(migrationsv1alpha1.GroupVersion.WithResource("postgressyncdumps").GroupResource(), key.Name)
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+		})
+		reconciler := &PostgresSyncReconciler{Client: cached, APIReader: apiServer, Scheme: scheme}
+
+		pgSync := &migrationsv1alpha1.PostgresSync{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "default", UID: "0b5c1a52"}}
+		name, err := reconciler.recordDumpStarted(ctx, pgSync, migrationsv1alpha1.DumpTriggerSchedule, "")
+		Expect(err).NotTo(HaveOccurred())
+		reconciler.finishDumpRecord(ctx, pgSync, name, &dumpResult{CommitSHA: "abc"}, nil, metav1.Now())
+
+		dump := &migrationsv1alpha1.PostgresSyncDump{}
+		Expect(apiServer.Get(ctx, types.NamespacedName{Name: name, Namespace: "default"}, dump)).To(Succeed())
+		Expect(dump.Status.Phase).To(Equal(PhaseSucceeded))
+		Expect(dump.Status.CommitSHA).To(Equal("abc"))
+	})
+
+	It("should dump even when the run can't be recorded", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(migrationsv1alpha1.AddToScheme(scheme)).To(Succeed())
+		c := interceptor.NewClient(fake.NewClientBuilder().WithScheme(scheme).Build(), interceptor.Funcs{
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				return apierrors.NewForbidden(migrationsv1alpha1.GroupVersion.WithResource("postgressyncdumps").GroupResource(), obj.GetName(), fmt.Errorf("denied"))
+			},
+		})
+		reconciler := &PostgresSyncReconciler{Client: c, APIReader: c, Scheme: scheme}
+
+		// The dump itself fails on its invalid filters, not on the missing record
+		pgSync := &migrationsv1alpha1.PostgresSync{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "default", UID: "0b5c1a52"},
+			Spec:       migrationsv1alpha1.PostgresSyncSpec{Dump: &migrationsv1alpha1.DumpSpec{Schemas: []string{""}}},
+		}
+		name, _, err := reconciler.runDump(ctx, pgSync, migrationsv1alpha1.DumpTriggerWebhook)
+		Expect(name).To(BeEmpty())
+		Expect(err).To(MatchError(ContainSubstring("invalid dump filters")))
+		Expect(pgSync.Status.LastDump).To(BeEmpty())
+	})
+
+	It("should pass dump results through the Job termination message", func() {
+		encoded, err := json.Marshal(operationResult{Message: "done", Dump: &dumpResult{CommitSHA: "abc", Size: 42}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parseOperationResult(string(encoded))).To(Equal(operationResult{
+			Message: "done",
+			Dump:    &dumpResult{CommitSHA: "abc", Size: 42},
+		}))
+
+		// Long lists of changed tables are shortened to fit the termination message
+		dump := &dumpResult{CommitSHA: "abc", Size: 42, Location: "https://git.example.com/acme/db/blob/abc/dumps/dump.sql"}
+		for i := range maxChangedTables {
+			dump.ChangedTables = append(dump.ChangedTables, fmt.Sprintf("public.%0127d", i))
+		}
+		message, err := encodeOperationResult(operationResult{Message: "done", Dump: dump})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(message)).To(BeNumerically("<=", terminationMessageLimit))
+		result := parseOperationResult(message)
+		Expect(result.Message).To(Equal("done"))
+		Expect(result.Dump.CommitSHA).To(Equal("abc"))
+		Expect(result.Dump.Size).To(BeEquivalentTo(42))
+		Expect(result.Dump.ChangedTables).NotTo(BeEmpty())
+		Expect(dump.ChangedTables).To(ContainElements(result.Dump.ChangedTables))
+		Expect(dump.ChangedTables).To(HaveLen(maxChangedTables))
+
+		// Errors and logs written on failure are kept as they are
+		Expect(parseOperationResult("pg_dump: error: connection refused")).To(Equal(operationResult{
+			Message: "pg_dump: error: connection refused",
+		}))
+	})
+})
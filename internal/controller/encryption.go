@@ -0,0 +1,364 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// passphraseKey is the key of the OpenPGP passphrase in the private key Secret
+const passphraseKey = "passphrase"
+
+// dumpEncryptions lists the encryptions of dump files, in the order their dumps are looked for when restoring.
+// The empty type stands for unencrypted dumps
+var dumpEncryptions = []cevichev1alpha1.EncryptionType{
+	"",
+	cevichev1alpha1.EncryptionTypeAge,
+	cevichev1alpha1.EncryptionTypeOpenPGP,
+}
+
+// encrypter wraps a writer so that everything written to it is encrypted
+type encrypter func(w io.Writer) (io.WriteCloser, error)
+
+// decrypter wraps a reader so that everything read from it is decrypted
+type decrypter func(r io.Reader) (io.Reader, error)
+
+// dumpEncryption returns the encryption type dumps of the PostgresSync are encrypted with, empty when
+// they aren't encrypted
+func dumpEncryption(pgSync *cevichev1alpha1.PostgresSync) cevichev1alpha1.EncryptionType {
+	if pgSync.Spec.Dump == nil || pgSync.Spec.Dump.Encryption == nil {
+		return ""
+	}
+	if pgSync.Spec.Dump.Encryption.Type == "" {
+		return cevichev1alpha1.EncryptionTypeAge
+	}
+	return pgSync.Spec.Dump.Encryption.Type
+}
+
+// encryptionExtension returns the file extension of the given encryption, the ones age and gpg use
+func encryptionExtension(encryption cevichev1alpha1.EncryptionType) string {
+	switch encryption {
+	case cevichev1alpha1.EncryptionTypeAge:
+		return ".age"
+	case cevichev1alpha1.EncryptionTypeOpenPGP:
+		return ".gpg"
+	default:
+		return ""
+	}
+}
+
+// dumpEncrypter returns the encrypter for the recipients of the PostgresSync along with the SHA-256 of the
+// recipients, nil and empty when dumps aren't encrypted
+func (r *PostgresSyncReconciler) dumpEncrypter(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) (encrypter, string, error) {
+	encryption := dumpEncryption(pgSync)
+	if encryption == "" {
+		return nil, "", nil
+	}
+
+	recipients := pgSync.Spec.Dump.Encryption.Recipients
+	var keys []byte
+	if recipients.ConfigMapName != "" {
+		configMap := &corev1.ConfigMap{}
+		configMapKey := types.NamespacedName{Name: recipients.ConfigMapName, Namespace: pgSync.Namespace}
+		if err := r.Get(ctx, configMapKey, configMap); err != nil {
+			return nil, "", fmt.Errorf("failed to get encryption recipients: %w", err)
+		}
+		keys = []byte(configMap.Data[recipients.Key])
+	} else {
+		secret := &corev1.Secret{}
+		secretKey := types.NamespacedName{Name: recipients.SecretName, Namespace: pgSync.Namespace}
+		if err := r.Get(ctx, secretKey, secret); err != nil {
+			return nil, "", fmt.Errorf("failed to get encryption recipients: %w", err)
+		}
+		keys = secret.Data[recipients.Key]
+	}
+	if len(bytes.TrimSpace(keys)) == 0 {
+		return nil, "", fmt.Errorf("encryption recipients have no %q key", recipients.Key)
+	}
+
+	encrypt, err := newEncrypter(encryption, keys)
+	if err != nil {
+		return nil, "", err
+	}
+	fingerprint := sha256.Sum256(append([]byte(encryption+"\n"), keys...))
+	return encrypt, hex.EncodeToString(fingerprint[:]), nil
+}
+
+// dumpChecksumKey returns the key checksums of encrypted dumps are keyed with, nil when dumps aren't encrypted
+func (r *PostgresSyncReconciler) dumpChecksumKey(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) ([]byte, error) {
+	if dumpEncryption(pgSync) == "" {
+		return nil, nil
+	}
+
+	checksumKey := pgSync.Spec.Dump.Encryption.ChecksumKey
+	secret := &corev1.Secret{}
+	secretKey := types.NamespacedName{Name: checksumKey.SecretName, Namespace: pgSync.Namespace}
+	if err := r.Get(ctx, secretKey, secret); err != nil {
+		return nil, fmt.Errorf("failed to get checksum key: %w", err)
+	}
+	key := secret.Data[checksumKey.Key]
+	if len(key) == 0 {
+		return nil, fmt.Errorf("checksum key secret %s has no %q key", checksumKey.SecretName, checksumKey.Key)
+	}
+	return key, nil
+}
+
+// newEncrypter parses the public keys of the recipients: age recipients one per line or comma separated
+// as in .sops.yaml, or an armored OpenPGP key ring
+func newEncrypter(encryption cevichev1alpha1.EncryptionType, keys []byte) (encrypter, error) {
+	switch encryption {
+	case cevichev1alpha1.EncryptionTypeAge:
+		recipients, err := age.ParseRecipients(strings.NewReader(strings.ReplaceAll(string(keys), ",", "\n")))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age recipients: %w", err)
+		}
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return age.Encrypt(w, recipients...)
+		}, nil
+	case cevichev1alpha1.EncryptionTypeOpenPGP:
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(keys))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse OpenPGP recipients: %w", err)
+		}
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return openpgp.Encrypt(w, entities, nil, &openpgp.FileHints{IsBinary: true}, nil)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported encryption %q", encryption)
+	}
+}
+
+// dumpDecrypter returns the decrypter for dumps of the given encryption, from the private key Secret of
+// the PostgresSync
+func (r *PostgresSyncReconciler) dumpDecrypter(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, encryption cevichev1alpha1.EncryptionType) (decrypter, error) {
+	if pgSync.Spec.Dump == nil || pgSync.Spec.Dump.Encryption == nil || pgSync.Spec.Dump.Encryption.PrivateKey == nil {
+		return nil, fmt.Errorf("dump is encrypted with %s but no private key is configured in spec.dump.encryption.privateKey", encryption)
+	}
+
+	privateKey := pgSync.Spec.Dump.Encryption.PrivateKey
+	secret := &corev1.Secret{}
+	secretKey := types.NamespacedName{Name: privateKey.SecretName, Namespace: pgSync.Namespace}
+	if err := r.Get(ctx, secretKey, secret); err != nil {
+		return nil, fmt.Errorf("failed to get encryption private key: %w", err)
+	}
+	key := secret.Data[privateKey.Key]
+	if len(key) == 0 {
+		return nil, fmt.Errorf("private key secret %s has no %q key", privateKey.SecretName, privateKey.Key)
+	}
+
+	return newDecrypter(encryption, key, secret.Data[passphraseKey])
+}
+
+// newDecrypter parses a private key: age identities as in a SOPS keys.txt, or an armored OpenPGP key
+// ring, decrypted with the passphrase if it is protected
+func newDecrypter(encryption cevichev1alpha1.EncryptionType, key, passphrase []byte) (decrypter, error) {
+	switch encryption {
+	case cevichev1alpha1.EncryptionTypeAge:
+		identities, err := age.ParseIdentities(bytes.NewReader(key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age identities: %w", err)
+		}
+		return func(r io.Reader) (io.Reader, error) {
+			return age.Decrypt(r, identities...)
+		}, nil
+	case cevichev1alpha1.EncryptionTypeOpenPGP:
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse OpenPGP private key: %w", err)
+		}
+		for _, entity := range entities {
+			if entity.PrivateKey == nil || !entity.PrivateKey.Encrypted {
+				continue
+			}
+			if len(passphrase) == 0 {
+				return nil, fmt.Errorf("OpenPGP private key is protected, %q is required in the private key secret", passphraseKey)
+			}
+			if err := entity.DecryptPrivateKeys(passphrase); err != nil {
+				return nil, fmt.Errorf("failed to unlock OpenPGP private key: %w", err)
+			}
+		}
+		return func(r io.Reader) (io.Reader, error) {
+			message, err := openpgp.ReadMessage(r, entities, nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			return message.UnverifiedBody, nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported encryption %q", encryption)
+	}
+}
+
+// encryptDump encrypts the dump at path and returns its new path. Files are replaced by path plus the
+// extension of the encryption, directory dumps keep their path and have each of their files encrypted
+func encryptDump(path string, encryption cevichev1alpha1.EncryptionType, encrypt encrypter) (string, error) {
+	extension := encryptionExtension(encryption)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read dump: %w", err)
+	}
+	if !info.IsDir() {
+		return transformFile(path, path+extension, func(w io.Writer, r io.Reader) error {
+			return encryptStream(w, r, encrypt)
+		})
+	}
+
+	err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		_, err = transformFile(file, file+extension, func(w io.Writer, r io.Reader) error {
+			return encryptStream(w, r, encrypt)
+		})
+		return err
+	})
+	return path, err
+}
+
+// detectEncryption returns the encryption of the dump at path from its extension, or for directory dumps
+// from the extension of their table of contents
+func detectEncryption(path string) cevichev1alpha1.EncryptionType {
+	for _, encryption := range dumpEncryptions {
+		extension := encryptionExtension(encryption)
+		if extension == "" {
+			continue
+		}
+		if strings.HasSuffix(path, extension) {
+			return encryption
+		}
+		if _, err := os.Stat(filepath.Join(path, "toc.dat"+extension)); err == nil {
+			return encryption
+		}
+	}
+	return ""
+}
+
+// decryptDump decrypts the dump at path into workDir and returns the path of the decrypted dump, named
+// without its extension. Directory dumps are decrypted file by file. The encrypted dump is left untouched, so
+// the plain dump never lands in a checkout that may be kept between operations
+func decryptDump(path, workDir string, encryption cevichev1alpha1.EncryptionType, decrypt decrypter) (string, error) {
+	extension := encryptionExtension(encryption)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read dump: %w", err)
+	}
+	decryptFile := func(file, newPath string) error {
+		return writeTransformed(file, newPath, func(w io.Writer, r io.Reader) error {
+			return decryptStream(w, r, decrypt)
+		})
+	}
+	if !info.IsDir() {
+		decryptedPath := filepath.Join(workDir, strings.TrimSuffix(filepath.Base(path), extension))
+		if err := decryptFile(path, decryptedPath); err != nil {
+			return "", err
+		}
+		return decryptedPath, nil
+	}
+
+	decryptedDir := filepath.Join(workDir, filepath.Base(path))
+	err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(path, file)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(decryptedDir, relative)
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, 0700)
+		case strings.HasSuffix(file, extension):
+			return decryptFile(file, strings.TrimSuffix(target, extension))
+		default:
+			return copyFile(file, target)
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return decryptedDir, nil
+}
+
+// encryptStream encrypts everything read from r into w
+func encryptStream(w io.Writer, r io.Reader, encrypt encrypter) error {
+	writer, err := encrypt(w)
+	if err != nil {
+		return fmt.Errorf("failed to start encryption: %w", err)
+	}
+	if _, err := io.Copy(writer, r); err != nil {
+		return fmt.Errorf("failed to encrypt dump: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt dump: %w", err)
+	}
+	return nil
+}
+
+// decryptStream decrypts everything read from r into w
+func decryptStream(w io.Writer, r io.Reader, decrypt decrypter) error {
+	reader, err := decrypt(r)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt dump: %w", err)
+	}
+	// Reading the whole message also checks its integrity
+	if _, err := io.Copy(w, reader); err != nil {
+		return fmt.Errorf("failed to decrypt dump: %w", err)
+	}
+	return nil
+}
+
+// transformFile writes the file at path transformed by transform to newPath, removes the original and
+// returns newPath
+func transformFile(path, newPath string, transform func(w io.Writer, r io.Reader) error) (string, error) {
+	if err := writeTransformed(path, newPath, transform); err != nil {
+		return "", err
+	}
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("failed to remove %s: %w", filepath.Base(path), err)
+	}
+	return newPath, nil
+}
+
+// writeTransformed writes the file at path transformed by transform to newPath, which is removed when
+// transform fails halfway
+func writeTransformed(path, newPath string, transform func(w io.Writer, r io.Reader) error) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open dump: %w", err)
+	}
+	defer in.Close() //nolint:errcheck
+
+	out, err := os.Create(newPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Base(newPath), err)
+	}
+	defer func() {
+		if err != nil {
+			_ = out.Close()
+			_ = os.Remove(newPath)
+		}
+	}()
+
+	if err := transform(out, in); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(newPath), err)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Dump encryption", func() {
+	dump := strings.Repeat("INSERT INTO t VALUES (1);\n", 100)
+
+	// ageKeys returns two comma separated recipients as in .sops.yaml and the keys.txt of the second one
+	ageKeys := func() ([]byte, []byte) {
+		identity, err := age.GenerateX25519Identity()
+		Expect(err).NotTo(HaveOccurred())
+		other, err := age.GenerateX25519Identity()
+		Expect(err).NotTo(HaveOccurred())
+		recipients := other.Recipient().String() + "," + identity.Recipient().String()
+		keys := "# created: 2024-01-01T00:00:00Z\n# public key: " + identity.Recipient().String() + "\n" + identity.String() + "\n"
+		return []byte(recipients), []byte(keys)
+	}
+
+	// openpgpKeys returns an armored public key and the armored private key protected by the passphrase
+	openpgpKeys := func(passphrase []byte) ([]byte, []byte) {
+		entity, err := openpgp.NewEntity("backup", "", "backup@example.com", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		var public bytes.Buffer
+		writer, err := armor.Encode(&public, openpgp.PublicKeyType, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entity.Serialize(writer)).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+
+		if passphrase != nil {
+			Expect(entity.EncryptPrivateKeys(passphrase, nil)).To(Succeed())
+		}
+		var private bytes.Buffer
+		writer, err = armor.Encode(&private, openpgp.PrivateKeyType, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entity.SerializePrivateWithoutSigning(writer, nil)).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+		return public.Bytes(), private.Bytes()
+	}
+
+	DescribeTable("should encrypt dumps and decrypt them on restore",
+		func(encryption migrationsv1alpha1.EncryptionType, extension string, passphrase []byte) {
+			var recipients, privateKey []byte
+			if encryption == migrationsv1alpha1.EncryptionTypeAge {
+				recipients, privateKey = ageKeys()
+			} else {
+				recipients, privateKey = openpgpKeys(passphrase)
+			}
+
+			dumpsDir := GinkgoT().TempDir()
+			path := filepath.Join(dumpsDir, "dump.sql")
+			Expect(os.WriteFile(path, []byte(dump), 0644)).To(Succeed())
+			compressedPath, err := compressFile(path, migrationsv1alpha1.DumpCompressionGzip, 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			encrypt, err := newEncrypter(encryption, recipients)
+			Expect(err).NotTo(HaveOccurred())
+			encryptedPath, err := encryptDump(compressedPath, encryption, encrypt)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(encryptedPath).To(Equal(path + ".gz" + extension))
+			Expect(compressedPath).NotTo(BeAnExistingFile())
+
+			found, format := findDumpArtifact(dumpsDir, migrationsv1alpha1.DumpFormatPlain)
+			Expect(found).To(Equal(encryptedPath))
+			Expect(format).To(Equal(migrationsv1alpha1.DumpFormatPlain))
+			Expect(detectEncryption(found)).To(Equal(encryption))
+
+			decrypt, err := newDecrypter(encryption, privateKey, passphrase)
+			Expect(err).NotTo(HaveOccurred())
+			workDir := GinkgoT().TempDir()
+			decryptedPath, err := decryptDump(found, workDir, encryption, decrypt)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decryptedPath).To(Equal(filepath.Join(workDir, "dump.sql.gz")))
+			Expect(found).To(BeAnExistingFile())
+			Expect(compressedPath).NotTo(BeAnExistingFile())
+			decompressedPath, err := decompressFile(decryptedPath, workDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.ReadFile(decompressedPath)).To(BeEquivalentTo(dump))
+		},
+		Entry("age", migrationsv1alpha1.EncryptionTypeAge, ".age", nil),
+		Entry("OpenPGP", migrationsv1alpha1.EncryptionTypeOpenPGP, ".gpg", nil),
+		Entry("OpenPGP with a protected key", migrationsv1alpha1.EncryptionTypeOpenPGP, ".gpg", []byte("secret")),
+	)
+
+	It("should encrypt directory dumps file by file", func() {
+		recipients, privateKey := ageKeys()
+		dumpsDir := GinkgoT().TempDir()
+		path := filepath.Join(dumpsDir, "dump")
+		Expect(os.Mkdir(path, 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(path, "toc.dat"), []byte("toc"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(path, "3001.dat.gz"), []byte(dump), 0644)).To(Succeed())
+
+		encrypt, err := newEncrypter(migrationsv1alpha1.EncryptionTypeAge, recipients)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encryptDump(path, migrationsv1alpha1.EncryptionTypeAge, encrypt)).To(Equal(path))
+		Expect(filepath.Join(path, "toc.dat.age")).To(BeAnExistingFile())
+		Expect(filepath.Join(path, "3001.dat.gz.age")).To(BeAnExistingFile())
+
+		found, format := findDumpArtifact(dumpsDir, migrationsv1alpha1.DumpFormatPlain)
+		Expect(found).To(Equal(path))
+		Expect(format).To(Equal(migrationsv1alpha1.DumpFormatDirectory))
+		Expect(detectEncryption(found)).To(Equal(migrationsv1alpha1.EncryptionTypeAge))
+
+		decrypt, err := newDecrypter(migrationsv1alpha1.EncryptionTypeAge, privateKey, nil)
+		Expect(err).NotTo(HaveOccurred())
+		workDir := GinkgoT().TempDir()
+		Expect(decryptDump(found, workDir, migrationsv1alpha1.EncryptionTypeAge, decrypt)).To(Equal(filepath.Join(workDir, "dump")))
+		Expect(os.ReadFile(filepath.Join(workDir, "dump", "toc.dat"))).To(BeEquivalentTo("toc"))
+		Expect(os.ReadFile(filepath.Join(workDir, "dump", "3001.dat.gz"))).To(BeEquivalentTo(dump))
+		Expect(filepath.Join(path, "toc.dat")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(path, "toc.dat.age")).To(BeAnExistingFile())
+	})
+
+	It("should not decrypt dumps with another key", func() {
+		recipients, _ := ageKeys()
+		_, otherKey := ageKeys()
+		path := filepath.Join(GinkgoT().TempDir(), "dump.sql")
+		Expect(os.WriteFile(path, []byte(dump), 0644)).To(Succeed())
+
+		encrypt, err := newEncrypter(migrationsv1alpha1.EncryptionTypeAge, recipients)
+		Expect(err).NotTo(HaveOccurred())
+		encryptedPath, err := encryptDump(path, migrationsv1alpha1.EncryptionTypeAge, encrypt)
+		Expect(err).NotTo(HaveOccurred())
+
+		decrypt, err := newDecrypter(migrationsv1alpha1.EncryptionTypeAge, otherKey, nil)
+		Expect(err).NotTo(HaveOccurred())
+		workDir := GinkgoT().TempDir()
+		_, err = decryptDump(encryptedPath, workDir, migrationsv1alpha1.EncryptionTypeAge, decrypt)
+		Expect(err).To(HaveOccurred())
+		// Nothing partly decrypted is left behind
+		Expect(os.ReadDir(workDir)).To(BeEmpty())
+		Expect(encryptedPath).To(BeAnExistingFile())
+	})
+
+	It("should require the passphrase of protected OpenPGP keys", func() {
+		_, privateKey := openpgpKeys([]byte("secret"))
+		_, err := newDecrypter(migrationsv1alpha1.EncryptionTypeOpenPGP, privateKey, nil)
+		Expect(err).To(MatchError(ContainSubstring("passphrase")))
+	})
+
+	It("should remove encrypted dumps of every format", func() {
+		dumpsDir := GinkgoT().TempDir()
+		for _, name := range []string{"dump.sql.zst.age", "dump.dump.gpg", "dump.tar.gpg"} {
+			Expect(os.WriteFile(filepath.Join(dumpsDir, name), nil, 0644)).To(Succeed())
+		}
+		Expect(removeDumpArtifacts(dumpsDir)).To(Succeed())
+		Expect(os.ReadDir(dumpsDir)).To(BeEmpty())
+	})
+})
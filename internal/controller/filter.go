@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// dumpFilter is a list of patterns of spec.dump mapped onto a pg_dump selector
+type dumpFilter struct {
+	// field is the spec field the patterns come from, for error messages
+	field    string
+	flag     string
+	patterns []string
+	// maxNames is how many dot separated names a pattern may have, database.schema or database.schema.table
+	maxNames int
+}
+
+// dumpFilters returns the schema and table filters of the PostgresSync
+func dumpFilters(pgSync *cevichev1alpha1.PostgresSync) []dumpFilter {
+	dump := pgSync.Spec.Dump
+	if dump == nil {
+		return nil
+	}
+	return []dumpFilter{
+		{field: "schemas", flag: "--schema", patterns: dump.Schemas, maxNames: 2},
+		{field: "excludeSchemas", flag: "--exclude-schema", patterns: dump.ExcludeSchemas, maxNames: 2},
+		{field: "includeTables", flag: "--table", patterns: dump.IncludeTables, maxNames: 3},
+		{field: "excludeTables", flag: "--exclude-table", patterns: dump.ExcludeTables, maxNames: 3},
+		{field: "excludeTableData", flag: "--exclude-table-data", patterns: dump.ExcludeTableData, maxNames: 3},
+	}
+}
+
+// filterArgs returns the pg_dump arguments selecting the schemas and tables of the PostgresSync
+func filterArgs(pgSync *cevichev1alpha1.PostgresSync) []string {
+	var args []string
+	for _, filter := range dumpFilters(pgSync) {
+		for _, pattern := range filter.patterns {
+			args = append(args, filter.flag+"="+pattern)
+		}
+	}
+	return args
+}
+
+// validateDumpFilters checks the schema and table patterns of the PostgresSync, so a typo fails before
+// pg_dump runs instead of silently dumping more or less than expected
+func validateDumpFilters(pgSync *cevichev1alpha1.PostgresSync) error {
+	var errs []error
+	for _, filter := range dumpFilters(pgSync) {
+		for i, pattern := range filter.patterns {
+			if err := validatePattern(pattern, filter.maxNames); err != nil {
+				errs = append(errs, fmt.Errorf("spec.dump.%s[%d]: invalid pattern %q: %w", filter.field, i, pattern, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validatePattern checks a pg_dump pattern
+func validatePattern(pattern string, maxNames int) error {
+	_, err := compilePattern(pattern, maxNames)
+	return err
+}
+
+// compilePattern translates a pg_dump pattern, like psql, into a regular expression per dot separated
+// name: * and ? are wildcards, double quotes match literally and other characters keep their regular
+// expression meaning. An empty name matches any name
+func compilePattern(pattern string, maxNames int) ([]*regexp.Regexp, error) {
+	if strings.TrimSpace(pattern) == "" {
+		return nil, errors.New("pattern is empty")
+	}
+
+	names := []string{""}
+	inQuotes := false
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case unicode.IsControl(c):
+			return nil, errors.New("pattern contains control characters")
+		case c == '"':
+			// A doubled quote inside quotes stands for a literal quote
+			if inQuotes && i+1 < len(runes) && runes[i+1] == '"' {
+				names[len(names)-1] += regexp.QuoteMeta(`"`)
+				i++
+				continue
+			}
+			inQuotes = !inQuotes
+		case inQuotes:
+			names[len(names)-1] += regexp.QuoteMeta(string(c))
+		case c == '.':
+			names = append(names, "")
+		case c == '*':
+			names[len(names)-1] += ".*"
+		case c == '?':
+			names[len(names)-1] += "."
+		case c == '$':
+			names[len(names)-1] += `\$`
+		default:
+			names[len(names)-1] += string(unicode.ToLower(c))
+		}
+	}
+
+	if inQuotes {
+		return nil, errors.New("unterminated double quote")
+	}
+	if len(names) > maxNames {
+		return nil, fmt.Errorf("too many dotted names, at most %d are allowed", maxNames)
+	}
+	regexps := make([]*regexp.Regexp, len(names))
+	for i, name := range names {
+		if name == "" {
+			name = ".*"
+		}
+		re, err := regexp.Compile("^(" + name + ")$")
+		if err != nil {
+			return nil, err
+		}
+		regexps[i] = re
+	}
+	return regexps, nil
+}
+
+// relationFilter selects tables and sequences like the pg_dump selectors of a PostgresSync do
+type relationFilter struct {
+	schemas, excludeSchemas, tables, excludeTables, excludeTableData [][]*regexp.Regexp
+}
+
+// newRelationFilter compiles the schema and table patterns of the PostgresSync
+func newRelationFilter(pgSync *cevichev1alpha1.PostgresSync) (*relationFilter, error) {
+	if err := validateDumpFilters(pgSync); err != nil {
+		return nil, err
+	}
+	f := &relationFilter{}
+	targets := map[string]*[][]*regexp.Regexp{
+		"schemas":          &f.schemas,
+		"excludeSchemas":   &f.excludeSchemas,
+		"includeTables":    &f.tables,
+		"excludeTables":    &f.excludeTables,
+		"excludeTableData": &f.excludeTableData,
+	}
+	for _, filter := range dumpFilters(pgSync) {
+		for _, pattern := range filter.patterns {
+			compiled, _ := compilePattern(pattern, filter.maxNames)
+			*targets[filter.field] = append(*targets[filter.field], compiled)
+		}
+	}
+	return f, nil
+}
+
+// includes reports whether the relation is dumped. Like pg_dump, table patterns take precedence over
+// schema patterns
+func (f *relationFilter) includes(schema, name string) bool {
+	if matchesRelation(f.excludeTables, schema, name) {
+		return false
+	}
+	if len(f.tables) > 0 {
+		return matchesRelation(f.tables, schema, name)
+	}
+	if len(f.schemas) > 0 && !matchesSchema(f.schemas, schema) {
+		return false
+	}
+	return !matchesSchema(f.excludeSchemas, schema)
+}
+
+// includesData reports whether the rows of the relation are dumped
+func (f *relationFilter) includesData(schema, name string) bool {
+	return f.includes(schema, name) && !matchesRelation(f.excludeTableData, schema, name)
+}
+
+// matchesSchema reports whether a schema pattern matches the schema, ignoring database names
+func matchesSchema(patterns [][]*regexp.Regexp, schema string) bool {
+	for _, pattern := range patterns {
+		if pattern[len(pattern)-1].MatchString(schema) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesRelation reports whether a table pattern matches the relation. Unqualified patterns match
+// relations of any schema, database names are ignored
+func matchesRelation(patterns [][]*regexp.Regexp, schema, name string) bool {
+	for _, pattern := range patterns {
+		if !pattern[len(pattern)-1].MatchString(name) {
+			continue
+		}
+		if len(pattern) == 1 || pattern[len(pattern)-2].MatchString(schema) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Dump filters", func() {
+	It("should map patterns onto pg_dump selectors", func() {
+		pgSync := &migrationsv1alpha1.PostgresSync{Spec: migrationsv1alpha1.PostgresSyncSpec{
+			Dump: &migrationsv1alpha1.DumpSpec{
+				Schemas:          []string{"public", "app_*"},
+				ExcludeSchemas:   []string{"pg_temp?"},
+				IncludeTables:    []string{"public.users"},
+				ExcludeTables:    []string{`"Legacy"."Orders"`},
+				ExcludeTableData: []string{"*.audit_log", "sessions"},
+			},
+		}}
+		Expect(validateDumpFilters(pgSync)).To(Succeed())
+		Expect(dumpArgs(pgSync)).To(Equal([]string{
+			"--format=plain",
+			"--schema=public",
+			"--schema=app_*",
+			"--exclude-schema=pg_temp?",
+			"--table=public.users",
+			`--exclude-table="Legacy"."Orders"`,
+			"--exclude-table-data=*.audit_log",
+			"--exclude-table-data=sessions",
+		}))
+	})
+
+	It("should not add selectors without filters", func() {
+		Expect(filterArgs(&migrationsv1alpha1.PostgresSync{})).To(BeEmpty())
+		Expect(validateDumpFilters(&migrationsv1alpha1.PostgresSync{})).To(Succeed())
+	})
+
+	DescribeTable("should validate patterns",
+		func(pattern string, maxNames int, valid bool) {
+			err := validatePattern(pattern, maxNames)
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(HaveOccurred())
+			}
+		},
+		Entry("a name", "users", 3, true),
+		Entry("wildcards", "audit_*_20??", 3, true),
+		Entry("a qualified table", "db.public.users", 3, true),
+		Entry("quoted dots", `"my.schema"`, 2, true),
+		Entry("a doubled quote", `"say ""hi"""`, 2, true),
+		Entry("a character class", "log_[0-9]*", 3, true),
+		Entry("an empty pattern", " ", 3, false),
+		Entry("an unterminated quote", `"users`, 3, false),
+		Entry("too many names for a schema", "db.public.users", 2, false),
+		Entry("too many names for a table", "a.b.c.d", 3, false),
+		Entry("an unbalanced bracket", "log_[0-9", 3, false),
+		Entry("a control character", "users\n", 3, false),
+	)
+
+	It("should report every invalid pattern with its field", func() {
+		pgSync := &migrationsv1alpha1.PostgresSync{Spec: migrationsv1alpha1.PostgresSyncSpec{
+			Dump: &migrationsv1alpha1.DumpSpec{
+				Schemas:       []string{"public", "a.b.c"},
+				ExcludeTables: []string{`"users`},
+			},
+		}}
+		err := validateDumpFilters(pgSync)
+		Expect(err).To(MatchError(ContainSubstring("spec.dump.schemas[1]")))
+		Expect(err).To(MatchError(ContainSubstring("spec.dump.excludeTables[0]")))
+	})
+})
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// dumpFormats lists the supported formats, in the order their dumps are looked for when restoring
+var dumpFormats = []cevichev1alpha1.DumpFormat{
+	cevichev1alpha1.DumpFormatPlain,
+	cevichev1alpha1.DumpFormatCustom,
+	cevichev1alpha1.DumpFormatDirectory,
+	cevichev1alpha1.DumpFormatTar,
+}
+
+// dumpFormat returns the format dumps of the PostgresSync are taken in
+func dumpFormat(pgSync *cevichev1alpha1.PostgresSync) cevichev1alpha1.DumpFormat {
+	if pgSync.Spec.Dump == nil || pgSync.Spec.Dump.Format == "" {
+		return cevichev1alpha1.DumpFormatPlain
+	}
+	return pgSync.Spec.Dump.Format
+}
+
+// dumpArtifact returns the name of the file or directory a dump of the given format is stored as
+func dumpArtifact(format cevichev1alpha1.DumpFormat) string {
+	switch format {
+	case cevichev1alpha1.DumpFormatCustom:
+		return "dump.dump"
+	case cevichev1alpha1.DumpFormatDirectory:
+		return "dump"
+	case cevichev1alpha1.DumpFormatTar:
+		return "dump.tar"
+	default:
+		return "dump.sql"
+	}
+}
+
+// dumpArgs returns the pg_dump arguments selecting the output format, compression, schemas and tables of
+// the PostgresSync
+func dumpArgs(pgSync *cevichev1alpha1.PostgresSync) []string {
+	format := dumpFormat(pgSync)
+	args := []string{"--format=" + string(format)}
+	if format == cevichev1alpha1.DumpFormatDirectory && pgSync.Spec.Dump.Jobs != nil {
+		args = append(args, "--jobs="+strconv.Itoa(int(*pgSync.Spec.Dump.Jobs)))
+	}
+	if !compressedInGo(format) {
+		args = append(args, pgDumpCompressionArgs(dumpCompression(pgSync))...)
+	}
+	return append(args, filterArgs(pgSync)...)
+}
+
+// removeDumpArtifacts removes the dumps of every format, compression, encryption and layout from dumpsDir,
+// so a dump taken in a new format doesn't leave a stale one behind
+func removeDumpArtifacts(dumpsDir string) error {
+	for _, format := range dumpFormats {
+		for _, compression := range dumpCompressions {
+			for _, encryption := range dumpEncryptions {
+				name := dumpArtifact(format) + compressionExtension(compression) + encryptionExtension(encryption)
+				if err := os.RemoveAll(filepath.Join(dumpsDir, name)); err != nil {
+					return fmt.Errorf("failed to remove previous %s dump: %w", format, err)
+				}
+			}
+		}
+	}
+	for _, name := range tableArtifacts {
+		if err := os.RemoveAll(filepath.Join(dumpsDir, name)); err != nil {
+			return fmt.Errorf("failed to remove previous per-table dump: %w", err)
+		}
+	}
+	return nil
+}
+
+// findDumpArtifact returns the path and format of the dump stored in dumpsDir, preferring the given format
+// Plain and tar dumps may be compressed and files of any format encrypted. It returns an empty path when
+// there is no dump
+func findDumpArtifact(dumpsDir string, preferred cevichev1alpha1.DumpFormat) (string, cevichev1alpha1.DumpFormat) {
+	for _, format := range append([]cevichev1alpha1.DumpFormat{preferred}, dumpFormats...) {
+		// Directory dumps keep their name, their files are encrypted one by one
+		if format == cevichev1alpha1.DumpFormatDirectory {
+			path := filepath.Join(dumpsDir, dumpArtifact(format))
+			if info, err := os.Stat(path); err != nil || !info.IsDir() {
+				continue
+			}
+			// Directory dumps always contain their table of contents
+			for _, encryption := range dumpEncryptions {
+				if _, err := os.Stat(filepath.Join(path, "toc.dat"+encryptionExtension(encryption))); err == nil {
+					return path, format
+				}
+			}
+			continue
+		}
+
+		compressions := []cevichev1alpha1.DumpCompression{cevichev1alpha1.DumpCompressionNone}
+		if compressedInGo(format) {
+			compressions = dumpCompressions
+		}
+		for _, compression := range compressions {
+			for _, encryption := range dumpEncryptions {
+				path := filepath.Join(dumpsDir, dumpArtifact(format)+compressionExtension(compression)+encryptionExtension(encryption))
+				if info, err := os.Stat(path); err == nil && !info.IsDir() {
+					return path, format
+				}
+			}
+		}
+	}
+	return "", ""
+}
+
+// restoreCommand returns the command restoring the dump at path, psql for plain dumps and pg_restore
+// for archives
+func restoreCommand(pgSync *cevichev1alpha1.PostgresSync, conn *databaseConnection, path string, format cevichev1alpha1.DumpFormat) *exec.Cmd {
+	if format == cevichev1alpha1.DumpFormatPlain {
+		return exec.Command("psql", append(conn.args(), "-f", path)...)
+	}
+
+	args := append(conn.args(),
+		"--format="+string(format),
+		"--clean",
+		"--if-exists",
+		"--no-owner",
+		"--no-privileges",
+	)
+	// pg_restore can't run tar archives in parallel
+	if format != cevichev1alpha1.DumpFormatTar && pgSync.Spec.Restore != nil && pgSync.Spec.Restore.Jobs != nil {
+		args = append(args, "--jobs="+strconv.Itoa(int(*pgSync.Spec.Restore.Jobs)))
+	}
+	return exec.Command("pg_restore", append(args, path)...)
+}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Dump formats", func() {
+	jobs := func(n int32) *int32 { return &n }
+	conn := &databaseConnection{host: "db", port: "5432", name: "app", user: "app"}
+
+	It("should find the dump in whichever format it was taken", func() {
+		dumpsDir := GinkgoT().TempDir()
+		path, _ := findDumpArtifact(dumpsDir, migrationsv1alpha1.DumpFormatPlain)
+		Expect(path).To(BeEmpty())
+
+		// Directory dumps only count once they have a table of contents
+		Expect(os.MkdirAll(filepath.Join(dumpsDir, "dump"), 0755)).To(Succeed())
+		path, _ = findDumpArtifact(dumpsDir, migrationsv1alpha1.DumpFormatPlain)
+		Expect(path).To(BeEmpty())
+
+		Expect(os.WriteFile(filepath.Join(dumpsDir, "dump", "toc.dat"), nil, 0644)).To(Succeed())
+		path, format := findDumpArtifact(dumpsDir, migrationsv1alpha1.DumpFormatPlain)
+		Expect(path).To(Equal(filepath.Join(dumpsDir, "dump")))
+		Expect(format).To(Equal(migrationsv1alpha1.DumpFormatDirectory))
+
+		Expect(os.WriteFile(filepath.Join(dumpsDir, "dump.tar"), nil, 0644)).To(Succeed())
+		_, format = findDumpArtifact(dumpsDir, migrationsv1alpha1.DumpFormatTar)
+		Expect(format).To(Equal(migrationsv1alpha1.DumpFormatTar))
+
+		Expect(removeDumpArtifacts(dumpsDir)).To(Succeed())
+		Expect(os.ReadDir(dumpsDir)).To(BeEmpty())
+	})
+
+	It("should only dump and restore in parallel where pg_dump and pg_restore support it", func() {
+		pgSync := &migrationsv1alpha1.PostgresSync{Spec: migrationsv1alpha1.PostgresSyncSpec{
+			Dump:    &migrationsv1alpha1.DumpSpec{Format: migrationsv1alpha1.DumpFormatDirectory, Jobs: jobs(4)},
+			Restore: &migrationsv1alpha1.RestoreSpec{Jobs: jobs(8)},
+		}}
+		Expect(dumpArgs(pgSync)).To(Equal([]string{"--format=directory", "--jobs=4"}))
+		Expect(restoreCommand(pgSync, conn, "dump", migrationsv1alpha1.DumpFormatDirectory).Args).To(ContainElement("--jobs=8"))
+		Expect(restoreCommand(pgSync, conn, "dump.tar", migrationsv1alpha1.DumpFormatTar).Args).NotTo(ContainElement("--jobs=8"))
+
+		pgSync.Spec.Dump.Format = migrationsv1alpha1.DumpFormatCustom
+		Expect(dumpArgs(pgSync)).To(Equal([]string{"--format=custom"}))
+
+		plain := restoreCommand(pgSync, conn, "dump.sql", migrationsv1alpha1.DumpFormatPlain)
+		Expect(filepath.Base(plain.Path)).To(Equal("psql"))
+		Expect(plain.Args).To(ContainElements("-f", "dump.sql"))
+	})
+})
@@ -0,0 +1,613 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// Keys read from the Git credentials Secret
+const (
+	gitUsernameKey   = "username"
+	gitPasswordKey   = "password"
+	gitPrivateKeyKey = corev1.SSHAuthPrivateKey
+	gitPassphraseKey = "passphrase"
+	gitKnownHostsKey = "known_hosts"
+)
+
+// getGitAuth builds the Git transport authentication for a PostgresSync from its credentials Secret
+func (r *PostgresSyncReconciler) getGitAuth(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) (transport.AuthMethod, error) {
+	gitSecret := &corev1.Secret{}
+	gitSecretKey := types.NamespacedName{
+		Name:      pgSync.Spec.GitCredentials.SecretName,
+		Namespace: pgSync.Namespace,
+	}
+	if err := r.Get(ctx, gitSecretKey, gitSecret); err != nil {
+		return nil, fmt.Errorf("failed to get Git credentials: %w", err)
+	}
+
+	return gitAuthFromSecret(pgSync.Spec.RepositoryURL, gitSecret)
+}
+
+// gitAuthFromSecret picks the transport from the repository URL: SSH URLs such as
+// git@host:org/repo.git use a private key, HTTP(S) URLs use basic auth
+func gitAuthFromSecret(repoURL string, secret *corev1.Secret) (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository URL: %w", err)
+	}
+
+	switch endpoint.Protocol {
+	case "ssh":
+		privateKey := secret.Data[gitPrivateKeyKey]
+		if len(privateKey) == 0 {
+			return nil, fmt.Errorf("%s is required in Git secret for SSH repository URLs", gitPrivateKeyKey)
+		}
+
+		// Host keys are always verified, there is no insecure fallback
+		knownHosts := secret.Data[gitKnownHostsKey]
+		if len(knownHosts) == 0 {
+			return nil, fmt.Errorf("%s is required in Git secret for SSH repository URLs", gitKnownHostsKey)
+		}
+
+		user := endpoint.User
+		if user == "" {
+			user = "git"
+		}
+
+		auth, err := gitssh.NewPublicKeys(user, privateKey, string(secret.Data[gitPassphraseKey]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH private key: %w", err)
+		}
+
+		auth.HostKeyCallback, err = knownHostsCallback(knownHosts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse known_hosts: %w", err)
+		}
+
+		return auth, nil
+	case "http", "https":
+		return &http.BasicAuth{
+			Username: string(secret.Data[gitUsernameKey]),
+			Password: string(secret.Data[gitPasswordKey]),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported repository URL scheme %q", endpoint.Protocol)
+	}
+}
+
+// knownHostsCallback builds a strict host key callback from known_hosts content
+func knownHostsCallback(knownHosts []byte) (ssh.HostKeyCallback, error) {
+	// The known_hosts parser only reads files, it loads them eagerly so the file can go right away
+	file, err := os.CreateTemp("", "known_hosts-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create known_hosts file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(file.Name())
+	}()
+
+	if _, err := file.Write(knownHosts); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to write known_hosts file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write known_hosts file: %w", err)
+	}
+
+	return gitssh.NewKnownHostsCallback(file.Name())
+}
+
+// gitBranch returns the branch configured for a PostgresSync, empty for the remote's default branch
+func gitBranch(pgSync *cevichev1alpha1.PostgresSync) string {
+	if pgSync.Spec.Git == nil {
+		return ""
+	}
+	return pgSync.Spec.Git.Branch
+}
+
+// resolveRemoteBranch checks whether branch exists on the remote, an empty remote has no branches
+// An empty branch resolves to the remote's default branch
+func resolveRemoteBranch(repoURL, branch string, auth transport.AuthMethod) (string, bool, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{repoURL},
+	})
+
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+			return branch, false, nil
+		}
+		return branch, false, fmt.Errorf("failed to list remote references: %w", err)
+	}
+
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if branch == "" {
+		for _, ref := range refs {
+			if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+				branchRef = ref.Target()
+			}
+		}
+	}
+	for _, ref := range refs {
+		if ref.Name() == branchRef {
+			return branchRef.Short(), true, nil
+		}
+	}
+	return branch, false, nil
+}
+
+// initOrphanBranch initializes an empty repository in dir whose HEAD points to a branch without history,
+// so the first commit creates the branch when pushed
+func initOrphanBranch(dir, repoURL, branch string) error {
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		return fmt.Errorf("failed to init repository: %w", err)
+	}
+
+	if _, err := repo.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{repoURL},
+	}); err != nil {
+		return fmt.Errorf("failed to create remote: %w", err)
+	}
+
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(branch))
+	if err := repo.Storer.SetReference(head); err != nil {
+		return fmt.Errorf("failed to point HEAD to branch %s: %w", branch, err)
+	}
+
+	return nil
+}
+
+// checkoutRef checks out a branch, tag or commit SHA in the cloned repository
+func checkoutRef(repoDir, ref string, auth transport.AuthMethod) error {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	// Make sure every branch and tag is available, the clone may only have a single branch
+	err = repo.Fetch(&git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+		Tags:       git.AllTags,
+		Depth:      fetchDepth(repo),
+		Auth:       auth,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to fetch references: %w", err)
+	}
+
+	hash, err := resolveRef(repo, ref)
+	if err != nil {
+		return err
+	}
+
+	// Detach HEAD so the checked out branch stays where it is
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, hash)); err != nil {
+		return fmt.Errorf("failed to checkout %s: %w", ref, err)
+	}
+	if err := checkoutTree(repo, hash, sparseCheckoutDirs(repoDir)); err != nil {
+		return fmt.Errorf("failed to checkout %s: %w", ref, err)
+	}
+
+	return nil
+}
+
+// resolveRef resolves a tag, remote branch or (abbreviated) commit SHA to a commit hash
+func resolveRef(repo *git.Repository, ref string) (plumbing.Hash, error) {
+	// Annotated tags point to a tag object, peel them to their commit
+	if tagRef, err := repo.Tag(ref); err == nil {
+		tag, err := repo.TagObject(tagRef.Hash())
+		if err != nil {
+			return tagRef.Hash(), nil
+		}
+		commit, err := tag.Commit()
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("tag %s does not point to a commit: %w", ref, err)
+		}
+		return commit.Hash, nil
+	}
+
+	for _, revision := range []string{git.DefaultRemoteName + "/" + ref, ref} {
+		if hash, err := repo.ResolveRevision(plumbing.Revision(revision)); err == nil {
+			return *hash, nil
+		}
+	}
+
+	return plumbing.ZeroHash, fmt.Errorf("ref %s not found in repository", ref)
+}
+
+// checkoutNewBranch creates branch at the checked out commit, or resets it there when it exists, and switches
+// to it, keeping the changes of the worktree. It returns the branch checked out before
+func checkoutNewBranch(repoDir, branch string) (string, error) {
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return "", fmt.Errorf("base branch has no commit to open a pull request against: %w", err)
+	}
+
+	// Point the branch at HEAD without touching the worktree, a cached clone still has it from the previous dump
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(branchRef, head.Hash())); err != nil {
+		return "", fmt.Errorf("failed to create branch %s: %w", branch, err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branchRef)); err != nil {
+		return "", fmt.Errorf("failed to switch to branch %s: %w", branch, err)
+	}
+
+	return head.Name().Short(), nil
+}
+
+// pushBackoff spaces out the pushes of a dump commit replayed after the remote branch moved, Steps bounds the retries
+var pushBackoff = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.5,
+	Steps:    5,
+}
+
+// isPushRejected tells whether a push failed because the remote branch moved since the clone, either
+// detected by go-git or reported by the server. go-git only types some of its errors, the rest and the
+// statuses of the server are matched by message
+func isPushRejected(err error) bool {
+	if errors.Is(err, git.ErrNonFastForwardUpdate) || errors.Is(err, git.ErrForceNeeded) {
+		return true
+	}
+	message := err.Error()
+	for _, rejection := range []string{"non-fast-forward", "fetch first", "cannot lock ref"} {
+		if strings.Contains(message, rejection) {
+			return true
+		}
+	}
+	return false
+}
+
+// replayCommit fetches branch and recreates the checked out commit on top of its remote head: the files
+// added, changed or removed by the commit are applied to the remote head, winning over remote changes to
+// them, and committed again. It returns false when the remote head already has the changes
+func replayCommit(repo *git.Repository, branch string, auth transport.AuthMethod, commit dumpCommit) (bool, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return false, fmt.Errorf("failed to get HEAD: %w", err)
+	}
+	ours, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return false, fmt.Errorf("failed to get commit %s: %w", head.Hash(), err)
+	}
+	if branch == "" {
+		branch = head.Name().Short()
+	}
+
+	ourTree, err := ours.Tree()
+	if err != nil {
+		return false, fmt.Errorf("failed to get tree of commit %s: %w", ours.Hash, err)
+	}
+	var parentTree *object.Tree
+	if ours.NumParents() > 0 {
+		parent, err := ours.Parent(0)
+		if err != nil {
+			return false, fmt.Errorf("failed to get parent of commit %s: %w", ours.Hash, err)
+		}
+		if parentTree, err = parent.Tree(); err != nil {
+			return false, fmt.Errorf("failed to get tree of commit %s: %w", parent.Hash, err)
+		}
+	}
+	changes, err := object.DiffTree(parentTree, ourTree)
+	if err != nil {
+		return false, fmt.Errorf("failed to diff commit %s: %w", ours.Hash, err)
+	}
+
+	remoteRef := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, branch)
+	err = repo.Fetch(&git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", plumbing.NewBranchReferenceName(branch), remoteRef))},
+		Depth:      fetchDepth(repo),
+		Auth:       auth,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return false, fmt.Errorf("failed to fetch branch %s: %w", branch, err)
+	}
+	remoteHead, err := repo.Reference(remoteRef, true)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve remote branch %s: %w", branch, err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return false, fmt.Errorf("failed to get worktree: %w", err)
+	}
+	if err := checkoutTree(repo, remoteHead.Hash(), sparseCheckoutDirs(worktree.Filesystem.Root())); err != nil {
+		return false, fmt.Errorf("failed to reset to remote branch %s: %w", branch, err)
+	}
+
+	for _, change := range changes {
+		if change.To.Name == "" {
+			if _, err := worktree.Remove(change.From.Name); err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+				return false, fmt.Errorf("failed to remove %s: %w", change.From.Name, err)
+			}
+			continue
+		}
+
+		file, err := ourTree.File(change.To.Name)
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", change.To.Name, err)
+		}
+		if err := writeBlob(filepath.Join(worktree.Filesystem.Root(), change.To.Name), file); err != nil {
+			return false, err
+		}
+		if _, err := worktree.Add(change.To.Name); err != nil {
+			return false, fmt.Errorf("failed to add %s: %w", change.To.Name, err)
+		}
+	}
+
+	_, err = worktree.Commit(commit.Message, &git.CommitOptions{
+		Author: commit.Author,
+		Signer: commit.Signer,
+	})
+	if errors.Is(err, git.ErrEmptyCommit) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to commit changes: %w", err)
+	}
+	return true, nil
+}
+
+// writeBlob writes the content of a file of a commit to path
+func writeBlob(path string, file *object.File) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", file.Name, err)
+	}
+	mode, err := file.Mode.ToOSFileMode()
+	if err != nil {
+		return fmt.Errorf("failed to get mode of %s: %w", file.Name, err)
+	}
+
+	reader, err := file.Reader()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file.Name, err)
+	}
+	defer reader.Close() //nolint:errcheck
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.Name, err)
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to write %s: %w", file.Name, err)
+	}
+	return out.Close()
+}
+
+// cloneOptions limits what is fetched and checked out of a repository
+type cloneOptions struct {
+	// Shallow fetches the last commit of the branch only
+	Shallow bool
+	// SparseDirs are the only directories checked out, the whole tree is checked out when empty
+	SparseDirs []string
+}
+
+// repositoryCloneOptions returns how the repository of a PostgresSync is cloned
+func repositoryCloneOptions(pgSync *cevichev1alpha1.PostgresSync) cloneOptions {
+	if pgSync.Spec.Git == nil {
+		return cloneOptions{}
+	}
+
+	options := cloneOptions{Shallow: pgSync.Spec.Git.Shallow}
+	if pgSync.Spec.Git.SparseCheckout {
+		dumpDir := "dumps" // Default path
+		if pgSync.Spec.DatabaseDumpPath != "" {
+			dumpDir = pgSync.Spec.DatabaseDumpPath
+		}
+		options.SparseDirs = []string{filepath.ToSlash(filepath.Clean(dumpDir))}
+	}
+	return options
+}
+
+// cloneInto clones branch of the repository into dir, which must not hold a repository yet
+// When branch is set and doesn't exist on the remote yet, an orphan branch is prepared instead
+func cloneInto(dir, repoURL, branch string, auth transport.AuthMethod, options cloneOptions) error {
+	branch, branchExists, err := resolveRemoteBranch(repoURL, branch, auth)
+	if err != nil {
+		return err
+	}
+	if !branchExists && branch != "" {
+		if err := initOrphanBranch(dir, repoURL, branch); err != nil {
+			return err
+		}
+		return writeSparseCheckout(dir, options.SparseDirs)
+	}
+
+	cloneOptions := &git.CloneOptions{
+		URL:        repoURL,
+		Auth:       auth,
+		NoCheckout: len(options.SparseDirs) > 0,
+	}
+	if branch != "" {
+		cloneOptions.ReferenceName = plumbing.NewBranchReferenceName(branch)
+	}
+	if options.Shallow {
+		cloneOptions.Depth = 1
+		cloneOptions.SingleBranch = true
+	}
+	repo, err := git.PlainClone(dir, false, cloneOptions)
+	if err != nil {
+		return err
+	}
+
+	if err := writeSparseCheckout(dir, options.SparseDirs); err != nil {
+		return err
+	}
+	if len(options.SparseDirs) == 0 {
+		return nil
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to get HEAD: %w", err)
+	}
+	return checkoutTree(repo, head.Hash(), options.SparseDirs)
+}
+
+// fetchDepth is the depth fetches of repo use, 1 to keep shallow clones shallow
+func fetchDepth(repo *git.Repository) int {
+	if shallow, err := repo.Storer.Shallow(); err == nil && len(shallow) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// checkoutTree makes the index and the worktree match the commit hash, removing any other file. With sparse
+// directories, only the files in them are written to the worktree while the index keeps the whole tree, so
+// commits leave the rest of the repository as it is
+func checkoutTree(repo *git.Repository, hash plumbing.Hash, sparseDirs []string) error {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	if len(sparseDirs) == 0 {
+		if err := worktree.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset}); err != nil {
+			return fmt.Errorf("failed to reset worktree: %w", err)
+		}
+		if err := worktree.Clean(&git.CleanOptions{Dir: true}); err != nil {
+			return fmt.Errorf("failed to clean worktree: %w", err)
+		}
+		return nil
+	}
+
+	root := worktree.Filesystem.Root()
+	if err := clearWorktree(root); err != nil {
+		return err
+	}
+	if err := worktree.Reset(&git.ResetOptions{Commit: hash, Mode: git.MixedReset}); err != nil {
+		return fmt.Errorf("failed to reset index: %w", err)
+	}
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("failed to get commit %s: %w", hash, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return fmt.Errorf("failed to get tree of commit %s: %w", hash, err)
+	}
+	for _, dir := range sparseDirs {
+		subtree, err := tree.Tree(dir)
+		if errors.Is(err, object.ErrDirectoryNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get directory %s of commit %s: %w", dir, hash, err)
+		}
+		err = subtree.Files().ForEach(func(file *object.File) error {
+			return writeBlob(filepath.Join(root, dir, file.Name), file)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearWorktree removes everything but the repository from a worktree
+func clearWorktree(root string) error {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return fmt.Errorf("failed to read worktree: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == git.GitDirName {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root, entry.Name())); err != nil {
+			return fmt.Errorf("failed to clean worktree: %w", err)
+		}
+	}
+	return nil
+}
+
+// sparseCheckoutFile lists the checked out directories of a sparse worktree, like git sparse-checkout does
+var sparseCheckoutFile = filepath.Join(git.GitDirName, "info", "sparse-checkout")
+
+// writeSparseCheckout records the sparse directories of the worktree in repoDir, no directories disable it
+func writeSparseCheckout(repoDir string, dirs []string) error {
+	path := filepath.Join(repoDir, sparseCheckoutFile)
+	if len(dirs) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to disable sparse checkout: %w", err)
+		}
+		return nil
+	}
+
+	var patterns strings.Builder
+	for _, dir := range dirs {
+		patterns.WriteString("/" + dir + "/\n")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to enable sparse checkout: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(patterns.String()), 0644); err != nil {
+		return fmt.Errorf("failed to enable sparse checkout: %w", err)
+	}
+	return nil
+}
+
+// sparseCheckoutDirs returns the sparse directories of the worktree in repoDir, none when it isn't sparse
+func sparseCheckoutDirs(repoDir string) []string {
+	patterns, err := os.ReadFile(filepath.Join(repoDir, sparseCheckoutFile))
+	if err != nil {
+		return nil
+	}
+
+	var dirs []string
+	for _, line := range strings.Split(string(patterns), "\n") {
+		if dir := strings.Trim(strings.TrimSpace(line), "/"); dir != "" && !strings.HasPrefix(dir, "#") {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// stageChanges adds the changes of the worktree to the index, only those in the sparse directories of
+// sparse worktrees, where the files outside them are missing on purpose
+func stageChanges(worktree *git.Worktree) error {
+	dirs := sparseCheckoutDirs(worktree.Filesystem.Root())
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	for _, dir := range dirs {
+		if _, err := worktree.Add(dir); err != nil {
+			return fmt.Errorf("failed to add changes: %w", err)
+		}
+	}
+	return nil
+}
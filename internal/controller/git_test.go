@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Git authentication", func() {
+	var privateKey, knownHosts []byte
+
+	BeforeEach(func() {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		block, err := ssh.MarshalPrivateKey(key, "")
+		Expect(err).NotTo(HaveOccurred())
+		privateKey = pem.EncodeToMemory(block)
+
+		hostKey, _, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		sshHostKey, err := ssh.NewPublicKey(hostKey)
+		Expect(err).NotTo(HaveOccurred())
+		knownHosts = []byte("github.com " + string(ssh.MarshalAuthorizedKey(sshHostKey)))
+	})
+
+	It("should use basic auth for HTTPS repositories", func() {
+		auth, err := gitAuthFromSecret("https://github.com/example/repo.git", &corev1.Secret{
+			Data: map[string][]byte{"username": []byte("user"), "password": []byte("token")},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(auth).To(Equal(&http.BasicAuth{Username: "user", Password: "token"}))
+	})
+
+	It("should use the deploy key for SSH repositories", func() {
+		auth, err := gitAuthFromSecret("git@github.com:example/repo.git", &corev1.Secret{
+			Data: map[string][]byte{"ssh-privatekey": privateKey, "known_hosts": knownHosts},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(auth).To(BeAssignableToTypeOf(&gitssh.PublicKeys{}))
+		Expect(auth.(*gitssh.PublicKeys).User).To(Equal("git"))
+		Expect(auth.(*gitssh.PublicKeys).HostKeyCallback).NotTo(BeNil())
+	})
+
+	It("should refuse SSH repositories without known_hosts", func() {
+		_, err := gitAuthFromSecret("ssh://git@github.com/example/repo.git", &corev1.Secret{
+			Data: map[string][]byte{"ssh-privatekey": privateKey},
+		})
+		Expect(err).To(MatchError(ContainSubstring("known_hosts")))
+	})
+})
+
+var _ = Describe("Git branches and refs", func() {
+	var remoteDir string
+	reconciler := &PostgresSyncReconciler{}
+
+	BeforeEach(func() {
+		remoteDir = GinkgoT().TempDir()
+		_, err := git.PlainInit(remoteDir, true)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	pushDump := func(branch, content string) string {
+		repoDir, err := reconciler.cloneRepository(remoteDir, branch, nil, cloneOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, repoDir)
+
+		Expect(os.MkdirAll(filepath.Join(repoDir, "dumps"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(repoDir, "dumps", "dump.sql"), []byte(content), 0644)).To(Succeed())
+		Expect(reconciler.commitAndPushChanges(repoDir, nil, dumpCommit{Branch: branch, Author: commitAuthor(&migrationsv1alpha1.PostgresSync{}), Message: "Updated database dump"})).To(Succeed())
+		return repoDir
+	}
+
+	It("should create missing branches as orphan branches", func() {
+		pushDump("main", "main")
+		pushDump("dumps", "first")
+		repoDir := pushDump("dumps", "second")
+
+		repo, err := git.PlainOpen(repoDir)
+		Expect(err).NotTo(HaveOccurred())
+		head, err := repo.Head()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(head.Name()).To(Equal(plumbing.NewBranchReferenceName("dumps")))
+
+		// The dumps branch only contains its own history
+		commits, err := repo.Log(&git.LogOptions{From: head.Hash()})
+		Expect(err).NotTo(HaveOccurred())
+		count := 0
+		Expect(commits.ForEach(func(*object.Commit) error { count++; return nil })).To(Succeed())
+		Expect(count).To(Equal(2))
+	})
+
+	It("should check out tags and commit SHAs", func() {
+		repoDir := pushDump("dumps", "known-good")
+		repo, err := git.PlainOpen(repoDir)
+		Expect(err).NotTo(HaveOccurred())
+		head, err := repo.Head()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = repo.CreateTag("known-good", head.Hash(), &git.CreateTagOptions{
+			Tagger:  &object.Signature{Name: "test", Email: "test@example.com"},
+			Message: "known good dump",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(repo.Push(&git.PushOptions{RefSpecs: []config.RefSpec{"refs/tags/*:refs/tags/*"}})).To(Succeed())
+		pushDump("dumps", "broken")
+
+		for _, ref := range []string{"known-good", head.Hash().String()[:8]} {
+			repoDir, err := reconciler.cloneRepository(remoteDir, "dumps", nil, cloneOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, repoDir)
+
+			Expect(checkoutRef(repoDir, ref, nil)).To(Succeed())
+			Expect(os.ReadFile(filepath.Join(repoDir, "dumps", "dump.sql"))).To(BeEquivalentTo("known-good"))
+		}
+	})
+
+	It("should replay dump commits on top of branches that moved since the clone", func() {
+		backoff := pushBackoff
+		pushBackoff.Duration = time.Millisecond
+		DeferCleanup(func() { pushBackoff = backoff })
+		author := commitAuthor(&migrationsv1alpha1.PostgresSync{})
+
+		for _, branch := range []string{"dumps", "fresh"} {
+			if branch == "dumps" {
+				pushDump(branch, "first")
+			}
+
+			// Both clones are taken before either pushes, the second push is rejected as non-fast-forward
+			ours, err := reconciler.cloneRepository(remoteDir, branch, nil, cloneOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, ours)
+			theirs, err := reconciler.cloneRepository(remoteDir, branch, nil, cloneOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, theirs)
+
+			Expect(os.MkdirAll(filepath.Join(theirs, "dumps"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(theirs, "dumps", "dump.sql"), []byte("theirs"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(theirs, "README.md"), []byte("readme"), 0644)).To(Succeed())
+			Expect(reconciler.commitAndPushChanges(theirs, nil, dumpCommit{Branch: branch, Author: author, Message: "Human change"})).To(Succeed())
+
+			Expect(os.MkdirAll(filepath.Join(ours, "dumps"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(ours, "dumps", "dump.sql"), []byte("ours"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(ours, "dumps", "checksums.json"), []byte("{}"), 0644)).To(Succeed())
+			Expect(reconciler.commitAndPushChanges(ours, nil, dumpCommit{Branch: branch, Author: author, Message: "Updated database dump"})).To(Succeed())
+
+			remote, err := git.PlainOpen(remoteDir)
+			Expect(err).NotTo(HaveOccurred())
+			head, err := remote.Reference(plumbing.NewBranchReferenceName(branch), true)
+			Expect(err).NotTo(HaveOccurred())
+			commit, err := remote.CommitObject(head.Hash())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commit.Message).To(Equal("Updated database dump"))
+			parent, err := commit.Parent(0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parent.Message).To(Equal("Human change"))
+
+			// The dump wins over the remote dump, other remote changes are kept
+			for name, content := range map[string]string{"dumps/dump.sql": "ours", "dumps/checksums.json": "{}", "README.md": "readme"} {
+				file, err := commit.File(name)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(file.Contents()).To(Equal(content))
+			}
+		}
+	})
+
+	It("should give up on branches that keep moving", func() {
+		Expect(isPushRejected(errors.New("non-fast-forward update: refs/heads/dumps"))).To(BeTrue())
+		Expect(isPushRejected(errors.New("command error on refs/heads/dumps: fetch first"))).To(BeTrue())
+		Expect(isPushRejected(fmt.Errorf("failed to push: %w", git.ErrNonFastForwardUpdate))).To(BeTrue())
+		Expect(isPushRejected(fmt.Errorf("failed to push: %w", git.ErrForceNeeded))).To(BeTrue())
+		Expect(isPushRejected(errors.New("authentication required"))).To(BeFalse())
+
+		backoff := pushBackoff
+		pushBackoff.Duration, pushBackoff.Steps = time.Millisecond, 0
+		DeferCleanup(func() { pushBackoff = backoff })
+
+		pushDump("dumps", "first")
+		repoDir, err := reconciler.cloneRepository(remoteDir, "dumps", nil, cloneOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, repoDir)
+		pushDump("dumps", "second")
+
+		Expect(os.WriteFile(filepath.Join(repoDir, "dumps", "dump.sql"), []byte("third"), 0644)).To(Succeed())
+		err = reconciler.commitAndPushChanges(repoDir, nil, dumpCommit{Branch: "dumps", Author: commitAuthor(&migrationsv1alpha1.PostgresSync{}), Message: "Updated database dump"})
+		Expect(err).To(MatchError(ContainSubstring("non-fast-forward")))
+	})
+})
@@ -0,0 +1,404 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// Operations that can run in a Job
+const (
+	OperationDump    = "dump"
+	OperationRestore = "restore"
+)
+
+const (
+	// jobContainerName is the name of the container running the operation in a Job
+	jobContainerName = "postgressync"
+
+	// Labels set on Jobs created for a PostgresSync
+	postgresSyncLabel = "ceviche.jcroyoaun.io/postgressync"
+	operationLabel    = "ceviche.jcroyoaun.io/operation"
+
+	// defaultJobTTL is how long finished Jobs are kept when the template doesn't say otherwise
+	defaultJobTTL int32 = 24 * 60 * 60
+
+	// jobVolumeDir is where Jobs mount the PersistentVolumeClaim of a PVC storage, under its claim name
+	jobVolumeDir = "/var/lib/cevichedbsync/volumes"
+
+	// terminationMessageLimit is the size Kubernetes truncates termination messages to
+	terminationMessageLimit = 4096
+
+	// jobRetryDelay is how long to wait after a failed Job before running the same operation again
+	jobRetryDelay = time.Minute
+)
+
+// runsInJob reports whether dumps and restores of a PostgresSync run in Jobs
+func runsInJob(pgSync *cevichev1alpha1.PostgresSync) bool {
+	return pgSync.Spec.Execution != nil && pgSync.Spec.Execution.Mode == cevichev1alpha1.ExecutionModeJob
+}
+
+// operationName returns the name of the objects created for an operation started at the given time. A random
+// part keeps operations started within the same second apart
+func operationName(pgSync *cevichev1alpha1.PostgresSync, operation string, now time.Time) string {
+	random := make([]byte, 3)
+	_, _ = rand.Read(random)
+
+	// Job names end up in pod labels, so they have to stay within 63 characters
+	suffix := fmt.Sprintf("-%s-%d-%s", operation, now.Unix(), hex.EncodeToString(random))
+	prefix := pgSync.Name
+	if len(prefix)+len(suffix) > 63 {
+		prefix = prefix[:63-len(suffix)]
+	}
+	return prefix + suffix
+}
+
+// startJob creates a Job running operation for the PostgresSync and records it as the active Job
+// ref is the Git ref restores are taken from, empty for the default, and trigger what started a dump
+func (r *PostgresSyncReconciler) startJob(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, operation, ref string, trigger cevichev1alpha1.DumpTrigger) error {
+	logger := log.FromContext(ctx)
+
+	template := pgSync.Spec.Execution.Job
+	if template == nil {
+		template = &cevichev1alpha1.JobTemplate{}
+	}
+
+	image := template.Image
+	if image == "" {
+		image = r.JobImage
+	}
+	if image == "" {
+		return fmt.Errorf("no image configured for Jobs, set spec.execution.job.image or the operator's --job-image")
+	}
+
+	ttl := defaultJobTTL
+	if template.TTLSecondsAfterFinished != nil {
+		ttl = *template.TTLSecondsAfterFinished
+	}
+
+	// Mount the volume dumps are kept in, if any
+	var volumes []corev1.Volume
+	var volumeMounts []corev1.VolumeMount
+	if storage := pgSync.Spec.Storage; storage != nil && storage.PVC != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: "dumps",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: storage.PVC.ClaimName},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      "dumps",
+			MountPath: path.Join(jobVolumeDir, storage.PVC.ClaimName),
+		})
+	}
+
+	backoffLimit := int32(0)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      operationName(pgSync, operation, time.Now()),
+			Namespace: pgSync.Namespace,
+			Labels: map[string]string{
+				postgresSyncLabel: pgSync.Name,
+				operationLabel:    operation,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			// The operator decides when to run again, a failed dump must not be pushed twice
+			BackoffLimit:            &backoffLimit,
+			ActiveDeadlineSeconds:   template.ActiveDeadlineSeconds,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						postgresSyncLabel: pgSync.Name,
+						operationLabel:    operation,
+					},
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: template.ServiceAccountName,
+					NodeSelector:       template.NodeSelector,
+					Tolerations:        template.Tolerations,
+					Volumes:            volumes,
+					Containers: []corev1.Container{{
+						Name:                     jobContainerName,
+						Image:                    image,
+						ImagePullPolicy:          template.ImagePullPolicy,
+						Command:                  []string{"/manager"},
+						Args:                     jobArgs(pgSync, operation, ref, trigger, r.AmbientS3Credentials),
+						Resources:                template.Resources,
+						VolumeMounts:             volumeMounts,
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+					}},
+				},
+			},
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(pgSync, job, r.Scheme); err != nil {
+		return fmt.Errorf("failed to set Job owner: %w", err)
+	}
+	if err := r.Create(ctx, job); err != nil {
+		return fmt.Errorf("failed to create Job: %w", err)
+	}
+
+	logger.Info("Created Job", "job", job.Name, "operation", operation)
+	pgSync.Status.ActiveJob = job.Name
+	markInProgress(pgSync, fmt.Sprintf("Running %s in Job %s", operation, job.Name))
+
+	return nil
+}
+
+// jobArgs returns the arguments of the operator binary running operation in a Job
+// ambientS3Credentials passes on the --s3-ambient-credentials of the operator
+func jobArgs(pgSync *cevichev1alpha1.PostgresSync, operation, ref string, trigger cevichev1alpha1.DumpTrigger, ambientS3Credentials bool) []string {
+	args := []string{
+		"--run-operation=" + operation,
+		"--postgressync=" + pgSync.Namespace + "/" + pgSync.Name,
+	}
+	if ref != "" {
+		args = append(args, "--ref="+ref)
+	}
+	if trigger != "" {
+		args = append(args, "--trigger="+string(trigger))
+	}
+	if storage := pgSync.Spec.Storage; storage != nil && storage.PVC != nil {
+		args = append(args, "--volume-dir="+jobVolumeDir)
+	}
+	if ambientS3Credentials {
+		args = append(args, "--s3-ambient-credentials")
+	}
+	return args
+}
+
+// reconcileActiveJob tracks the active Job of a PostgresSync and mirrors its outcome into the status
+// once it finishes. It returns true while the Job is still running
+func (r *PostgresSyncReconciler) reconcileActiveJob(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) (bool, ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	job := &batchv1.Job{}
+	jobKey := types.NamespacedName{Name: pgSync.Status.ActiveJob, Namespace: pgSync.Namespace}
+	if err := r.Get(ctx, jobKey, job); err != nil {
+		if !errors.IsNotFound(err) {
+			logger.Error(err, "unable to fetch Job", "job", jobKey)
+			return true, ctrl.Result{}, err
+		}
+
+		logger.Info("Active Job disappeared before it finished", "job", jobKey)
+		pgSync.Status.ActiveJob = ""
+		pgSync.Status.Phase = PhaseFailed
+		pgSync.Status.Message = fmt.Sprintf("Job %s was deleted before it finished", jobKey.Name)
+		setCondition(pgSync, cevichev1alpha1.ConditionReady, metav1.ConditionFalse, cevichev1alpha1.ReasonJobFailed, pgSync.Status.Message)
+		if err := r.updateStatus(ctx, pgSync); err != nil {
+			logger.Error(err, "unable to update PostgresSync status")
+			return true, ctrl.Result{}, err
+		}
+		return false, ctrl.Result{}, nil
+	}
+
+	finished, failed := jobFinished(job)
+	if !finished {
+		// Job updates requeue us as well, polling only covers missed events
+		return true, ctrl.Result{RequeueAfter: time.Second * 30}, nil
+	}
+
+	operation := job.Labels[operationLabel]
+	result := parseOperationResult(r.jobTerminationMessage(ctx, job))
+	completionTime := metav1.Now()
+	if job.Status.CompletionTime != nil {
+		completionTime = *job.Status.CompletionTime
+	}
+	requestID := jobOperationID(pgSync, job.Name)
+	switch {
+	case failed && operation == OperationRestore:
+		logger.Info("Restore Job failed", "job", job.Name, "message", result.Message)
+		err := fmt.Errorf("job %s failed: %s", job.Name, result.Message)
+		if requestID != "" {
+			markRestoreRequestFailed(pgSync, err)
+		} else {
+			markRestoreFailed(pgSync, err)
+		}
+		finishOperation(pgSync, requestID, "", err, "")
+	case failed:
+		logger.Info("Dump Job failed", "job", job.Name, "message", result.Message)
+		err := fmt.Errorf("job %s failed: %s", job.Name, result.Message)
+		markDumpFailed(pgSync, err)
+		finishOperation(pgSync, requestID, job.Name, err, "")
+		r.finishDumpRecord(ctx, pgSync, job.Name, nil, err, completionTime)
+	case operation == OperationRestore && requestID != "" && result.Message == messageNoDumpFound:
+		logger.Info("Restore Job found no dump to restore", "job", job.Name)
+		markRestoreRequestFailed(pgSync, errNoDumpFound)
+		finishOperation(pgSync, requestID, "", errNoDumpFound, "")
+	case operation == OperationRestore:
+		logger.Info("Restore Job succeeded", "job", job.Name)
+		markRestored(pgSync, result.Message != messageNoDumpFound)
+		finishOperation(pgSync, requestID, "", nil, result.Message)
+	default:
+		logger.Info("Dump Job succeeded", "job", job.Name)
+		markDumpSucceeded(pgSync, result.Dump, result.Message, completionTime)
+		finishOperation(pgSync, requestID, job.Name, nil, pgSync.Status.Message)
+		r.finishDumpRecord(ctx, pgSync, job.Name, result.Dump, nil, completionTime)
+	}
+
+	pgSync.Status.ActiveJob = ""
+	if err := r.updateStatus(ctx, pgSync); err != nil {
+		logger.Error(err, "unable to update PostgresSync status")
+		return true, ctrl.Result{}, err
+	}
+
+	return false, ctrl.Result{}, nil
+}
+
+// jobFinished reports whether a Job has finished and whether it failed
+func jobFinished(job *batchv1.Job) (bool, bool) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return true, false
+		case batchv1.JobFailed:
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// jobRetryAfter returns how long to wait before running operation again in a new Job,
+// so a failing Job isn't recreated in a tight loop
+func (r *PostgresSyncReconciler) jobRetryAfter(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, operation string) (time.Duration, error) {
+	var jobs batchv1.JobList
+	if err := r.List(ctx, &jobs, client.InNamespace(pgSync.Namespace), client.MatchingLabels{
+		postgresSyncLabel: pgSync.Name,
+		operationLabel:    operation,
+	}); err != nil {
+		return 0, fmt.Errorf("failed to list Jobs: %w", err)
+	}
+
+	var lastFailure time.Time
+	for _, job := range jobs.Items {
+		for _, condition := range job.Status.Conditions {
+			if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue &&
+				condition.LastTransitionTime.After(lastFailure) {
+				lastFailure = condition.LastTransitionTime.Time
+			}
+		}
+	}
+
+	return time.Until(lastFailure.Add(jobRetryDelay)), nil
+}
+
+// jobTerminationMessage returns the termination message written by the operation in the Job pod,
+// falling back to the Job conditions when the pod is gone
+func (r *PostgresSyncReconciler) jobTerminationMessage(ctx context.Context, job *batchv1.Job) string {
+	var reader client.Reader = r.Client
+	if r.APIReader != nil {
+		reader = r.APIReader
+	}
+
+	var pods corev1.PodList
+	if err := reader.List(ctx, &pods, client.InNamespace(job.Namespace), client.MatchingLabels{
+		batchv1.JobNameLabel: job.Name,
+	}); err == nil {
+		for _, pod := range pods.Items {
+			for _, status := range pod.Status.ContainerStatuses {
+				if status.Name == jobContainerName && status.State.Terminated != nil && status.State.Terminated.Message != "" {
+					return status.State.Terminated.Message
+				}
+			}
+		}
+	}
+
+	for _, condition := range job.Status.Conditions {
+		if condition.Status == corev1.ConditionTrue && condition.Message != "" {
+			return condition.Message
+		}
+	}
+	return fmt.Sprintf("%s finished", job.Labels[operationLabel])
+}
+
+// operationResult is the result of an operation, passed from Jobs to the operator through their
+// termination message
+type operationResult struct {
+	Message string      `json:"message"`
+	Dump    *dumpResult `json:"dump,omitempty"`
+}
+
+// parseOperationResult decodes the termination message of a Job. Messages that aren't an encoded
+// result, such as errors or logs, are returned as the message of the result
+func parseOperationResult(message string) operationResult {
+	var result operationResult
+	if err := json.Unmarshal([]byte(message), &result); err != nil || result.Message == "" {
+		return operationResult{Message: message}
+	}
+	return result
+}
+
+// RunOperation runs a single dump or restore of a PostgresSync in the current process and returns its
+// result encoded for the termination message. It is the entrypoint of the Jobs created in Job execution mode.
+// ref is the Git ref restores are taken from, empty for the default, and trigger what started a dump
+func (r *PostgresSyncReconciler) RunOperation(ctx context.Context, key types.NamespacedName, operation, ref, trigger string) (string, error) {
+	var pgSync cevichev1alpha1.PostgresSync
+	if err := r.Get(ctx, key, &pgSync); err != nil {
+		return "", fmt.Errorf("failed to get PostgresSync: %w", err)
+	}
+
+	var result operationResult
+	switch operation {
+	case OperationDump:
+		dump, err := r.createDatabaseDump(ctx, &pgSync, cevichev1alpha1.DumpTrigger(trigger))
+		if err != nil {
+			return "", fmt.Errorf("failed to dump database: %w", err)
+		}
+		result = operationResult{Message: "Database dump created successfully", Dump: dump}
+	case OperationRestore:
+		restored, err := r.findAndRestoreDump(ctx, &pgSync, ref)
+		if err != nil {
+			return "", fmt.Errorf("failed to restore dump: %w", err)
+		}
+		result = operationResult{Message: messageNoDumpFound}
+		if restored {
+			result.Message = messageDumpRestored
+		}
+	default:
+		return "", fmt.Errorf("unknown operation %q", operation)
+	}
+
+	return encodeOperationResult(result)
+}
+
+// encodeOperationResult encodes the result for the termination message. Changed tables are left out, last
+// first, until it fits, so it is never truncated into invalid JSON
+func encodeOperationResult(result operationResult) (string, error) {
+	if result.Dump != nil {
+		dump := *result.Dump
+		result.Dump = &dump
+	}
+	for {
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode result: %w", err)
+		}
+		if len(encoded) <= terminationMessageLimit || result.Dump == nil || len(result.Dump.ChangedTables) == 0 {
+			return string(encoded), nil
+		}
+		result.Dump.ChangedTables = result.Dump.ChangedTables[:len(result.Dump.ChangedTables)-1]
+	}
+}
@@ -0,0 +1,490 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// Files of a per-table dump, relative to the dumps directory
+const (
+	tableManifestFile  = "tables.json"
+	tableSchemaFile    = "schema.sql"
+	tablePostDataFile  = "post-data.sql"
+	tableSequencesFile = "sequences.sql"
+	tableDataDir       = "data"
+)
+
+// tableArtifacts lists the files and directories a per-table dump is made of
+var tableArtifacts = []string{tableManifestFile, tableSchemaFile, tablePostDataFile, tableSequencesFile, tableDataDir}
+
+// relationsQuery lists the tables and sequences of the database as JSON, along with the columns, primary
+// key and referenced tables of each table. Partitions are dumped through their parent and extension
+// members by their extension
+const relationsQuery = `SELECT coalesce(json_agg(r ORDER BY r.schema, r.name), '[]') FROM (
+  SELECT n.nspname AS schema, c.relname AS name, c.relkind = 'S' AS sequence,
+    coalesce((SELECT json_agg(a.attname ORDER BY a.attnum) FROM pg_catalog.pg_attribute a
+      WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped AND a.attgenerated = ''), '[]') AS columns,
+    coalesce((SELECT json_agg(a.attname ORDER BY array_position(i.indkey::int2[], a.attnum))
+      FROM pg_catalog.pg_index i JOIN pg_catalog.pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+      WHERE i.indrelid = c.oid AND i.indisprimary), '[]') AS "primaryKey",
+    coalesce((SELECT json_agg(DISTINCT jsonb_build_object('schema', fn.nspname, 'name', fc.relname))
+      FROM pg_catalog.pg_constraint f JOIN pg_catalog.pg_class fc ON fc.oid = f.confrelid
+      JOIN pg_catalog.pg_namespace fn ON fn.oid = fc.relnamespace
+      WHERE f.conrelid = c.oid AND f.contype = 'f' AND f.confrelid <> c.oid), '[]') AS "references"
+  FROM pg_catalog.pg_class c JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+  WHERE c.relkind IN ('r', 'p', 'S') AND NOT c.relispartition
+    AND n.nspname <> 'information_schema' AND n.nspname NOT LIKE 'pg\_%'
+    AND NOT EXISTS (SELECT 1 FROM pg_catalog.pg_depend d
+      WHERE d.classid = 'pg_catalog.pg_class'::regclass AND d.objid = c.oid AND d.deptype = 'e')
+) r`
+
+// relation is a table or sequence of the database, as listed by relationsQuery
+type relation struct {
+	Schema     string         `json:"schema"`
+	Name       string         `json:"name"`
+	Sequence   bool           `json:"sequence"`
+	Columns    []string       `json:"columns"`
+	PrimaryKey []string       `json:"primaryKey"`
+	References []relationName `json:"references"`
+}
+
+// relationName is the qualified name of a relation
+type relationName struct {
+	Schema string `json:"schema"`
+	Name   string `json:"name"`
+}
+
+// String returns the quoted qualified name of the relation
+func (n relationName) String() string {
+	return quoteIdentifier(n.Schema) + "." + quoteIdentifier(n.Name)
+}
+
+// tableManifest lists the tables of a per-table dump in the order they are restored
+type tableManifest struct {
+	Tables []manifestTable `json:"tables"`
+}
+
+// manifestTable is a table of a per-table dump
+type manifestTable struct {
+	Schema     string   `json:"schema"`
+	Name       string   `json:"name"`
+	Columns    []string `json:"columns"`
+	PrimaryKey []string `json:"primaryKey,omitempty"`
+	// File holds the rows of the table in COPY text format, relative to the dumps directory. It is empty
+	// when the data of the table is excluded
+	File string `json:"file,omitempty"`
+}
+
+// dumpLayout returns how dumps of the PostgresSync are laid out in the repository
+func dumpLayout(pgSync *cevichev1alpha1.PostgresSync) cevichev1alpha1.DumpLayout {
+	if pgSync.Spec.Dump == nil || pgSync.Spec.Dump.Layout == "" {
+		return cevichev1alpha1.DumpLayoutSingle
+	}
+	return pgSync.Spec.Dump.Layout
+}
+
+// hasTableManifest reports whether dumpsDir holds a per-table dump
+func hasTableManifest(dumpsDir string) bool {
+	info, err := os.Stat(filepath.Join(dumpsDir, tableManifestFile))
+	return err == nil && !info.IsDir()
+}
+
+// createTableDump dumps the database into dumpsDir with one file per table: the schema is split around
+// the data by pg_dump sections, so constraints and indexes are only created once the rows are loaded.
+// The schema, the relations and the rows are all read from one exported snapshot
+func createTableDump(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, conn *databaseConnection, dumpsDir string, mask *masker) error {
+	logger := log.FromContext(ctx)
+
+	filter, err := newRelationFilter(pgSync)
+	if err != nil {
+		return fmt.Errorf("invalid dump filters: %w", err)
+	}
+
+	snapshot, err := exportSnapshot(conn)
+	if err != nil {
+		return databaseError{err}
+	}
+	defer snapshot.release()
+
+	sections := []struct {
+		section string
+		file    string
+		args    []string
+	}{
+		{section: "pre-data", file: tableSchemaFile, args: []string{"--clean", "--if-exists"}},
+		{section: "post-data", file: tablePostDataFile},
+	}
+	for _, s := range sections {
+		args := append(conn.args(), s.args...)
+		args = append(args,
+			"--no-owner",
+			"--no-privileges",
+			"--section="+s.section,
+			"--snapshot="+snapshot.id,
+			"-f", filepath.Join(dumpsDir, s.file),
+		)
+		dumpCmd := exec.Command("pg_dump", append(args, filterArgs(pgSync)...)...)
+		dumpCmd.Env = conn.env()
+		if output, err := dumpCmd.CombinedOutput(); err != nil {
+			logger.Error(err, "failed to dump schema", "section", s.section, "output", string(output))
+			return databaseError{fmt.Errorf("pg_dump failed: %w, output: %s", err, output)}
+		}
+	}
+
+	relations, err := listRelations(conn, snapshot.id)
+	if err != nil {
+		return databaseError{err}
+	}
+	var tables, sequences []relation
+	for _, rel := range relations {
+		if !filter.includes(rel.Schema, rel.Name) {
+			continue
+		}
+		if rel.Sequence {
+			sequences = append(sequences, rel)
+		} else {
+			tables = append(tables, rel)
+		}
+	}
+	tables = orderTables(tables)
+
+	manifest := tableManifest{Tables: make([]manifestTable, 0, len(tables))}
+	for _, table := range tables {
+		entry := manifestTable{
+			Schema:     table.Schema,
+			Name:       table.Name,
+			Columns:    table.Columns,
+			PrimaryKey: table.PrimaryKey,
+		}
+		if filter.includesData(table.Schema, table.Name) {
+			entry.File = tableDataFile(table.Schema, table.Name)
+		}
+		manifest.Tables = append(manifest.Tables, entry)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dumpsDir, tableDataDir), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	logger.Info("Dumping tables", "tables", len(tables), "sequences", len(sequences))
+	if output, err := runPsqlScript(conn, tableDumpScript(dumpsDir, snapshot.id, manifest, sequences)); err != nil {
+		logger.Error(err, "failed to dump tables", "output", string(output))
+		return databaseError{fmt.Errorf("failed to dump tables: %w, output: %s", err, output)}
+	}
+
+	if mask != nil {
+		for _, table := range manifest.Tables {
+			if table.File == "" {
+				continue
+			}
+			name := table.Schema + "." + table.Name
+			if err := mask.maskTableFile(filepath.Join(dumpsDir, table.File), name, table.Columns); err != nil {
+				return fmt.Errorf("failed to mask dump: %w", err)
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode table manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dumpsDir, tableManifestFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write table manifest: %w", err)
+	}
+	return nil
+}
+
+// restoreTableDump restores the per-table dump in dumpsDir
+func restoreTableDump(conn *databaseConnection, dumpsDir string) error {
+	data, err := os.ReadFile(filepath.Join(dumpsDir, tableManifestFile))
+	if err != nil {
+		return fmt.Errorf("failed to read table manifest: %w", err)
+	}
+	var manifest tableManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("failed to decode table manifest: %w", err)
+	}
+
+	if output, err := runPsqlScript(conn, tableRestoreScript(dumpsDir, manifest)); err != nil {
+		return databaseError{fmt.Errorf("failed to restore database: %w, output: %s", err, output)}
+	}
+	return nil
+}
+
+// exportedSnapshot is a snapshot exported by a psql session, which other sessions can import while it is open
+type exportedSnapshot struct {
+	id    string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// exportSnapshot opens a read-only REPEATABLE READ transaction and exports its snapshot. The transaction is
+// kept open until release
+func exportSnapshot(conn *databaseConnection) (*exportedSnapshot, error) {
+	cmd := exec.Command("psql", append(conn.args(), "-X", "-A", "-t", "-q", "-v", "ON_ERROR_STOP=1")...)
+	cmd.Env = conn.env()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to export snapshot: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to export snapshot: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to export snapshot: %w", err)
+	}
+
+	snapshot := &exportedSnapshot{cmd: cmd, stdin: stdin}
+	_, err = io.WriteString(stdin, "BEGIN ISOLATION LEVEL REPEATABLE READ, READ ONLY;\nSELECT pg_catalog.pg_export_snapshot();\n")
+	if err == nil {
+		var line string
+		line, err = bufio.NewReader(stdout).ReadString('\n')
+		snapshot.id = strings.TrimSpace(line)
+	}
+	if err == nil && snapshot.id == "" {
+		err = fmt.Errorf("no snapshot returned")
+	}
+	if err != nil {
+		snapshot.release()
+		return nil, fmt.Errorf("failed to export snapshot: %w, output: %s", err, stderr.String())
+	}
+	return snapshot, nil
+}
+
+// release ends the transaction of the snapshot
+func (s *exportedSnapshot) release() {
+	_ = s.stdin.Close()
+	_ = s.cmd.Wait()
+}
+
+// listRelations lists the tables and sequences of the database as seen by the snapshot
+func listRelations(conn *databaseConnection, snapshot string) ([]relation, error) {
+	cmd := exec.Command("psql", append(conn.args(), "-X", "-A", "-t", "-q", "-v", "ON_ERROR_STOP=1",
+		"-c", "BEGIN ISOLATION LEVEL REPEATABLE READ, READ ONLY",
+		"-c", "SET TRANSACTION SNAPSHOT "+quoteLiteral(snapshot),
+		"-c", relationsQuery,
+		"-c", "COMMIT")...)
+	cmd.Env = conn.env()
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("failed to list tables: %w, output: %s", err, exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+
+	var relations []relation
+	if err := json.Unmarshal(output, &relations); err != nil {
+		return nil, fmt.Errorf("failed to decode tables: %w", err)
+	}
+	return relations, nil
+}
+
+// orderTables sorts tables so that referenced tables come before the tables referencing them, by name
+// otherwise. Tables in a reference cycle keep their name order
+func orderTables(tables []relation) []relation {
+	byName := make(map[relationName]relation, len(tables))
+	for _, table := range tables {
+		byName[relationName{Schema: table.Schema, Name: table.Name}] = table
+	}
+
+	names := make([]relationName, 0, len(tables))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Schema != names[j].Schema {
+			return names[i].Schema < names[j].Schema
+		}
+		return names[i].Name < names[j].Name
+	})
+
+	ordered := make([]relation, 0, len(tables))
+	visited := make(map[relationName]bool, len(tables))
+	var visit func(name relationName, path map[relationName]bool)
+	visit = func(name relationName, path map[relationName]bool) {
+		if visited[name] || path[name] {
+			return
+		}
+		path[name] = true
+		table := byName[name]
+		references := append([]relationName(nil), table.References...)
+		sort.Slice(references, func(i, j int) bool { return references[i].String() < references[j].String() })
+		for _, reference := range references {
+			if _, ok := byName[reference]; ok {
+				visit(reference, path)
+			}
+		}
+		delete(path, name)
+		visited[name] = true
+		ordered = append(ordered, table)
+	}
+	for _, name := range names {
+		visit(name, map[relationName]bool{})
+	}
+	return ordered
+}
+
+// tableDataFile returns the data file of a table, relative to the dumps directory. Characters that aren't
+// safe in file names are percent encoded, so the dot between schema and table stays unambiguous
+func tableDataFile(schema, name string) string {
+	escape := func(s string) string {
+		var b strings.Builder
+		for _, c := range []byte(s) {
+			if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '-' {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		return b.String()
+	}
+	return filepath.Join(tableDataDir, escape(schema)+"."+escape(name)+".copy")
+}
+
+// tableDumpScript returns the psql script copying the rows of every table, ordered by primary key, and
+// the values of the sequences. Everything is read from the exported snapshot, sequences last so they are
+// never behind the rows
+func tableDumpScript(dumpsDir, snapshot string, manifest tableManifest, sequences []relation) string {
+	var b strings.Builder
+	b.WriteString("BEGIN ISOLATION LEVEL REPEATABLE READ, READ ONLY;\n")
+	fmt.Fprintf(&b, "SET TRANSACTION SNAPSHOT %s;\n", quoteLiteral(snapshot))
+	for _, table := range manifest.Tables {
+		if table.File == "" {
+			continue
+		}
+		name := relationName{Schema: table.Schema, Name: table.Name}
+		path := filepath.Join(dumpsDir, table.File)
+		if len(table.Columns) == 0 {
+			fmt.Fprintf(&b, "\\copy %s TO %s\n", name, psqlQuote(path))
+			continue
+		}
+
+		columns := quoteIdentifiers(table.Columns)
+		// Without a primary key every column is compared as text, which any type supports
+		order := quoteIdentifiers(table.PrimaryKey)
+		if len(order) == 0 {
+			for _, column := range columns {
+				order = append(order, column+"::text")
+			}
+		}
+		fmt.Fprintf(&b, "\\copy (SELECT %s FROM %s ORDER BY %s) TO %s\n",
+			strings.Join(columns, ", "), name, strings.Join(order, ", "), psqlQuote(path))
+	}
+
+	if len(sequences) > 0 {
+		fmt.Fprintf(&b, "\\pset format unaligned\n\\pset tuples_only on\n\\o %s\n", psqlQuote(filepath.Join(dumpsDir, tableSequencesFile)))
+		for _, sequence := range sequences {
+			name := relationName{Schema: sequence.Schema, Name: sequence.Name}
+			fmt.Fprintf(&b, "SELECT pg_catalog.format('SELECT pg_catalog.setval(%%L, %%s, %%s);', %s, last_value, is_called) FROM %s;\n",
+				quoteLiteral(name.String()), name)
+		}
+		b.WriteString("\\o\n")
+	}
+	b.WriteString("COMMIT;\n")
+	return b.String()
+}
+
+// tableRestoreScript returns the psql script restoring a per-table dump: the schema, the rows of every
+// table in dependency order, the sequences and finally constraints and indexes
+func tableRestoreScript(dumpsDir string, manifest tableManifest) string {
+	var b strings.Builder
+
+	// The schema drops the tables it recreates, drop the foreign keys of other tables referencing them first
+	if len(manifest.Tables) > 0 {
+		names := make([]string, 0, len(manifest.Tables))
+		for _, table := range manifest.Tables {
+			names = append(names, quoteLiteral(relationName{Schema: table.Schema, Name: table.Name}.String()))
+		}
+		fmt.Fprintf(&b, `DO $ceviche$
+DECLARE r record;
+BEGIN
+  FOR r IN SELECT c.conrelid::regclass AS tbl, c.conname FROM pg_catalog.pg_constraint c
+    WHERE c.contype = 'f' AND c.confrelid IN (SELECT pg_catalog.to_regclass(t) FROM unnest(ARRAY[%s]) AS t)
+  LOOP
+    EXECUTE pg_catalog.format('ALTER TABLE %%s DROP CONSTRAINT IF EXISTS %%I', r.tbl, r.conname);
+  END LOOP;
+END
+$ceviche$;
+`, strings.Join(names, ", "))
+	}
+
+	fmt.Fprintf(&b, "\\i %s\n", psqlQuote(filepath.Join(dumpsDir, tableSchemaFile)))
+	for _, table := range manifest.Tables {
+		if table.File == "" {
+			continue
+		}
+		name := relationName{Schema: table.Schema, Name: table.Name}
+		path := psqlQuote(filepath.Join(dumpsDir, table.File))
+		if len(table.Columns) == 0 {
+			fmt.Fprintf(&b, "\\copy %s FROM %s\n", name, path)
+			continue
+		}
+		fmt.Fprintf(&b, "\\copy %s (%s) FROM %s\n", name, strings.Join(quoteIdentifiers(table.Columns), ", "), path)
+	}
+	if _, err := os.Stat(filepath.Join(dumpsDir, tableSequencesFile)); err == nil {
+		fmt.Fprintf(&b, "\\i %s\n", psqlQuote(filepath.Join(dumpsDir, tableSequencesFile)))
+	}
+	fmt.Fprintf(&b, "\\i %s\n", psqlQuote(filepath.Join(dumpsDir, tablePostDataFile)))
+	return b.String()
+}
+
+// runPsqlScript runs a psql script, stopping at the first error, and returns its output
+func runPsqlScript(conn *databaseConnection, script string) ([]byte, error) {
+	file, err := os.CreateTemp("", "ceviche-*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create psql script: %w", err)
+	}
+	defer os.Remove(file.Name()) //nolint:errcheck
+	defer file.Close()           //nolint:errcheck
+
+	if _, err := file.WriteString(script); err != nil {
+		return nil, fmt.Errorf("failed to write psql script: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write psql script: %w", err)
+	}
+
+	cmd := exec.Command("psql", append(conn.args(), "-X", "-q", "-v", "ON_ERROR_STOP=1", "-f", file.Name())...)
+	cmd.Env = conn.env()
+	return cmd.CombinedOutput()
+}
+
+// quoteIdentifier quotes a SQL identifier
+func quoteIdentifier(identifier string) string {
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+// quoteIdentifiers quotes SQL identifiers
+func quoteIdentifiers(identifiers []string) []string {
+	quoted := make([]string, len(identifiers))
+	for i, identifier := range identifiers {
+		quoted[i] = quoteIdentifier(identifier)
+	}
+	return quoted
+}
+
+// quoteLiteral quotes a SQL string literal
+func quoteLiteral(literal string) string {
+	return "'" + strings.ReplaceAll(literal, "'", "''") + "'"
+}
+
+// psqlQuote quotes an argument of a psql meta-command, where backslashes are escapes too
+func psqlQuote(arg string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(arg) + "'"
+}
@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Per-table dump layout", func() {
+	table := func(schema, name string, references ...relationName) relation {
+		return relation{Schema: schema, Name: name, Columns: []string{"id"}, PrimaryKey: []string{"id"}, References: references}
+	}
+	names := func(tables []relation) []string {
+		var result []string
+		for _, t := range tables {
+			result = append(result, t.Schema+"."+t.Name)
+		}
+		return result
+	}
+
+	It("should order referenced tables first", func() {
+		users := relationName{Schema: "public", Name: "users"}
+		orders := relationName{Schema: "public", Name: "orders"}
+		ordered := orderTables([]relation{
+			table("public", "order_lines", orders, relationName{Schema: "catalog", Name: "products"}),
+			table("public", "orders", users),
+			table("public", "users"),
+			table("catalog", "products"),
+			table("audit", "log", relationName{Schema: "other", Name: "excluded"}),
+		})
+		Expect(names(ordered)).To(Equal([]string{
+			"audit.log", "catalog.products", "public.users", "public.orders", "public.order_lines",
+		}))
+	})
+
+	It("should keep tables in a reference cycle", func() {
+		a := relationName{Schema: "public", Name: "a"}
+		b := relationName{Schema: "public", Name: "b"}
+		ordered := orderTables([]relation{table("public", "b", a), table("public", "a", b)})
+		Expect(names(ordered)).To(ConsistOf("public.a", "public.b"))
+	})
+
+	It("should escape data file names", func() {
+		Expect(tableDataFile("public", "users")).To(Equal(filepath.Join("data", "public.users.copy")))
+		Expect(tableDataFile("My Schema", "a.b/c")).To(Equal(filepath.Join("data", "My%20Schema.a%2Eb%2Fc.copy")))
+	})
+
+	It("should copy rows ordered by primary key in one snapshot", func() {
+		manifest := tableManifest{Tables: []manifestTable{
+			{Schema: "public", Name: "users", Columns: []string{"id", "email"}, PrimaryKey: []string{"id"}, File: "data/public.users.copy"},
+			{Schema: "public", Name: "events", Columns: []string{"at", "payload"}, File: "data/public.events.copy"},
+			{Schema: "public", Name: "sessions", Columns: []string{"id"}},
+		}}
+		sequences := []relation{{Schema: "public", Name: "users_id_seq", Sequence: true}}
+
+		script := tableDumpScript("/repo/dumps", "00000003-0000001B-1", manifest, sequences)
+		Expect(script).To(HavePrefix("BEGIN ISOLATION LEVEL REPEATABLE READ, READ ONLY;\nSET TRANSACTION SNAPSHOT '00000003-0000001B-1';\n"))
+		Expect(script).To(ContainSubstring(`\copy (SELECT "id", "email" FROM "public"."users" ORDER BY "id") TO '/repo/dumps/data/public.users.copy'`))
+		Expect(script).To(ContainSubstring(`\copy (SELECT "at", "payload" FROM "public"."events" ORDER BY "at"::text, "payload"::text) TO '/repo/dumps/data/public.events.copy'`))
+		Expect(script).NotTo(ContainSubstring("sessions"))
+		Expect(script).To(ContainSubstring(`\o '/repo/dumps/sequences.sql'`))
+		Expect(script).To(ContainSubstring(`'"public"."users_id_seq"', last_value, is_called) FROM "public"."users_id_seq";`))
+		Expect(script).To(HaveSuffix("COMMIT;\n"))
+	})
+
+	It("should restore the schema, rows in manifest order, sequences and constraints", func() {
+		dumpsDir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dumpsDir, tableSequencesFile), nil, 0644)).To(Succeed())
+		manifest := tableManifest{Tables: []manifestTable{
+			{Schema: "public", Name: "users", Columns: []string{"id", "email"}, File: "data/public.users.copy"},
+			{Schema: "public", Name: "orders", Columns: []string{"id", "user_id"}, File: "data/public.orders.copy"},
+			{Schema: "public", Name: "sessions", Columns: []string{"id"}},
+		}}
+
+		script := tableRestoreScript(dumpsDir, manifest)
+		Expect(script).To(ContainSubstring(`unnest(ARRAY['"public"."users"', '"public"."orders"', '"public"."sessions"'])`))
+		expected := []string{
+			`DROP CONSTRAINT IF EXISTS`,
+			`\i '` + filepath.Join(dumpsDir, "schema.sql") + `'`,
+			`\copy "public"."users" ("id", "email") FROM '` + filepath.Join(dumpsDir, "data/public.users.copy") + `'`,
+			`\copy "public"."orders" ("id", "user_id") FROM '` + filepath.Join(dumpsDir, "data/public.orders.copy") + `'`,
+			`\i '` + filepath.Join(dumpsDir, "sequences.sql") + `'`,
+			`\i '` + filepath.Join(dumpsDir, "post-data.sql") + `'`,
+		}
+		position := -1
+		for _, statement := range expected {
+			index := indexAfter(script, statement, position)
+			Expect(index).To(BeNumerically(">", position), statement)
+			position = index
+		}
+		Expect(script).NotTo(ContainSubstring(`"public"."sessions" (`))
+	})
+
+	It("should select relations like pg_dump", func() {
+		pgSync := &migrationsv1alpha1.PostgresSync{Spec: migrationsv1alpha1.PostgresSyncSpec{
+			Dump: &migrationsv1alpha1.DumpSpec{
+				ExcludeSchemas:   []string{"scratch"},
+				ExcludeTables:    []string{"public.tmp_*"},
+				ExcludeTableData: []string{"audit_log"},
+			},
+		}}
+		filter, err := newRelationFilter(pgSync)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filter.includes("public", "users")).To(BeTrue())
+		Expect(filter.includes("public", "tmp_import")).To(BeFalse())
+		Expect(filter.includes("scratch", "users")).To(BeFalse())
+		Expect(filter.includes("app", "audit_log")).To(BeTrue())
+		Expect(filter.includesData("app", "audit_log")).To(BeFalse())
+
+		pgSync.Spec.Dump = &migrationsv1alpha1.DumpSpec{
+			Schemas:       []string{"app"},
+			IncludeTables: []string{"public.users"},
+		}
+		filter, err = newRelationFilter(pgSync)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filter.includes("public", "users")).To(BeTrue())
+		Expect(filter.includes("app", "orders")).To(BeFalse())
+	})
+
+	It("should mask the rows of table files", func() {
+		path := filepath.Join(GinkgoT().TempDir(), "public.users.copy")
+		Expect(os.WriteFile(path, []byte("1\talice@corp.com\n2\t\\N\n"), 0644)).To(Succeed())
+		m := newMasker([]migrationsv1alpha1.MaskingRule{
+			{Table: "public.users", Column: "email", Strategy: migrationsv1alpha1.MaskingStrategyNull},
+		}, nil)
+		Expect(m.maskTableFile(path, "public.users", []string{"id", "email"})).To(Succeed())
+		Expect(os.ReadFile(path)).To(BeEquivalentTo("1\t\\N\n2\t\\N\n"))
+		Expect(m.unmatchedTables()).To(BeEmpty())
+	})
+
+	It("should detect and remove per-table dumps", func() {
+		dumpsDir := GinkgoT().TempDir()
+		Expect(os.Mkdir(filepath.Join(dumpsDir, tableDataDir), 0755)).To(Succeed())
+		for _, name := range []string{tableManifestFile, tableSchemaFile, tablePostDataFile, "data/public.users.copy"} {
+			Expect(os.WriteFile(filepath.Join(dumpsDir, name), nil, 0644)).To(Succeed())
+		}
+		Expect(hasTableManifest(dumpsDir)).To(BeTrue())
+
+		Expect(removeDumpArtifacts(dumpsDir)).To(Succeed())
+		Expect(hasTableManifest(dumpsDir)).To(BeFalse())
+		Expect(os.ReadDir(dumpsDir)).To(BeEmpty())
+	})
+})
+
+// indexAfter returns the index of substr in s after position, or -1
+func indexAfter(s, substr string, position int) int {
+	index := strings.Index(s[position+1:], substr)
+	if index == -1 {
+		return -1
+	}
+	return position + 1 + index
+}
@@ -0,0 +1,471 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+const (
+	// defaultLockDuration is how long a branch lock lasts unless renewed
+	defaultLockDuration = 2 * time.Minute
+
+	// lockRetryInterval is how often a lock held by someone else is tried again
+	lockRetryInterval = 5 * time.Second
+
+	// lockRefPrefix is where ref locks are pushed to, followed by the branch
+	lockRefPrefix = "refs/cevichedbsync/locks/"
+
+	// Lines of the messages of ref lock commits
+	lockHolderPrefix  = "Holder: "
+	lockExpiresPrefix = "Expires: "
+)
+
+// branchLock is a lock on a branch shared with other processes
+type branchLock interface {
+	// tryLock takes the lock, taking it over when its holder let it expire. It returns false when someone
+	// else holds it
+	tryLock(ctx context.Context) (bool, error)
+	// renew extends the lock, failing when it was lost
+	renew(ctx context.Context) error
+	// unlock releases the lock if it is still held
+	unlock(ctx context.Context) error
+}
+
+// lockBranch takes the locks dumps of a PostgresSync hold while writing to its branch: the one of the operator
+// for the repository and branch, then the one of spec.git.lock. It waits for them until ctx is done and
+// returns the function releasing them, and the one checking they are still held before writing
+func (r *PostgresSyncReconciler) lockBranch(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, auth transport.AuthMethod) (func(), func(context.Context) error, error) {
+	logger := log.FromContext(ctx)
+
+	// Name the default branch so every PostgresSync of the branch shares the lock
+	branch, _, err := resolveRemoteBranch(pgSync.Spec.RepositoryURL, gitBranch(pgSync), auth)
+	if err != nil {
+		return nil, nil, err
+	}
+	if branch == "" {
+		branch = plumbing.HEAD.String()
+	}
+
+	unlock, err := r.lockBranchLocally(ctx, pgSync.Spec.RepositoryURL, branch)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pgSync.Spec.Git == nil || pgSync.Spec.Git.Lock == nil {
+		return unlock, func(context.Context) error { return nil }, nil
+	}
+
+	spec := pgSync.Spec.Git.Lock
+	duration := defaultLockDuration
+	if spec.Duration != nil && spec.Duration.Duration > 0 {
+		duration = spec.Duration.Duration
+	}
+	var lock branchLock
+	switch spec.Type {
+	case "", cevichev1alpha1.GitLockTypeLease:
+		// Leases aren't watched, read them from the API server
+		var reader client.Reader = r.Client
+		if r.APIReader != nil {
+			reader = r.APIReader
+		}
+		lock = &leaseLock{
+			client:   r.Client,
+			reader:   reader,
+			key:      types.NamespacedName{Namespace: pgSync.Namespace, Name: branchLockName(pgSync.Spec.RepositoryURL, branch)},
+			holder:   lockHolder(),
+			duration: duration,
+		}
+	case cevichev1alpha1.GitLockTypeRef:
+		lock = &refLock{
+			repoURL:  pgSync.Spec.RepositoryURL,
+			ref:      plumbing.ReferenceName(lockRefPrefix + branch),
+			auth:     auth,
+			holder:   lockHolder(),
+			duration: duration,
+		}
+	default:
+		unlock()
+		return nil, nil, fmt.Errorf("unsupported lock type %q", spec.Type)
+	}
+
+	if err := waitForLock(ctx, lock, branch); err != nil {
+		unlock()
+		return nil, nil, err
+	}
+
+	// A failed renewal means someone else may hold the lock now, so it is never renewed again and the dump
+	// must not write to the branch anymore
+	var renewing sync.Mutex
+	var lost error
+	renew := func(ctx context.Context) error {
+		renewing.Lock()
+		defer renewing.Unlock()
+		if lost == nil {
+			if err := lock.renew(ctx); err != nil {
+				lost = fmt.Errorf("lost the lock on branch %s: %w", branch, err)
+			}
+		}
+		return lost
+	}
+
+	// Renew the lock until released, without the dump's context that may be cancelled before
+	renewCtx, stopRenewing := context.WithCancel(context.WithoutCancel(ctx))
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(duration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-renewCtx.Done():
+				return
+			case <-ticker.C:
+				if err := renew(renewCtx); err != nil {
+					logger.Error(err, "failed to renew branch lock", "branch", branch)
+					return
+				}
+			}
+		}
+	}()
+
+	release := func() {
+		stopRenewing()
+		<-renewed
+		if err := lock.unlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(err, "failed to release branch lock", "branch", branch)
+		}
+		unlock()
+	}
+	// Renewing right before a write checks the lock is still held and leaves the write its whole duration
+	return release, renew, nil
+}
+
+// waitForLock tries to take lock until it succeeds or ctx is done
+func waitForLock(ctx context.Context, lock branchLock, branch string) error {
+	for waiting := false; ; waiting = true {
+		locked, err := lock.tryLock(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to lock branch %s: %w", branch, err)
+		}
+		if locked {
+			return nil
+		}
+		if !waiting {
+			log.FromContext(ctx).Info("Waiting for the lock on branch", "branch", branch)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for the lock on branch %s: %w", branch, ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// lockBranchLocally takes the lock the operations of the operator on a branch of a repository share, waiting
+// for it until ctx is done
+func (r *PostgresSyncReconciler) lockBranchLocally(ctx context.Context, repoURL, branch string) (func(), error) {
+	value, _ := r.branchLocks.LoadOrStore(repoURL+"\x00"+branch, make(chan struct{}, 1))
+	lock := value.(chan struct{})
+
+	select {
+	case lock <- struct{}{}:
+	default:
+		log.FromContext(ctx).Info("Waiting for another dump to the branch", "branch", branch)
+		select {
+		case lock <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("gave up waiting for the lock on branch %s: %w", branch, ctx.Err())
+		}
+	}
+
+	var once sync.Once
+	return func() { once.Do(func() { <-lock }) }, nil
+}
+
+// branchLockName names the Lease locking a branch of a repository
+func branchLockName(repoURL, branch string) string {
+	sum := sha256.Sum256([]byte(repoURL + "\x00" + branch))
+	return "cevichedbsync-" + hex.EncodeToString(sum[:8])
+}
+
+// lockHolder identifies the holder of a lock, the pod name followed by a random suffix distinguishing
+// the operations of a pod
+func lockHolder() string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "cevichedbsync"
+	}
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// leaseLock locks a branch with a Lease
+type leaseLock struct {
+	client   client.Client
+	reader   client.Reader
+	key      types.NamespacedName
+	holder   string
+	duration time.Duration
+
+	// lease is the Lease as last written
+	lease *coordinationv1.Lease
+}
+
+func (l *leaseLock) tryLock(ctx context.Context) (bool, error) {
+	lease := &coordinationv1.Lease{}
+	if err := l.reader.Get(ctx, l.key, lease); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("failed to get Lease %s: %w", l.key.Name, err)
+		}
+
+		lease = &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: l.key.Namespace, Name: l.key.Name}}
+		l.hold(lease)
+		if err := l.client.Create(ctx, lease); err != nil {
+			if apierrors.IsAlreadyExists(err) {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to create Lease %s: %w", l.key.Name, err)
+		}
+		l.lease = lease
+		return true, nil
+	}
+
+	if holder := lease.Spec.HolderIdentity; holder != nil && *holder != "" && *holder != l.holder && !leaseExpired(lease) {
+		return false, nil
+	}
+	transitions := int32(1)
+	if lease.Spec.LeaseTransitions != nil {
+		transitions += *lease.Spec.LeaseTransitions
+	}
+	lease.Spec.LeaseTransitions = &transitions
+	l.hold(lease)
+	if err := l.client.Update(ctx, lease); err != nil {
+		if apierrors.IsConflict(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to take over Lease %s: %w", l.key.Name, err)
+	}
+	l.lease = lease
+	return true, nil
+}
+
+func (l *leaseLock) renew(ctx context.Context) error {
+	lease := l.lease.DeepCopy()
+	lease.Spec.RenewTime = &metav1.MicroTime{Time: time.Now()}
+	if err := l.client.Update(ctx, lease); err != nil {
+		return fmt.Errorf("failed to renew Lease %s: %w", l.key.Name, err)
+	}
+	l.lease = lease
+	return nil
+}
+
+func (l *leaseLock) unlock(ctx context.Context) error {
+	err := l.client.Delete(ctx, l.lease, client.Preconditions{
+		UID:             &l.lease.UID,
+		ResourceVersion: &l.lease.ResourceVersion,
+	})
+	if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+		return fmt.Errorf("failed to delete Lease %s: %w", l.key.Name, err)
+	}
+	return nil
+}
+
+// hold makes the holder of this lock the holder of lease
+func (l *leaseLock) hold(lease *coordinationv1.Lease) {
+	now := metav1.MicroTime{Time: time.Now()}
+	holder := l.holder
+	seconds := int32(l.duration.Seconds())
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+}
+
+// leaseExpired tells whether the holder of lease let it expire
+func leaseExpired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return time.Now().After(expiry)
+}
+
+// refLock locks a branch with a ref pushed to the remote, pointing to a commit naming the holder and when the
+// lock expires. Pushes only ever fast-forward the ref, so the remote arbitrates between concurrent holders
+type refLock struct {
+	repoURL  string
+	ref      plumbing.ReferenceName
+	auth     transport.AuthMethod
+	holder   string
+	duration time.Duration
+
+	// repo holds the lock commits, commit is the last one pushed
+	repo   *git.Repository
+	commit plumbing.Hash
+}
+
+func (l *refLock) tryLock(ctx context.Context) (bool, error) {
+	if l.repo == nil {
+		repo, err := git.Init(memory.NewStorage(), nil)
+		if err != nil {
+			return false, fmt.Errorf("failed to init lock repository: %w", err)
+		}
+		if _, err := repo.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{l.repoURL}}); err != nil {
+			return false, fmt.Errorf("failed to create remote: %w", err)
+		}
+		l.repo = repo
+	}
+
+	// Fetch the current lock, if any, and see whether it is still held
+	err := l.repo.FetchContext(ctx, &git.FetchOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", l.ref, l.ref))},
+		Auth:     l.auth,
+	})
+	var parent plumbing.Hash
+	switch {
+	case err == nil || errors.Is(err, git.NoErrAlreadyUpToDate):
+		ref, err := l.repo.Reference(l.ref, false)
+		if err != nil {
+			return false, fmt.Errorf("failed to resolve lock ref: %w", err)
+		}
+		commit, err := l.repo.CommitObject(ref.Hash())
+		if err != nil {
+			return false, fmt.Errorf("failed to read lock commit: %w", err)
+		}
+		holder, expires := parseLockMessage(commit.Message)
+		if holder != l.holder && time.Now().Before(expires) {
+			return false, nil
+		}
+		parent = commit.Hash
+	case errors.Is(err, git.NoMatchingRefSpecError{}), errors.Is(err, transport.ErrEmptyRemoteRepository):
+	default:
+		return false, fmt.Errorf("failed to fetch lock ref: %w", err)
+	}
+
+	if err := l.push(ctx, parent); err != nil {
+		if isPushRejected(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *refLock) renew(ctx context.Context) error {
+	if err := l.push(ctx, l.commit); err != nil {
+		return fmt.Errorf("failed to renew lock ref: %w", err)
+	}
+	return nil
+}
+
+func (l *refLock) unlock(ctx context.Context) error {
+	// Leave the ref alone when someone else took the lock over
+	refs, err := l.repo.Remotes()
+	if err != nil || len(refs) == 0 {
+		return fmt.Errorf("failed to get remote: %w", err)
+	}
+	remoteRefs, err := refs[0].ListContext(ctx, &git.ListOptions{Auth: l.auth})
+	if err != nil {
+		return fmt.Errorf("failed to list remote references: %w", err)
+	}
+	for _, ref := range remoteRefs {
+		if ref.Name() == l.ref && ref.Hash() != l.commit {
+			return nil
+		}
+	}
+
+	err = l.repo.PushContext(ctx, &git.PushOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(":" + l.ref)},
+		Auth:     l.auth,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to delete lock ref: %w", err)
+	}
+	return nil
+}
+
+// push pushes a lock commit on top of parent, expiring after the lock duration
+func (l *refLock) push(ctx context.Context, parent plumbing.Hash) error {
+	now := time.Now()
+	signature := object.Signature{Name: defaultAuthorName, Email: defaultAuthorEmail, When: now}
+	commit := &object.Commit{
+		Author:    signature,
+		Committer: signature,
+		Message: fmt.Sprintf("Lock %s\n\n%s%s\n%s%s\n", l.ref.Short(),
+			lockHolderPrefix, l.holder, lockExpiresPrefix, now.Add(l.duration).UTC().Format(time.RFC3339)),
+	}
+	if !parent.IsZero() {
+		commit.ParentHashes = []plumbing.Hash{parent}
+	}
+
+	// Lock commits have an empty tree
+	tree := l.repo.Storer.NewEncodedObject()
+	if err := (&object.Tree{}).Encode(tree); err != nil {
+		return fmt.Errorf("failed to encode lock tree: %w", err)
+	}
+	treeHash, err := l.repo.Storer.SetEncodedObject(tree)
+	if err != nil {
+		return fmt.Errorf("failed to store lock tree: %w", err)
+	}
+	commit.TreeHash = treeHash
+	encoded := l.repo.Storer.NewEncodedObject()
+	if err := commit.Encode(encoded); err != nil {
+		return fmt.Errorf("failed to encode lock commit: %w", err)
+	}
+	hash, err := l.repo.Storer.SetEncodedObject(encoded)
+	if err != nil {
+		return fmt.Errorf("failed to store lock commit: %w", err)
+	}
+	if err := l.repo.Storer.SetReference(plumbing.NewHashReference(l.ref, hash)); err != nil {
+		return fmt.Errorf("failed to update lock ref: %w", err)
+	}
+
+	err = l.repo.PushContext(ctx, &git.PushOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", l.ref, l.ref))},
+		Auth:     l.auth,
+	})
+	if err != nil {
+		return err
+	}
+	l.commit = hash
+	return nil
+}
+
+// parseLockMessage reads the holder of a ref lock and when it expires from the message of its commit
+func parseLockMessage(message string) (string, time.Time) {
+	var holder string
+	var expires time.Time
+	for _, line := range strings.Split(message, "\n") {
+		if value, found := strings.CutPrefix(line, lockHolderPrefix); found {
+			holder = value
+		}
+		if value, found := strings.CutPrefix(line, lockExpiresPrefix); found {
+			expires, _ = time.Parse(time.RFC3339, value)
+		}
+	}
+	return holder, expires
+}
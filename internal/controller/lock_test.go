@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Branch locks", func() {
+	ctx := context.Background()
+
+	It("should queue dumps to the same branch within the operator", func() {
+		reconciler := &PostgresSyncReconciler{}
+		unlock, err := reconciler.lockBranchLocally(ctx, "https://example.com/db.git", "main")
+		Expect(err).NotTo(HaveOccurred())
+
+		// Other branches aren't held up
+		unlockOther, err := reconciler.lockBranchLocally(ctx, "https://example.com/db.git", "dumps")
+		Expect(err).NotTo(HaveOccurred())
+		unlockOther()
+
+		waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err = reconciler.lockBranchLocally(waitCtx, "https://example.com/db.git", "main")
+		Expect(err).To(MatchError(ContainSubstring("gave up waiting")))
+
+		locked := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			unlock, err := reconciler.lockBranchLocally(ctx, "https://example.com/db.git", "main")
+			Expect(err).NotTo(HaveOccurred())
+			close(locked)
+			unlock()
+		}()
+		Consistently(locked).ShouldNot(BeClosed())
+		unlock()
+		unlock()
+		Eventually(locked).Should(BeClosed())
+	})
+
+	It("should lock branches with Leases, taken over once expired", func() {
+		c := fake.NewClientBuilder().Build()
+		key := types.NamespacedName{Namespace: "default", Name: branchLockName("https://example.com/db.git", "main")}
+		first := &leaseLock{client: c, reader: c, key: key, holder: "first", duration: time.Minute}
+		second := &leaseLock{client: c, reader: c, key: key, holder: "second", duration: time.Minute}
+
+		Expect(first.tryLock(ctx)).To(BeTrue())
+		Expect(second.tryLock(ctx)).To(BeFalse())
+		Expect(first.renew(ctx)).To(Succeed())
+		Expect(first.unlock(ctx)).To(Succeed())
+		Expect(second.tryLock(ctx)).To(BeTrue())
+
+		// The holder stopped renewing the Lease
+		lease := &coordinationv1.Lease{}
+		Expect(c.Get(ctx, key, lease)).To(Succeed())
+		lease.Spec.RenewTime = &metav1.MicroTime{Time: time.Now().Add(-2 * time.Minute)}
+		Expect(c.Update(ctx, lease)).To(Succeed())
+
+		Expect(first.tryLock(ctx)).To(BeTrue())
+		Expect(c.Get(ctx, key, lease)).To(Succeed())
+		Expect(*lease.Spec.HolderIdentity).To(Equal("first"))
+		Expect(*lease.Spec.LeaseTransitions).To(BeEquivalentTo(1))
+	})
+
+	Context("with refs in the remote", func() {
+		var remoteDir string
+
+		BeforeEach(func() {
+			remoteDir = GinkgoT().TempDir()
+			_, err := git.PlainInit(remoteDir, true)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		lockRef := func(holder string, duration time.Duration) *refLock {
+			return &refLock{
+				repoURL:  remoteDir,
+				ref:      plumbing.ReferenceName(lockRefPrefix + "main"),
+				holder:   holder,
+				duration: duration,
+			}
+		}
+
+		It("should lock branches with a ref, taken over once expired", func() {
+			first, second := lockRef("first", time.Minute), lockRef("second", -time.Minute)
+
+			Expect(first.tryLock(ctx)).To(BeTrue())
+			Expect(second.tryLock(ctx)).To(BeFalse())
+			Expect(first.renew(ctx)).To(Succeed())
+			Expect(first.unlock(ctx)).To(Succeed())
+
+			remote, err := git.PlainOpen(remoteDir)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = remote.Reference(second.ref, false)
+			Expect(err).To(MatchError(plumbing.ErrReferenceNotFound))
+
+			// The second lock expires right away, the first takes it over and the second can't renew it
+			Expect(second.tryLock(ctx)).To(BeTrue())
+			Expect(first.tryLock(ctx)).To(BeTrue())
+			Expect(second.renew(ctx)).To(MatchError(ContainSubstring("non-fast-forward")))
+			Expect(second.unlock(ctx)).To(Succeed())
+			ref, err := remote.Reference(first.ref, false)
+			Expect(err).NotTo(HaveOccurred())
+			commit, err := remote.CommitObject(ref.Hash())
+			Expect(err).NotTo(HaveOccurred())
+			holder, expires := parseLockMessage(commit.Message)
+			Expect(holder).To(Equal("first"))
+			Expect(expires).To(BeTemporally("~", time.Now().Add(time.Minute), 2*time.Second))
+		})
+
+		It("should queue dumps of operators sharing the repository", func() {
+			repoDir, err := (&PostgresSyncReconciler{}).cloneRepository(remoteDir, "master", nil, cloneOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, repoDir)
+			Expect(os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("readme"), 0644)).To(Succeed())
+			Expect((&PostgresSyncReconciler{}).commitAndPushChanges(repoDir, nil, dumpCommit{Author: commitAuthor(&migrationsv1alpha1.PostgresSync{}), Message: "Initial commit"})).To(Succeed())
+
+			// The default branch is locked under its name
+			pgSync := &migrationsv1alpha1.PostgresSync{Spec: migrationsv1alpha1.PostgresSyncSpec{
+				RepositoryURL: remoteDir,
+				Git:           &migrationsv1alpha1.GitSpec{Lock: &migrationsv1alpha1.GitLockSpec{Type: migrationsv1alpha1.GitLockTypeRef}},
+			}}
+			unlock, held, err := (&PostgresSyncReconciler{}).lockBranch(ctx, pgSync, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(held(ctx)).To(Succeed())
+
+			waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			_, _, err = (&PostgresSyncReconciler{}).lockBranch(waitCtx, pgSync, nil)
+			Expect(err).To(MatchError(ContainSubstring("gave up waiting for the lock on branch master")))
+
+			unlock()
+			unlock, _, err = (&PostgresSyncReconciler{}).lockBranch(ctx, pgSync, nil)
+			Expect(err).NotTo(HaveOccurred())
+			unlock()
+		})
+
+		It("should stop a dump from writing once its lock was taken over", func() {
+			repoDir, err := (&PostgresSyncReconciler{}).cloneRepository(remoteDir, "master", nil, cloneOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, repoDir)
+			Expect(os.WriteFile(filepath.Join(repoDir, "README.md"), []byte("readme"), 0644)).To(Succeed())
+			Expect((&PostgresSyncReconciler{}).commitAndPushChanges(repoDir, nil, dumpCommit{Author: commitAuthor(&migrationsv1alpha1.PostgresSync{}), Message: "Initial commit"})).To(Succeed())
+
+			pgSync := &migrationsv1alpha1.PostgresSync{Spec: migrationsv1alpha1.PostgresSyncSpec{
+				RepositoryURL: remoteDir,
+				Git:           &migrationsv1alpha1.GitSpec{Lock: &migrationsv1alpha1.GitLockSpec{Type: migrationsv1alpha1.GitLockTypeRef}},
+			}}
+			unlock, held, err := (&PostgresSyncReconciler{}).lockBranch(ctx, pgSync, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer unlock()
+
+			// Someone else moved the lock ref, the lock can't be renewed for the write anymore
+			remote, err := git.PlainOpen(remoteDir)
+			Expect(err).NotTo(HaveOccurred())
+			head, err := remote.Reference(plumbing.NewBranchReferenceName("master"), true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(remote.Storer.SetReference(plumbing.NewHashReference(lockRefPrefix+"master", head.Hash()))).To(Succeed())
+			Expect(held(ctx)).To(MatchError(ContainSubstring("lost the lock on branch master")))
+			Expect(held(ctx)).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,535 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// copyNull is how COPY text data writes NULL values
+const copyNull = `\N`
+
+// Names the fakeName strategy picks from
+var (
+	fakeFirstNames = []string{
+		"Alex", "Ana", "Bruno", "Carla", "Daniel", "Elena", "Felix", "Gabriela", "Hugo", "Irene",
+		"Jorge", "Julia", "Kevin", "Laura", "Mario", "Nora", "Oscar", "Paula", "Rafael", "Sofia",
+		"Tomas", "Valeria", "Victor", "Ximena",
+	}
+	fakeLastNames = []string{
+		"Alvarez", "Brown", "Castro", "Diaz", "Evans", "Flores", "Garcia", "Herrera", "Jones", "Lopez",
+		"Martin", "Moreno", "Nunez", "Ortiz", "Perez", "Quispe", "Ramos", "Rojas", "Smith", "Torres",
+		"Vargas", "Walker", "Young", "Zapata",
+	}
+)
+
+// masker rewrites the COPY data of plain dumps according to masking rules
+type masker struct {
+	salt []byte
+	// rules maps qualified table names to the rules of their columns
+	rules map[string]map[string]cevichev1alpha1.MaskingRule
+	// matched records the tables found in the dump
+	matched map[string]bool
+}
+
+// dumpMasker returns the masker for the rules of the PostgresSync, nil when nothing is masked
+func (r *PostgresSyncReconciler) dumpMasker(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) (*masker, error) {
+	if pgSync.Spec.Dump == nil || pgSync.Spec.Dump.Masking == nil {
+		return nil, nil
+	}
+
+	masking := pgSync.Spec.Dump.Masking
+	if masking.Salt == nil {
+		for _, rule := range masking.Rules {
+			if saltedStrategy(rule.Strategy) {
+				return nil, fmt.Errorf("masking salt is required by the %s strategy of %s.%s", rule.Strategy, rule.Table, rule.Column)
+			}
+		}
+	}
+
+	var salt []byte
+	if masking.Salt != nil {
+		secret := &corev1.Secret{}
+		secretKey := types.NamespacedName{Name: masking.Salt.SecretName, Namespace: pgSync.Namespace}
+		if err := r.Get(ctx, secretKey, secret); err != nil {
+			return nil, fmt.Errorf("failed to get masking salt: %w", err)
+		}
+		salt = secret.Data[masking.Salt.Key]
+		if len(salt) == 0 {
+			return nil, fmt.Errorf("masking salt secret %s has no %q key", masking.Salt.SecretName, masking.Salt.Key)
+		}
+	}
+
+	return newMasker(masking.Rules, salt), nil
+}
+
+// saltedStrategy reports whether the strategy derives values from the salt
+func saltedStrategy(strategy cevichev1alpha1.MaskingStrategy) bool {
+	switch strategy {
+	case cevichev1alpha1.MaskingStrategyHash, cevichev1alpha1.MaskingStrategyPseudonymize,
+		cevichev1alpha1.MaskingStrategyFakeEmail, cevichev1alpha1.MaskingStrategyFakeName:
+		return true
+	}
+	return false
+}
+
+// newMasker indexes masking rules by table and column
+func newMasker(rules []cevichev1alpha1.MaskingRule, salt []byte) *masker {
+	m := &masker{
+		salt:    salt,
+		rules:   make(map[string]map[string]cevichev1alpha1.MaskingRule),
+		matched: make(map[string]bool),
+	}
+	for _, rule := range rules {
+		table := rule.Table
+		if !strings.Contains(table, ".") {
+			table = "public." + table
+		}
+		if m.rules[table] == nil {
+			m.rules[table] = make(map[string]cevichev1alpha1.MaskingRule)
+		}
+		m.rules[table][rule.Column] = rule
+	}
+	return m
+}
+
+// fingerprint returns the SHA-256 of the rules and the salt, empty without a masker
+func (m *masker) fingerprint() string {
+	if m == nil {
+		return ""
+	}
+	h := sha256.New()
+	tables := make([]string, 0, len(m.rules))
+	for table := range m.rules {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		columns := make([]string, 0, len(m.rules[table]))
+		for column := range m.rules[table] {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+		for _, column := range columns {
+			rule := m.rules[table][column]
+			value := ""
+			if rule.Value != nil {
+				value = "=" + *rule.Value
+			}
+			fmt.Fprintf(h, "%q %q %s%q\n", table, column, rule.Strategy, value)
+		}
+	}
+	h.Write(m.salt)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// unmatchedTables returns the tables with masking rules that weren't found in the dump, e.g. because
+// they were excluded or misspelled
+func (m *masker) unmatchedTables() []string {
+	var tables []string
+	for table := range m.rules {
+		if !m.matched[table] {
+			tables = append(tables, table)
+		}
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// maskFile masks the plain dump at path in place
+func (m *masker) maskFile(path string) error {
+	return maskInPlace(path, m.mask)
+}
+
+// maskTableFile masks the COPY data file of a per-table dump in place, names are the columns of the file
+func (m *masker) maskTableFile(path, table string, names []string) error {
+	if m.rules[table] == nil {
+		return nil
+	}
+	m.matched[table] = true
+	columns, err := m.columnRules(table, names)
+	if err != nil {
+		return err
+	}
+	return maskInPlace(path, func(w io.Writer, r io.Reader) error {
+		reader := bufio.NewReader(r)
+		for {
+			line, readErr := reader.ReadString('\n')
+			if readErr != nil && readErr != io.EOF {
+				return fmt.Errorf("failed to read dump: %w", readErr)
+			}
+			if line != "" {
+				if _, err := io.WriteString(w, m.maskRow(line, columns)); err != nil {
+					return fmt.Errorf("failed to write masked dump: %w", err)
+				}
+			}
+			if readErr == io.EOF {
+				return nil
+			}
+		}
+	})
+}
+
+// maskInPlace rewrites the file at path with mask
+func maskInPlace(path string, mask func(w io.Writer, r io.Reader) error) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open dump: %w", err)
+	}
+	defer in.Close() //nolint:errcheck
+
+	maskedPath := path + ".masked"
+	out, err := os.Create(maskedPath)
+	if err != nil {
+		return fmt.Errorf("failed to create masked dump: %w", err)
+	}
+	defer out.Close() //nolint:errcheck
+
+	writer := bufio.NewWriter(out)
+	if err := mask(writer, in); err != nil {
+		_ = os.Remove(maskedPath)
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write masked dump: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write masked dump: %w", err)
+	}
+	if err := os.Rename(maskedPath, path); err != nil {
+		return fmt.Errorf("failed to replace dump with masked dump: %w", err)
+	}
+	return nil
+}
+
+// mask copies a plain dump from r to w, rewriting the rows of the COPY blocks of masked tables
+func (m *masker) mask(w io.Writer, r io.Reader) error {
+	reader := bufio.NewReader(r)
+	inCopy := false
+	// columns holds the rule of each column of the COPY block being read, nil for unmasked tables
+	var columns []*cevichev1alpha1.MaskingRule
+	for {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return fmt.Errorf("failed to read dump: %w", readErr)
+		}
+
+		switch {
+		case inCopy && (line == "\\.\n" || line == "\\."):
+			inCopy, columns = false, nil
+		case inCopy:
+			if columns != nil {
+				line = m.maskRow(line, columns)
+			}
+		default:
+			table, names, ok := parseCopyStatement(line)
+			if !ok {
+				break
+			}
+			inCopy = true
+			if m.rules[table] != nil {
+				m.matched[table] = true
+				var err error
+				if columns, err = m.columnRules(table, names); err != nil {
+					return err
+				}
+			}
+		}
+
+		if _, err := io.WriteString(w, line); err != nil {
+			return fmt.Errorf("failed to write masked dump: %w", err)
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// columnRules returns the rule of each column of a COPY block. A rule for a column the table doesn't
+// have fails the dump rather than leaving a renamed column unmasked
+func (m *masker) columnRules(table string, names []string) ([]*cevichev1alpha1.MaskingRule, error) {
+	rules := m.rules[table]
+	columns := make([]*cevichev1alpha1.MaskingRule, len(names))
+	found := 0
+	for i, name := range names {
+		if rule, ok := rules[name]; ok {
+			columns[i] = &rule
+			found++
+		}
+	}
+	if found != len(rules) {
+		for column := range rules {
+			if !slices.Contains(names, column) {
+				return nil, fmt.Errorf("masked column %s not found in table %s", column, table)
+			}
+		}
+	}
+	return columns, nil
+}
+
+// maskRow masks the fields of a COPY row
+func (m *masker) maskRow(line string, columns []*cevichev1alpha1.MaskingRule) string {
+	row, newline := strings.CutSuffix(line, "\n")
+	fields := strings.Split(row, "\t")
+	for i, field := range fields {
+		if i < len(columns) && columns[i] != nil {
+			fields[i] = m.maskField(field, columns[i])
+		}
+	}
+	masked := strings.Join(fields, "\t")
+	if newline {
+		masked += "\n"
+	}
+	return masked
+}
+
+// maskField masks a COPY field, in its escaped text representation
+func (m *masker) maskField(field string, rule *cevichev1alpha1.MaskingRule) string {
+	switch rule.Strategy {
+	case cevichev1alpha1.MaskingStrategyNull:
+		return copyNull
+	case cevichev1alpha1.MaskingStrategyConstant:
+		if rule.Value == nil {
+			return copyNull
+		}
+		return encodeCopyValue(*rule.Value)
+	}
+
+	if field == copyNull {
+		return field
+	}
+	value := decodeCopyValue(field)
+	switch rule.Strategy {
+	case cevichev1alpha1.MaskingStrategyHash:
+		return hex.EncodeToString(m.digest(value, 0))
+	case cevichev1alpha1.MaskingStrategyPseudonymize:
+		return encodeCopyValue(m.pseudonymize(value))
+	case cevichev1alpha1.MaskingStrategyFakeEmail:
+		return "user-" + hex.EncodeToString(m.digest(value, 0))[:12] + "@example.com"
+	case cevichev1alpha1.MaskingStrategyFakeName:
+		digest := m.digest(value, 0)
+		first := fakeFirstNames[int(binary.BigEndian.Uint16(digest[0:2]))%len(fakeFirstNames)]
+		last := fakeLastNames[int(binary.BigEndian.Uint16(digest[2:4]))%len(fakeLastNames)]
+		return first + " " + last
+	default:
+		return field
+	}
+}
+
+// digest returns the HMAC-SHA256 of the value keyed with the salt, counter extends it for long values
+func (m *masker) digest(value string, counter uint32) []byte {
+	mac := hmac.New(sha256.New, m.salt)
+	if counter > 0 {
+		_ = binary.Write(mac, binary.BigEndian, counter)
+	}
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// pseudonymize replaces each letter and digit of the value with one of the same class picked from its
+// digest, so the same value always gives the same pseudonym and foreign keys keep matching
+func (m *masker) pseudonymize(value string) string {
+	var b strings.Builder
+	var stream []byte
+	var counter uint32
+	for _, c := range value {
+		if len(stream) == 0 {
+			stream = m.digest(value, counter)
+			counter++
+		}
+		n := int(stream[0])
+		stream = stream[1:]
+
+		switch {
+		case c >= '0' && c <= '9':
+			b.WriteByte(byte('0' + n%10))
+		case c >= 'a' && c <= 'z':
+			b.WriteByte(byte('a' + n%26))
+		case c >= 'A' && c <= 'Z':
+			b.WriteByte(byte('A' + n%26))
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// parseCopyStatement parses a "COPY schema.table (column, ...) FROM stdin;" line of a plain dump and
+// returns the qualified table name and the column names, unquoted
+func parseCopyStatement(line string) (string, []string, bool) {
+	rest, ok := strings.CutPrefix(line, "COPY ")
+	if !ok {
+		return "", nil, false
+	}
+	rest, ok = strings.CutSuffix(strings.TrimRight(rest, "\r\n"), " FROM stdin;")
+	if !ok {
+		return "", nil, false
+	}
+
+	names, rest := parseIdentifiers(rest, '.')
+	if len(names) == 0 || len(names) > 2 {
+		return "", nil, false
+	}
+	if len(names) == 1 {
+		names = append([]string{"public"}, names...)
+	}
+	table := names[0] + "." + names[1]
+
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return table, nil, true
+	}
+	columnList, ok := strings.CutPrefix(rest, "(")
+	if !ok {
+		return "", nil, false
+	}
+	columns, rest := parseIdentifiers(columnList, ',')
+	if strings.TrimSpace(rest) != ")" {
+		return "", nil, false
+	}
+	return table, columns, true
+}
+
+// parseIdentifiers parses SQL identifiers separated by sep, double quoted or not, and returns them along
+// with the rest of s
+func parseIdentifiers(s string, sep byte) ([]string, string) {
+	var identifiers []string
+	for {
+		s = strings.TrimLeft(s, " ")
+		var identifier strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			i := 1
+			for ; i < len(s); i++ {
+				if s[i] != '"' {
+					identifier.WriteByte(s[i])
+					continue
+				}
+				// A doubled quote stands for a literal quote
+				if i+1 < len(s) && s[i+1] == '"' {
+					identifier.WriteByte('"')
+					i++
+					continue
+				}
+				break
+			}
+			if i >= len(s) {
+				return nil, s
+			}
+			s = s[i+1:]
+		} else {
+			end := strings.IndexAny(s, " ()"+string(sep))
+			if end == -1 {
+				end = len(s)
+			}
+			if end == 0 {
+				return identifiers, s
+			}
+			identifier.WriteString(s[:end])
+			s = s[end:]
+		}
+		identifiers = append(identifiers, identifier.String())
+
+		if !strings.HasPrefix(s, string(sep)) {
+			return identifiers, s
+		}
+		s = s[1:]
+	}
+}
+
+// decodeCopyValue unescapes a field of COPY text data
+func decodeCopyValue(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		if c != '\\' || i+1 == len(field) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = field[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			n := 0
+			j := i
+			for ; j < len(field) && j < i+3 && field[j] >= '0' && field[j] <= '7'; j++ {
+				n = n*8 + int(field[j]-'0')
+			}
+			b.WriteByte(byte(n))
+			i = j - 1
+		case 'x':
+			n := 0
+			j := i + 1
+			for ; j < len(field) && j < i+3 && isHexDigit(field[j]); j++ {
+				n = n*16 + hexValue(field[j])
+			}
+			if j == i+1 {
+				b.WriteByte('x')
+				continue
+			}
+			b.WriteByte(byte(n))
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// encodeCopyValue escapes a value for COPY text data
+func encodeCopyValue(value string) string {
+	return copyEscaper.Replace(value)
+}
+
+// copyEscaper escapes the characters COPY text data can't hold literally
+var copyEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"\b", `\b`,
+	"\f", `\f`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"\v", `\v`,
+)
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	default:
+		return int(c-'A') + 10
+	}
+}
@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Dump masking", func() {
+	const dump = `--
+-- PostgreSQL database dump
+--
+
+CREATE TABLE public.users (id integer, email text, name text, token text, notes text);
+
+COPY public.users (id, email, name, token, notes) FROM stdin;
+1	alice@corp.com	Alice Doe	s3cr3t	line\twith\\tabs
+2	\N	Bob Roe	t0k3n	\N
+\.
+
+COPY public.orders (id, user_email) FROM stdin;
+10	alice@corp.com
+\.
+
+COPY "Billing"."Invoice Lines" ("Customer Email", amount) FROM stdin;
+alice@corp.com	12.50
+\.
+
+`
+
+	constant := "[redacted]\tx"
+	rules := []migrationsv1alpha1.MaskingRule{
+		{Table: "users", Column: "email", Strategy: migrationsv1alpha1.MaskingStrategyPseudonymize},
+		{Table: "public.users", Column: "name", Strategy: migrationsv1alpha1.MaskingStrategyFakeName},
+		{Table: "public.users", Column: "token", Strategy: migrationsv1alpha1.MaskingStrategyNull},
+		{Table: "public.users", Column: "notes", Strategy: migrationsv1alpha1.MaskingStrategyConstant, Value: &constant},
+		{Table: "public.orders", Column: "user_email", Strategy: migrationsv1alpha1.MaskingStrategyPseudonymize},
+		{Table: "Billing.Invoice Lines", Column: "Customer Email", Strategy: migrationsv1alpha1.MaskingStrategyFakeEmail},
+	}
+
+	maskDump := func(m *masker) []string {
+		path := filepath.Join(GinkgoT().TempDir(), "dump.sql")
+		Expect(os.WriteFile(path, []byte(dump), 0644)).To(Succeed())
+		Expect(m.maskFile(path)).To(Succeed())
+		masked, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return strings.Split(string(masked), "\n")
+	}
+
+	It("should mask the columns of COPY blocks", func() {
+		lines := maskDump(newMasker(rules, []byte("salt")))
+		Expect(strings.Join(lines, "\n")).NotTo(ContainSubstring("alice@corp.com"))
+		Expect(strings.Join(lines, "\n")).To(ContainSubstring("CREATE TABLE public.users"))
+
+		alice := strings.Split(lines[7], "\t")
+		Expect(alice[0]).To(Equal("1"))
+		Expect(alice[1]).To(MatchRegexp(`^[a-z]{5}@[a-z]{4}\.[a-z]{3}$`))
+		Expect(alice[2]).To(MatchRegexp(`^[A-Z][a-z]+ [A-Z][a-z]+$`))
+		Expect(alice[3]).To(Equal(`\N`))
+		Expect(alice[4]).To(Equal(`[redacted]\tx`))
+		Expect(alice).To(HaveLen(5))
+
+		// NULL values stay NULL
+		bob := strings.Split(lines[8], "\t")
+		Expect(bob[1]).To(Equal(`\N`))
+		Expect(bob[4]).To(Equal(`[redacted]\tx`))
+
+		// Pseudonyms are consistent across tables
+		order := strings.Split(lines[12], "\t")
+		Expect(order[1]).To(Equal(alice[1]))
+
+		invoice := strings.Split(lines[16], "\t")
+		Expect(invoice[0]).To(MatchRegexp(`^user-[0-9a-f]{12}@example\.com$`))
+		Expect(invoice[1]).To(Equal("12.50"))
+	})
+
+	It("should derive pseudonyms from the salt", func() {
+		salted := maskDump(newMasker(rules, []byte("salt")))
+		resalted := maskDump(newMasker(rules, []byte("other salt")))
+		Expect(maskDump(newMasker(rules, []byte("salt")))).To(Equal(salted))
+		Expect(resalted[7]).NotTo(Equal(salted[7]))
+	})
+
+	It("should hash values", func() {
+		m := newMasker(nil, []byte("salt"))
+		rule := &migrationsv1alpha1.MaskingRule{Strategy: migrationsv1alpha1.MaskingStrategyHash}
+		Expect(m.maskField("alice", rule)).To(MatchRegexp(`^[0-9a-f]{64}$`))
+		Expect(m.maskField("alice", rule)).To(Equal(m.maskField("alice", rule)))
+		Expect(m.maskField(`\N`, rule)).To(Equal(`\N`))
+	})
+
+	It("should require a salt for derived values", func() {
+		ctx := context.Background()
+		r := &PostgresSyncReconciler{}
+		pgSync := &migrationsv1alpha1.PostgresSync{}
+		pgSync.Spec.Dump = &migrationsv1alpha1.DumpSpec{Masking: &migrationsv1alpha1.MaskingSpec{Rules: rules}}
+		_, err := r.dumpMasker(ctx, pgSync)
+		Expect(err).To(MatchError(ContainSubstring("masking salt is required by the pseudonymize strategy of users.email")))
+
+		pgSync.Spec.Dump.Masking.Rules = []migrationsv1alpha1.MaskingRule{
+			{Table: "public.users", Column: "token", Strategy: migrationsv1alpha1.MaskingStrategyNull},
+		}
+		m, err := r.dumpMasker(ctx, pgSync)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m).NotTo(BeNil())
+	})
+
+	It("should fail when a masked column is missing", func() {
+		m := newMasker([]migrationsv1alpha1.MaskingRule{
+			{Table: "public.users", Column: "phone", Strategy: migrationsv1alpha1.MaskingStrategyNull},
+		}, nil)
+		path := filepath.Join(GinkgoT().TempDir(), "dump.sql")
+		Expect(os.WriteFile(path, []byte(dump), 0644)).To(Succeed())
+		Expect(m.maskFile(path)).To(MatchError(ContainSubstring("phone")))
+		Expect(os.ReadFile(path)).To(BeEquivalentTo(dump))
+	})
+
+	It("should report rules for tables missing from the dump", func() {
+		m := newMasker(append(rules, migrationsv1alpha1.MaskingRule{
+			Table: "audit.log", Column: "ip", Strategy: migrationsv1alpha1.MaskingStrategyNull,
+		}), nil)
+		maskDump(m)
+		Expect(m.unmatchedTables()).To(Equal([]string{"audit.log"}))
+	})
+
+	It("should parse COPY statements", func() {
+		table, columns, ok := parseCopyStatement(`COPY "My ""Schema"""."t" (a, "B c", "d,e") FROM stdin;` + "\n")
+		Expect(ok).To(BeTrue())
+		Expect(table).To(Equal(`My "Schema".t`))
+		Expect(columns).To(Equal([]string{"a", "B c", "d,e"}))
+
+		table, columns, ok = parseCopyStatement("COPY public.empty  FROM stdin;\n")
+		Expect(ok).To(BeTrue())
+		Expect(table).To(Equal("public.empty"))
+		Expect(columns).To(BeEmpty())
+
+		_, _, ok = parseCopyStatement("COPY public.t (a) TO stdout;\n")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should round trip COPY escapes", func() {
+		value := "tab\there\nnew line \\ backslash"
+		Expect(decodeCopyValue(encodeCopyValue(value))).To(Equal(value))
+		Expect(decodeCopyValue(`\101\x42`)).To(Equal("AB"))
+		Expect(regexp.MustCompile(`^[a-z]+$`).MatchString(newMasker(nil, nil).pseudonymize("abcdefghijklmnopqrstuvwxyzabcdefghijklmnop"))).To(BeTrue())
+	})
+})
@@ -0,0 +1,262 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// dumpNameLayout names the dumps kept in an object store after the time they were taken, so their names sort
+// by time
+const dumpNameLayout = "20060102T150405Z"
+
+// isDumpName tells whether name is the name of a dump, anything else under the root wasn't stored by the operator
+func isDumpName(name string) bool {
+	_, err := time.Parse(dumpNameLayout, name)
+	return err == nil
+}
+
+// objectStore is a flat store of files under slash separated keys, such as an S3 bucket
+type objectStore interface {
+	// list returns the keys starting with prefix
+	list(ctx context.Context, prefix string) ([]string, error)
+
+	// download writes the object at key to the file at path
+	download(ctx context.Context, key, path string) error
+
+	// upload stores the file at path under key
+	upload(ctx context.Context, path, key string) error
+
+	// remove deletes the objects at keys
+	remove(ctx context.Context, keys []string) error
+
+	// location returns the URL of the object, or objects, at key
+	location(key string) string
+}
+
+// objectStorage keeps each dump in an object store under root/<name>/, laid out like the dumps directory.
+// The checksums of a dump are stored last, dumps without them are incomplete and never restored
+type objectStorage struct {
+	objects objectStore
+	root    string
+	// keep is how many dumps are kept, all of them when 0
+	keep int
+
+	// latest is the name of the latest dump, found by prepare
+	latest string
+}
+
+// objectKey joins the elements of a key, ignoring empty ones and leading or trailing slashes
+func objectKey(elements ...string) string {
+	return strings.Trim(path.Join(elements...), "/")
+}
+
+// prefix returns the prefix of the keys of all dumps
+func (s *objectStorage) prefix() string {
+	if s.root == "" {
+		return ""
+	}
+	return s.root + "/"
+}
+
+// dumps returns the names of the complete dumps, oldest first
+func (s *objectStorage) dumps(ctx context.Context) ([]string, error) {
+	keys, err := s.objects.list(ctx, s.prefix())
+	if err != nil {
+		return nil, storageError{fmt.Errorf("failed to list dumps: %w", err)}
+	}
+
+	var names []string
+	for _, key := range keys {
+		name, file, ok := strings.Cut(strings.TrimPrefix(key, s.prefix()), "/")
+		if ok && file == checksumsFile && isDumpName(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// checkout downloads the dump named ref, or the latest dump, to a temporary directory
+func (s *objectStorage) checkout(ctx context.Context, ref string) (string, func(), error) {
+	logger := log.FromContext(ctx)
+
+	names, err := s.dumps(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	name := ref
+	if name == "" && len(names) > 0 {
+		name = names[len(names)-1]
+	} else if name != "" && !slices.Contains(names, name) {
+		return "", nil, fmt.Errorf("no dump named %s in the storage", ref)
+	}
+
+	dumpsDir, release, err := tempDumpsDir(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if name == "" {
+		return dumpsDir, release, nil
+	}
+
+	logger.Info("Downloading dump", "location", s.objects.location(objectKey(s.root, name)))
+	prefix := objectKey(s.root, name) + "/"
+	keys, err := s.objects.list(ctx, prefix)
+	if err != nil {
+		release()
+		return "", nil, storageError{fmt.Errorf("failed to list dump %s: %w", name, err)}
+	}
+	for _, key := range keys {
+		// Keys come from the storage, they must not lead out of the dumps directory
+		relative := filepath.FromSlash(strings.TrimPrefix(key, prefix))
+		if !filepath.IsLocal(relative) {
+			release()
+			return "", nil, fmt.Errorf("dump %s has an invalid file %q", name, key)
+		}
+		file := filepath.Join(dumpsDir, relative)
+		if err := s.objects.download(ctx, key, file); err != nil {
+			release()
+			return "", nil, storageError{fmt.Errorf("failed to download %s: %w", key, err)}
+		}
+	}
+	return dumpsDir, release, nil
+}
+
+// prepare downloads the checksums of the latest dump to a temporary directory
+func (s *objectStorage) prepare(ctx context.Context) (string, func(), error) {
+	names, err := s.dumps(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	dumpsDir, release, err := tempDumpsDir(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(names) == 0 {
+		return dumpsDir, release, nil
+	}
+
+	s.latest = names[len(names)-1]
+	key := objectKey(s.root, s.latest, checksumsFile)
+	if err := s.objects.download(ctx, key, filepath.Join(dumpsDir, checksumsFile)); err != nil {
+		release()
+		return "", nil, storageError{fmt.Errorf("failed to download %s: %w", key, err)}
+	}
+	return dumpsDir, release, nil
+}
+
+// store uploads the dump under a name of its own, its checksums last, then deletes the dumps beyond keep
+func (s *objectStorage) store(ctx context.Context, dumpsDir string, dump storedDump) (*dumpResult, error) {
+	logger := log.FromContext(ctx)
+
+	name := dump.Data.Time.UTC().Format(dumpNameLayout)
+	files, err := dumpFiles(dumpsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Uploading dump", "location", s.objects.location(objectKey(s.root, name)), "files", len(files))
+	for _, file := range append(files, checksumsFile) {
+		key := objectKey(s.root, name, filepath.ToSlash(file))
+		if err := s.objects.upload(ctx, filepath.Join(dumpsDir, file), key); err != nil {
+			return nil, storageError{fmt.Errorf("failed to upload %s: %w", key, err)}
+		}
+	}
+	s.latest = name
+
+	if err := s.prune(ctx); err != nil {
+		// The dump is stored, leftovers are deleted after the next one
+		logger.Error(err, "failed to delete old dumps")
+	}
+	return s.describe(ctx, dumpsDir, dump.File)
+}
+
+// prune deletes the dumps older than the newest keep ones, incomplete dumps included. Keys not named like
+// dumps are left alone
+func (s *objectStorage) prune(ctx context.Context) error {
+	if s.keep <= 0 {
+		return nil
+	}
+	names, err := s.dumps(ctx)
+	if err != nil || len(names) <= s.keep {
+		return err
+	}
+	oldest := names[len(names)-s.keep]
+
+	keys, err := s.objects.list(ctx, s.prefix())
+	if err != nil {
+		return fmt.Errorf("failed to list dumps: %w", err)
+	}
+	var expired []string
+	for _, key := range keys {
+		name, _, _ := strings.Cut(strings.TrimPrefix(key, s.prefix()), "/")
+		if isDumpName(name) && name < oldest {
+			expired = append(expired, key)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	if err := s.objects.remove(ctx, expired); err != nil {
+		return fmt.Errorf("failed to delete old dumps: %w", err)
+	}
+	return nil
+}
+
+// describe describes the latest dump, its size is the size of the dump in the dumps directory
+func (s *objectStorage) describe(_ context.Context, dumpsDir, file string) (*dumpResult, error) {
+	size, err := dumpSize(filepath.Join(dumpsDir, file))
+	if err != nil {
+		return nil, err
+	}
+	return &dumpResult{
+		Size:     size,
+		Location: s.objects.location(objectKey(s.root, s.latest, filepath.ToSlash(file))),
+	}, nil
+}
+
+// dumpFiles returns the files of the dumps directory, relative to it, except the checksums
+func dumpFiles(dumpsDir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dumpsDir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relative, err := filepath.Rel(dumpsDir, file)
+		if err != nil {
+			return err
+		}
+		if relative != checksumsFile {
+			files = append(files, relative)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dump files: %w", err)
+	}
+	return files, nil
+}
+
+// tempDumpsDir creates a temporary dumps directory and returns it with a function removing it
+func tempDumpsDir(ctx context.Context) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "dumps-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	return dir, func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to remove dumps directory")
+		}
+	}, nil
+}
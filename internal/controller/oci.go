@@ -0,0 +1,433 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/retry"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// Media types of dumps pushed to an OCI registry
+const (
+	ociDumpArtifactType  = "application/vnd.cevichedbsync.dump.v1"
+	ociDumpFileMediaType = "application/vnd.cevichedbsync.dump.file.v1"
+	ociChecksumMediaType = "application/vnd.cevichedbsync.dump.checksums.v1+json"
+)
+
+// Annotations of the manifests of dumps pushed to an OCI registry
+const (
+	ociDatabaseAnnotation  = "ceviche.jcroyoaun.io/database"
+	ociTimestampAnnotation = "ceviche.jcroyoaun.io/timestamp"
+	ociChecksumAnnotation  = "ceviche.jcroyoaun.io/checksum"
+)
+
+// ociLatestTagPrefix starts the tag of the latest dump of each PostgresSync, restored when no ref is given
+const ociLatestTagPrefix = "latest."
+
+// ociTagInvalidChars matches the characters a tag can't hold
+var ociTagInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// ociStorage pushes each dump as an artifact to a repository of an OCI registry, each file of the dumps
+// directory being a layer titled with its path. Dumps are tagged as the latest of their PostgresSync last,
+// once complete
+type ociStorage struct {
+	target     oras.Target
+	repository string
+	spec       *cevichev1alpha1.OCIStorageSpec
+	// latestTag tags the latest dump of the PostgresSync
+	latestTag string
+
+	// Set by prepare for store
+	tag    *template.Template
+	latest ocispec.Descriptor
+}
+
+// ociStorage returns the storage of the repository of the PostgresSync, authenticated with its
+// dockerconfigjson Secret
+func (r *PostgresSyncReconciler) ociStorage(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) (*ociStorage, error) {
+	spec := pgSync.Spec.Storage.OCI
+
+	var config []byte
+	if spec.Credentials != nil {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: spec.Credentials.SecretName, Namespace: pgSync.Namespace}, secret); err != nil {
+			return nil, fmt.Errorf("failed to get registry credentials: %w", err)
+		}
+		if config = secret.Data[corev1.DockerConfigJsonKey]; len(config) == 0 {
+			return nil, fmt.Errorf("registry credentials secret %s needs the %q key", spec.Credentials.SecretName, corev1.DockerConfigJsonKey)
+		}
+	}
+
+	repo, err := newOCIRepository(spec, config)
+	if err != nil {
+		return nil, err
+	}
+	return &ociStorage{target: repo, repository: spec.Repository, spec: spec, latestTag: ociLatestTag(pgSync)}, nil
+}
+
+// ociLatestTag returns the tag of the latest dump of the PostgresSync, latest.<namespace>.<name>, so
+// PostgresSyncs sharing a repository never restore or compare against each other's dumps
+func ociLatestTag(pgSync *cevichev1alpha1.PostgresSync) string {
+	tag := ociLatestTagPrefix + pgSync.Namespace + "." + pgSync.Name
+	if len(tag) > 128 {
+		sum := sha256.Sum256([]byte(pgSync.Namespace + "/" + pgSync.Name))
+		tag = ociLatestTagPrefix + hex.EncodeToString(sum[:16])
+	}
+	return tag
+}
+
+// newOCIRepository returns the repository described by spec, authenticated with the credentials of its
+// registry in the Docker config, anonymously when empty
+func newOCIRepository(spec *cevichev1alpha1.OCIStorageSpec, config []byte) (*remote.Repository, error) {
+	repo, err := remote.NewRepository(spec.Repository)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository %s: %w", spec.Repository, err)
+	}
+	if repo.Reference.Reference != "" {
+		return nil, fmt.Errorf("repository %s must not have a tag or digest", spec.Repository)
+	}
+	repo.PlainHTTP = spec.PlainHTTP
+
+	client := &auth.Client{Client: retry.DefaultClient, Cache: auth.NewCache()}
+	if len(config) > 0 {
+		cred, err := dockerConfigCredential(config, repo.Reference.Registry)
+		if err != nil {
+			return nil, err
+		}
+		client.Credential = auth.StaticCredential(repo.Reference.Registry, cred)
+	}
+	repo.Client = client
+	return repo, nil
+}
+
+// dockerConfigCredential returns the credentials of registry in a Docker config, as kept in dockerconfigjson
+// Secrets
+func dockerConfigCredential(config []byte, registry string) (auth.Credential, error) {
+	var parsed struct {
+		Auths map[string]struct {
+			Username      string `json:"username"`
+			Password      string `json:"password"`
+			Auth          string `json:"auth"`
+			IdentityToken string `json:"identitytoken"`
+			RegistryToken string `json:"registrytoken"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(config, &parsed); err != nil {
+		return auth.EmptyCredential, fmt.Errorf("invalid Docker config: %w", err)
+	}
+
+	for server, entry := range parsed.Auths {
+		// Servers may be written as URLs, such as https://index.docker.io/v1/
+		host := server
+		if _, rest, ok := strings.Cut(host, "://"); ok {
+			host = rest
+		}
+		host, _, _ = strings.Cut(host, "/")
+		if host != registry {
+			continue
+		}
+
+		cred := auth.Credential{
+			Username:     entry.Username,
+			Password:     entry.Password,
+			RefreshToken: entry.IdentityToken,
+			AccessToken:  entry.RegistryToken,
+		}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return auth.EmptyCredential, fmt.Errorf("invalid auth of %s in Docker config: %w", server, err)
+			}
+			username, password, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return auth.EmptyCredential, fmt.Errorf("invalid auth of %s in Docker config, expected username:password", server)
+			}
+			cred.Username, cred.Password = username, password
+		}
+		return cred, nil
+	}
+	return auth.EmptyCredential, fmt.Errorf("no credentials for registry %s in Docker config", registry)
+}
+
+// resolve returns the manifest of the dump tagged ref or with digest ref, found false when missing
+func (s *ociStorage) resolve(ctx context.Context, ref string) (ocispec.Descriptor, *ocispec.Manifest, bool, error) {
+	desc, err := s.target.Resolve(ctx, ref)
+	if errors.Is(err, errdef.ErrNotFound) {
+		return ocispec.Descriptor{}, nil, false, nil
+	}
+	if err != nil {
+		return ocispec.Descriptor{}, nil, false, storageError{fmt.Errorf("failed to resolve %s: %w", s.reference(ref), err)}
+	}
+
+	data, err := content.FetchAll(ctx, s.target, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, false, storageError{fmt.Errorf("failed to fetch %s: %w", s.reference(ref), err)}
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return ocispec.Descriptor{}, nil, false, fmt.Errorf("invalid manifest of %s: %w", s.reference(ref), err)
+	}
+	if manifest.ArtifactType != ociDumpArtifactType {
+		return ocispec.Descriptor{}, nil, false, fmt.Errorf("%s is not a dump but a %q artifact", s.reference(ref), manifest.ArtifactType)
+	}
+	return desc, &manifest, true, nil
+}
+
+// checkout pulls the dump tagged ref or with digest ref, the latest dump when empty, to a temporary directory
+func (s *ociStorage) checkout(ctx context.Context, ref string) (string, func(), error) {
+	reference := ref
+	if reference == "" {
+		reference = s.latestTag
+	}
+	desc, manifest, found, err := s.resolve(ctx, reference)
+	if err != nil {
+		return "", nil, err
+	}
+	if !found && ref != "" {
+		return "", nil, fmt.Errorf("no dump %s in the repository", s.reference(ref))
+	}
+
+	dumpsDir, release, err := tempDumpsDir(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if !found {
+		return dumpsDir, release, nil
+	}
+
+	log.FromContext(ctx).Info("Pulling dump", "location", s.location(desc), "files", len(manifest.Layers))
+	for _, layer := range manifest.Layers {
+		if err := s.pull(ctx, layer, dumpsDir); err != nil {
+			release()
+			return "", nil, err
+		}
+	}
+	return dumpsDir, release, nil
+}
+
+// prepare pulls the checksums of the latest dump to a temporary directory
+func (s *ociStorage) prepare(ctx context.Context) (string, func(), error) {
+	// Parse the tag before dumping, so a broken template doesn't cost a dump
+	text := s.spec.Tag
+	if text == "" {
+		text = `{{ .Time.UTC.Format "` + dumpNameLayout + `" }}`
+	}
+	var err error
+	if s.tag, err = parseTemplate("spec.storage.oci.tag", text); err != nil {
+		return "", nil, err
+	}
+
+	desc, manifest, found, err := s.resolve(ctx, s.latestTag)
+	if err != nil {
+		return "", nil, err
+	}
+	dumpsDir, release, err := tempDumpsDir(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	if !found {
+		return dumpsDir, release, nil
+	}
+
+	s.latest = desc
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == ociChecksumMediaType {
+			if err := s.pull(ctx, layer, dumpsDir); err != nil {
+				release()
+				return "", nil, err
+			}
+		}
+	}
+	return dumpsDir, release, nil
+}
+
+// store pushes the files of the dump as layers of a new artifact, then tags it
+func (s *ociStorage) store(ctx context.Context, dumpsDir string, dump storedDump) (*dumpResult, error) {
+	logger := log.FromContext(ctx)
+
+	var tag strings.Builder
+	if err := s.tag.Execute(&tag, dump.Data); err != nil {
+		logger.Error(err, "failed to render tag")
+		return nil, fmt.Errorf("failed to render spec.storage.oci.tag: %w", err)
+	}
+	name, err := ociTag(tag.String())
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := dumpFiles(dumpsDir)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Pushing dump", "repository", s.repository, "tag", name, "files", len(files))
+	var layers []ocispec.Descriptor
+	for _, file := range files {
+		layer, err := s.push(ctx, dumpsDir, file, ociDumpFileMediaType)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+	}
+	checksums, err := s.push(ctx, dumpsDir, checksumsFile, ociChecksumMediaType)
+	if err != nil {
+		return nil, err
+	}
+	layers = append(layers, checksums)
+
+	timestamp := dump.Data.Time.UTC().Format(time.RFC3339)
+	desc, err := oras.PackManifest(ctx, s.target, oras.PackManifestVersion1_1, ociDumpArtifactType, oras.PackManifestOptions{
+		Layers: layers,
+		ManifestAnnotations: map[string]string{
+			ocispec.AnnotationCreated: timestamp,
+			ociDatabaseAnnotation:     dump.Data.Database,
+			ociTimestampAnnotation:    timestamp,
+			// The checksums cover the schema and the rows of every table, so equal dumps share it
+			ociChecksumAnnotation: checksums.Digest.String(),
+		},
+	})
+	if err != nil {
+		return nil, storageError{fmt.Errorf("failed to push manifest: %w", err)}
+	}
+
+	// Move the latest tag last, once the dump is complete under its own tag
+	for _, reference := range []string{name, s.latestTag} {
+		if err := s.target.Tag(ctx, desc, reference); err != nil {
+			return nil, storageError{fmt.Errorf("failed to tag %s: %w", s.reference(reference), err)}
+		}
+	}
+	s.latest = desc
+	return s.describe(ctx, dumpsDir, dump.File)
+}
+
+// describe describes the latest dump by its digest
+func (s *ociStorage) describe(_ context.Context, dumpsDir, file string) (*dumpResult, error) {
+	size, err := dumpSize(filepath.Join(dumpsDir, file))
+	if err != nil {
+		return nil, err
+	}
+	return &dumpResult{Size: size, Location: s.location(s.latest)}, nil
+}
+
+// push pushes the file of the dumps directory as a layer, unless the registry already has it
+func (s *ociStorage) push(ctx context.Context, dumpsDir, file, mediaType string) (ocispec.Descriptor, error) {
+	path := filepath.Join(dumpsDir, file)
+	f, err := os.Open(path)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to open %s: %w", file, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	// Layers are addressed by their digest, so the file is read once to hash it and once to push it
+	dgst, err := digest.SHA256.FromReader(f)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to hash %s: %w", file, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to stat %s: %w", file, err)
+	}
+	desc := ocispec.Descriptor{
+		MediaType:   mediaType,
+		Digest:      dgst,
+		Size:        info.Size(),
+		Annotations: map[string]string{ocispec.AnnotationTitle: filepath.ToSlash(file)},
+	}
+
+	// Tables that didn't change since the previous dump are already there
+	exists, err := s.target.Exists(ctx, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, storageError{fmt.Errorf("failed to check %s: %w", file, err)}
+	}
+	if exists {
+		return desc, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	if err := s.target.Push(ctx, desc, f); err != nil && !errors.Is(err, errdef.ErrAlreadyExists) {
+		return ocispec.Descriptor{}, storageError{fmt.Errorf("failed to push %s: %w", file, err)}
+	}
+	return desc, nil
+}
+
+// pull writes the layer to the dumps directory, at the path it is titled with
+func (s *ociStorage) pull(ctx context.Context, layer ocispec.Descriptor, dumpsDir string) error {
+	title := layer.Annotations[ocispec.AnnotationTitle]
+	if !filepath.IsLocal(filepath.FromSlash(title)) {
+		return fmt.Errorf("layer %s has an invalid title %q", layer.Digest, title)
+	}
+	path := filepath.Join(dumpsDir, filepath.FromSlash(title))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", title, err)
+	}
+
+	r, err := s.target.Fetch(ctx, layer)
+	if err != nil {
+		return storageError{fmt.Errorf("failed to pull %s: %w", title, err)}
+	}
+	defer r.Close() //nolint:errcheck
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", title, err)
+	}
+	defer f.Close() //nolint:errcheck
+
+	verifier := content.NewVerifyReader(r, layer)
+	if _, err := io.Copy(f, verifier); err != nil {
+		return storageError{fmt.Errorf("failed to pull %s: %w", title, err)}
+	}
+	if err := verifier.Verify(); err != nil {
+		return fmt.Errorf("failed to verify %s: %w", title, err)
+	}
+	return f.Close()
+}
+
+// reference returns the full reference of a tag or digest of the repository
+func (s *ociStorage) reference(ref string) string {
+	if _, err := digest.Parse(ref); err == nil {
+		return s.repository + "@" + ref
+	}
+	return s.repository + ":" + ref
+}
+
+// location returns the URL of the artifact
+func (s *ociStorage) location(desc ocispec.Descriptor) string {
+	return "oci://" + s.reference(desc.Digest.String())
+}
+
+// ociTag turns a rendered tag into a valid one
+func ociTag(tag string) (string, error) {
+	tag = strings.TrimLeft(ociTagInvalidChars.ReplaceAllString(strings.TrimSpace(tag), "-"), ".-")
+	if len(tag) > 128 {
+		tag = tag[:128]
+	}
+	if tag == "" {
+		return "", fmt.Errorf("spec.storage.oci.tag rendered an empty tag")
+	}
+	return tag, nil
+}
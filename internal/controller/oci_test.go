@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/oci"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("OCI dump storage", func() {
+	ctx := context.Background()
+
+	// storeDump stores a dump taken at the given time through a fresh storage, as each operation does
+	storeDump := func(target oras.Target, spec *migrationsv1alpha1.OCIStorageSpec, taken time.Time, data string) *dumpResult {
+		storage := &ociStorage{target: target, repository: spec.Repository, spec: spec, latestTag: "latest.default.orders"}
+		dumpsDir, release, err := storage.prepare(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		defer release()
+
+		Expect(os.MkdirAll(filepath.Join(dumpsDir, "dump"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dumpsDir, "dump", "toc.dat"), []byte(data), 0644)).To(Succeed())
+		Expect(writeChecksums(dumpsDir, &dumpChecksums{Files: []string{"dump"}, Schema: data})).To(Succeed())
+		result, err := storage.store(ctx, dumpsDir, storedDump{File: "dump", Data: commitMessageData{Database: "orders", Time: taken}})
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	checkout := func(storage *ociStorage, ref string) (string, error) {
+		dumpsDir, release, err := storage.checkout(ctx, ref)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+		data, err := os.ReadFile(filepath.Join(dumpsDir, "dump", "toc.dat"))
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return string(data), err
+	}
+
+	// testRestores stores two dumps to target and restores them by tag, digest or as the latest
+	testRestores := func(target oras.Target, spec *migrationsv1alpha1.OCIStorageSpec) {
+		storage := &ociStorage{target: target, repository: spec.Repository, spec: spec, latestTag: "latest.default.orders"}
+		Expect(checkout(storage, "")).To(BeEmpty())
+
+		first := time.Date(2026, 10, 1, 3, 0, 0, 0, time.UTC)
+		result := storeDump(target, spec, first, "first")
+		Expect(result.Location).To(HavePrefix("oci://" + spec.Repository + "@sha256:"))
+		Expect(result.Size).To(BeEquivalentTo(len("first")))
+		storeDump(target, spec, first.Add(24*time.Hour), "second")
+
+		Expect(checkout(storage, "")).To(Equal("second"))
+		Expect(checkout(storage, "20261001T030000Z")).To(Equal("first"))
+		Expect(checkout(storage, result.Location[len("oci://"+spec.Repository+"@"):])).To(Equal("first"))
+		_, err := checkout(storage, "20260101T000000Z")
+		Expect(err).To(MatchError(ContainSubstring("no dump " + spec.Repository + ":20260101T000000Z")))
+	}
+
+	It("should push dumps as annotated artifacts and restore them by tag or digest", func() {
+		target, err := oci.New(GinkgoT().TempDir())
+		Expect(err).NotTo(HaveOccurred())
+		spec := &migrationsv1alpha1.OCIStorageSpec{Repository: "registry.example.com/dumps/orders"}
+		testRestores(target, spec)
+
+		desc, err := target.Resolve(ctx, "latest.default.orders")
+		Expect(err).NotTo(HaveOccurred())
+		data, err := content.FetchAll(ctx, target, desc)
+		Expect(err).NotTo(HaveOccurred())
+		var manifest ocispec.Manifest
+		Expect(json.Unmarshal(data, &manifest)).To(Succeed())
+		Expect(manifest.ArtifactType).To(Equal(ociDumpArtifactType))
+		Expect(manifest.Annotations).To(HaveKeyWithValue(ociDatabaseAnnotation, "orders"))
+		Expect(manifest.Annotations).To(HaveKeyWithValue(ociTimestampAnnotation, "2026-10-02T03:00:00Z"))
+		Expect(manifest.Layers).To(HaveLen(2))
+		Expect(manifest.Layers[0].MediaType).To(Equal(ociDumpFileMediaType))
+		Expect(manifest.Layers[0].Annotations).To(HaveKeyWithValue(ocispec.AnnotationTitle, "dump/toc.dat"))
+		Expect(manifest.Layers[1].MediaType).To(Equal(ociChecksumMediaType))
+		Expect(manifest.Annotations).To(HaveKeyWithValue(ociChecksumAnnotation, manifest.Layers[1].Digest.String()))
+
+		// The previous checksums are all a new dump needs
+		storage := &ociStorage{target: target, repository: spec.Repository, spec: spec, latestTag: "latest.default.orders"}
+		dumpsDir, release, err := storage.prepare(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		defer release()
+		Expect(readChecksums(dumpsDir).Schema).To(Equal("second"))
+		Expect(filepath.Join(dumpsDir, "dump")).NotTo(BeADirectory())
+		described, err := storage.describe(ctx, dumpsDir, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(described.Location).To(Equal("oci://registry.example.com/dumps/orders@" + desc.Digest.String()))
+	})
+
+	It("should tag dumps after the tag template", func() {
+		target, err := oci.New(GinkgoT().TempDir())
+		Expect(err).NotTo(HaveOccurred())
+		spec := &migrationsv1alpha1.OCIStorageSpec{
+			Repository: "registry.example.com/dumps",
+			Tag:        `{{ .Database }}/{{ .Time.Format "2006-01-02" }}`,
+		}
+		storeDump(target, spec, time.Date(2026, 10, 1, 3, 0, 0, 0, time.UTC), "first")
+		_, err = target.Resolve(ctx, "orders-2026-10-01")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ociTag(" -.v1+")).To(Equal("v1-"))
+		_, err = ociTag("--")
+		Expect(err).To(MatchError(ContainSubstring("empty tag")))
+	})
+
+	It("should take the credentials of the registry from a Docker config", func() {
+		config := []byte(`{"auths": {
+			"https://index.docker.io/v1/": {"username": "hub", "password": "hub-secret"},
+			"registry.example.com:5000": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("ci:s3cr3t:x")) + `"}
+		}}`)
+		cred, err := dockerConfigCredential(config, "registry.example.com:5000")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cred.Username).To(Equal("ci"))
+		Expect(cred.Password).To(Equal("s3cr3t:x"))
+		cred, err = dockerConfigCredential(config, "index.docker.io")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cred.Password).To(Equal("hub-secret"))
+		_, err = dockerConfigCredential(config, "ghcr.io")
+		Expect(err).To(MatchError(ContainSubstring("no credentials for registry ghcr.io")))
+
+		_, err = newOCIRepository(&migrationsv1alpha1.OCIStorageSpec{Repository: "registry.example.com/dumps:latest"}, nil)
+		Expect(err).To(MatchError(ContainSubstring("must not have a tag or digest")))
+
+		storage, err := (&PostgresSyncReconciler{}).dumpStorage(ctx, &migrationsv1alpha1.PostgresSync{Spec: migrationsv1alpha1.PostgresSyncSpec{
+			Storage: &migrationsv1alpha1.StorageSpec{OCI: &migrationsv1alpha1.OCIStorageSpec{Repository: "localhost:5000/dumps", PlainHTTP: true}},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(storage).To(BeAssignableToTypeOf(&ociStorage{}))
+
+		// Each PostgresSync sharing a repository has its own latest dump
+		pgSync := &migrationsv1alpha1.PostgresSync{ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "orders"}}
+		Expect(ociLatestTag(pgSync)).To(Equal("latest.prod.orders"))
+		pgSync.Name = strings.Repeat("a", 200)
+		Expect(ociLatestTag(pgSync)).To(MatchRegexp(`^latest\.[0-9a-f]{32}$`))
+	})
+
+	// The registry store is tested against a local registry when OCI_TEST_REGISTRY is set, such as one started
+	// with docker run -p 5000:5000 registry:2 and reached as localhost:5000
+	It("should push and pull dumps to a registry", func() {
+		registry := os.Getenv("OCI_TEST_REGISTRY")
+		if registry == "" {
+			Skip("OCI_TEST_REGISTRY is not set")
+		}
+		spec := &migrationsv1alpha1.OCIStorageSpec{
+			Repository: registry + "/ceviche-test-" + time.Now().Format("20060102150405"),
+			PlainHTTP:  true,
+		}
+		repo, err := newOCIRepository(spec, nil)
+		Expect(err).NotTo(HaveOccurred())
+		testRestores(repo, spec)
+	})
+})
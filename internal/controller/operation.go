@@ -0,0 +1,66 @@
+package controller
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// maxOperations is how many requested operations are kept in the PostgresSync status
+const maxOperations = 10
+
+// startOperation records a requested operation as in progress. Operations without an ID weren't
+// requested through the webhook and aren't tracked
+func startOperation(pgSync *cevichev1alpha1.PostgresSync, id, operation, job string) {
+	if id == "" {
+		return
+	}
+
+	now := metav1.Now()
+	operations := []cevichev1alpha1.OperationStatus{{
+		ID:        id,
+		Type:      operation,
+		Phase:     PhaseInProgress,
+		Job:       job,
+		StartTime: &now,
+	}}
+	for _, existing := range pgSync.Status.Operations {
+		if existing.ID != id && len(operations) < maxOperations {
+			operations = append(operations, existing)
+		}
+	}
+	pgSync.Status.Operations = operations
+}
+
+// finishOperation records the outcome of the requested operation with the given ID
+func finishOperation(pgSync *cevichev1alpha1.PostgresSync, id string, dump string, err error, message string) {
+	for i := range pgSync.Status.Operations {
+		operation := &pgSync.Status.Operations[i]
+		if id == "" || operation.ID != id {
+			continue
+		}
+
+		now := metav1.Now()
+		operation.CompletionTime = &now
+		operation.Phase = PhaseSucceeded
+		operation.Message = message
+		if err != nil {
+			operation.Phase = PhaseFailed
+			operation.Message = err.Error()
+		}
+		if dump != "" {
+			operation.Dump = dump
+		}
+		return
+	}
+}
+
+// jobOperationID returns the ID of the requested operation running in the given Job, if any
+func jobOperationID(pgSync *cevichev1alpha1.PostgresSync, job string) string {
+	for _, operation := range pgSync.Status.Operations {
+		if operation.Job == job {
+			return operation.ID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Requested operations", func() {
+	It("should name operations started in the same second apart", func() {
+		pgSync := &migrationsv1alpha1.PostgresSync{}
+		pgSync.Name = strings.Repeat("a", 70)
+		now := time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)
+		first, second := operationName(pgSync, OperationDump, now), operationName(pgSync, OperationDump, now)
+		Expect(first).NotTo(Equal(second))
+		Expect(first).To(MatchRegexp(`^a+-dump-%d-[0-9a-f]{6}$`, now.Unix()))
+		Expect(len(first)).To(Equal(63))
+	})
+
+	It("should track operations by ID and Job", func() {
+		pgSync := &migrationsv1alpha1.PostgresSync{}
+		startOperation(pgSync, "", OperationDump, "")
+		Expect(pgSync.Status.Operations).To(BeEmpty())
+
+		startOperation(pgSync, "abc", OperationDump, "app-dump-1")
+		Expect(jobOperationID(pgSync, "app-dump-1")).To(Equal("abc"))
+		Expect(pgSync.Status.Operations[0].Phase).To(Equal(PhaseInProgress))
+
+		finishOperation(pgSync, "abc", "app-dump-1", errors.New("pg_dump failed"), "")
+		Expect(pgSync.Status.Operations[0].Phase).To(Equal(PhaseFailed))
+		Expect(pgSync.Status.Operations[0].Message).To(Equal("pg_dump failed"))
+		Expect(pgSync.Status.Operations[0].Dump).To(Equal("app-dump-1"))
+		Expect(pgSync.Status.Operations[0].CompletionTime).NotTo(BeNil())
+	})
+
+	It("should only keep the most recent operations", func() {
+		pgSync := &migrationsv1alpha1.PostgresSync{}
+		for i := range maxOperations + 5 {
+			startOperation(pgSync, fmt.Sprintf("op-%d", i), OperationDump, "")
+		}
+		Expect(pgSync.Status.Operations).To(HaveLen(maxOperations))
+		Expect(pgSync.Status.Operations[0].ID).To(Equal(fmt.Sprintf("op-%d", maxOperations+4)))
+	})
+})
@@ -6,13 +6,16 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	appsv1 "k8s.io/api/apps/v1"
-	corev1 "k8s.io/api/core/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -29,6 +32,31 @@ import (
 type PostgresSyncReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// APIReader reads objects that aren't cached by the manager, such as Job pods
+	// If nil, the Client is used
+	APIReader client.Reader
+
+	// JobImage is the default image of the Jobs running dumps and restores in Job execution mode
+	JobImage string
+
+	// GitCacheDir keeps a clone of each repository between operations, fetched incrementally
+	// If empty, every operation clones the repository to a temporary directory
+	GitCacheDir string
+
+	// AmbientS3Credentials lets S3 storages without a credentials Secret use the environment and IAM role of
+	// the operator or Job pod. Off by default, as any PostgresSync could then reach every bucket of that role
+	AmbientS3Credentials bool
+
+	// VolumeDir is where the PersistentVolumeClaims of PVC storages are mounted, each under its claim name
+	// If empty, PVC storages are only reachable from Jobs
+	VolumeDir string
+
+	// repositoryLocks holds a *sync.Mutex per cached clone
+	repositoryLocks sync.Map
+
+	// branchLocks holds a chan struct{} of capacity 1 per repository and branch dumps are pushed to
+	branchLocks sync.Map
 }
 
 // Constants for phases
@@ -37,6 +65,7 @@ const (
 	PhaseInProgress = "InProgress"
 	PhaseSucceeded  = "Succeeded"
 	PhaseFailed     = "Failed"
+	PhaseUnchanged  = "Unchanged"
 )
 
 // Reconcile handles PostgresSync resources
@@ -64,9 +93,8 @@ func (r *PostgresSyncReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		if errors.IsNotFound(err) {
 			// StatefulSet doesn't exist yet, requeue
 			logger.Info("StatefulSet not found, requeueing", "statefulset", statefulSetKey)
-			pgSync.Status.Phase = PhasePending
-			pgSync.Status.Message = "Waiting for StatefulSet to be created"
-			if err := r.Status().Update(ctx, &pgSync); err != nil {
+			markPending(&pgSync, cevichev1alpha1.ReasonStatefulSetNotFound, "Waiting for StatefulSet to be created")
+			if err := r.updateStatus(ctx, &pgSync); err != nil {
 				logger.Error(err, "unable to update PostgresSync status")
 				return ctrl.Result{}, err
 			}
@@ -79,71 +107,140 @@ func (r *PostgresSyncReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	// Check if StatefulSet is ready
 	if statefulSet.Status.ReadyReplicas == 0 {
 		logger.Info("StatefulSet not ready, requeueing", "statefulset", statefulSetKey)
-		pgSync.Status.Phase = PhasePending
-		pgSync.Status.Message = "Waiting for StatefulSet to be ready"
-		if err := r.Status().Update(ctx, &pgSync); err != nil {
+		markPending(&pgSync, cevichev1alpha1.ReasonStatefulSetNotReady, "Waiting for StatefulSet to be ready")
+		if err := r.updateStatus(ctx, &pgSync); err != nil {
 			logger.Error(err, "unable to update PostgresSync status")
 			return ctrl.Result{}, err
 		}
 		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
 	}
 
+	// Track the Job running a dump or restore, nothing else starts until it is done
+	if pgSync.Status.ActiveJob != "" {
+		running, result, err := r.reconcileActiveJob(ctx, &pgSync)
+		if running || err != nil {
+			return result, err
+		}
+	}
+
+	// Restore again when requested through the webhook or the spec
+	if pgSync.Spec.RestoreRequest != nil {
+		return r.reconcileRestoreRequest(ctx, &pgSync)
+	}
+
 	// First time setup - check for existing dump
-	if pgSync.Status.Phase != PhaseSucceeded {
-		// Try to find and restore dump.sql if it exists
-		restored, err := r.findAndRestoreDump(ctx, &pgSync)
+	if needsInitialRestore(&pgSync) && runsInJob(&pgSync) {
+		retryAfter, err := r.jobRetryAfter(ctx, &pgSync, OperationRestore)
+		if err != nil {
+			logger.Error(err, "unable to check previous restore Jobs")
+			return ctrl.Result{}, err
+		}
+		if retryAfter > 0 {
+			logger.Info("Previous restore Job failed, waiting before retrying", "retryAfter", retryAfter)
+			return ctrl.Result{RequeueAfter: retryAfter}, nil
+		}
+
+		logger.Info("Restoring dump in a Job")
+		if err := r.startJob(ctx, &pgSync, OperationRestore, "", ""); err != nil {
+			logger.Error(err, "Failed to start restore Job")
+			markRestoreFailed(&pgSync, err)
+			if updateErr := r.updateStatus(ctx, &pgSync); updateErr != nil {
+				logger.Error(updateErr, "Failed to update status")
+			}
+			return ctrl.Result{}, err
+		}
+		if err := r.updateStatus(ctx, &pgSync); err != nil {
+			logger.Error(err, "unable to update PostgresSync status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	} else if needsInitialRestore(&pgSync) {
+		// Try to find and restore a dump if it exists
+		restored, err := r.findAndRestoreDump(ctx, &pgSync, "")
 		if err != nil {
 			logger.Error(err, "Failed to restore dump")
-			pgSync.Status.Phase = PhaseFailed
-			pgSync.Status.Message = fmt.Sprintf("Failed to restore dump: %v", err)
-			if updateErr := r.Status().Update(ctx, &pgSync); updateErr != nil {
+			markRestoreFailed(&pgSync, err)
+			if updateErr := r.updateStatus(ctx, &pgSync); updateErr != nil {
 				logger.Error(updateErr, "Failed to update status")
 			}
 			return ctrl.Result{}, err
 		}
 
 		// Update status based on restore result
-		if restored {
-			pgSync.Status.Phase = PhaseSucceeded
-			pgSync.Status.Message = "Database initialized from dump.sql"
-		} else {
-			pgSync.Status.Phase = PhaseSucceeded
-			pgSync.Status.Message = "Ready - no existing dump found"
-		}
-
-		if err := r.Status().Update(ctx, &pgSync); err != nil {
+		markRestored(&pgSync, restored)
+		if err := r.updateStatus(ctx, &pgSync); err != nil {
 			logger.Error(err, "unable to update PostgresSync status")
 			return ctrl.Result{}, err
 		}
 	}
 
-	// Handle dump on webhook if enabled
-	if pgSync.Spec.DumpOnWebhook {
-		logger.Info("DumpOnWebhook is true, creating database dump")
+	// Handle dump on webhook in a Job if enabled
+	if pgSync.Spec.DumpOnWebhook && runsInJob(&pgSync) {
+		logger.Info("DumpOnWebhook is true, creating database dump in a Job")
 
-		// Create the dump
-		if err := r.createDatabaseDump(ctx, &pgSync); err != nil {
-			logger.Error(err, "failed to create database dump")
-			pgSync.Status.Phase = PhaseFailed
-			pgSync.Status.Message = fmt.Sprintf("Failed to dump database: %v", err)
-			if updateErr := r.Status().Update(ctx, &pgSync); updateErr != nil {
+		// Reset the DumpOnWebhook flag first so the dump isn't started twice
+		requestID := pgSync.Spec.DumpRequestID
+		pgSync.Spec.DumpOnWebhook = false
+		pgSync.Spec.DumpRequestID = ""
+		if err := r.Update(ctx, &pgSync); err != nil {
+			logger.Error(err, "failed to update PostgresSync before dump")
+			return ctrl.Result{}, err
+		}
+
+		if err := r.startDumpJob(ctx, &pgSync, cevichev1alpha1.DumpTriggerWebhook); err != nil {
+			logger.Error(err, "failed to start dump Job")
+			markDumpFailed(&pgSync, err)
+			startOperation(&pgSync, requestID, OperationDump, "")
+			finishOperation(&pgSync, requestID, "", err, "")
+			if updateErr := r.updateStatus(ctx, &pgSync); updateErr != nil {
 				logger.Error(updateErr, "failed to update PostgresSync status")
 			}
 			return ctrl.Result{}, err
 		}
+		startOperation(&pgSync, requestID, OperationDump, pgSync.Status.ActiveJob)
+		if err := r.updateStatus(ctx, &pgSync); err != nil {
+			logger.Error(err, "unable to update PostgresSync status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	} else if pgSync.Spec.DumpOnWebhook {
+		logger.Info("DumpOnWebhook is true, creating database dump")
 
-		// Reset the DumpOnWebhook flag
+		// Reset the DumpOnWebhook flag first, a failed dump is reported by its operation instead of retried.
+		// The update returns the stored status so keep the one recorded so far
+		requestID := pgSync.Spec.DumpRequestID
+		status := pgSync.Status
 		pgSync.Spec.DumpOnWebhook = false
+		pgSync.Spec.DumpRequestID = ""
 		if err := r.Update(ctx, &pgSync); err != nil {
-			logger.Error(err, "failed to update PostgresSync after dump")
+			logger.Error(err, "failed to update PostgresSync before dump")
+			return ctrl.Result{}, err
+		}
+		pgSync.Status = status
+
+		// Report the operation as in progress while the dump runs
+		startOperation(&pgSync, requestID, OperationDump, "")
+		if err := r.updateStatus(ctx, &pgSync); err != nil {
+			logger.Error(err, "unable to update PostgresSync status")
+			return ctrl.Result{}, err
+		}
+
+		// Create the dump
+		dumpName, result, err := r.runDump(ctx, &pgSync, cevichev1alpha1.DumpTriggerWebhook)
+		if err != nil {
+			logger.Error(err, "failed to create database dump")
+			markDumpFailed(&pgSync, err)
+			finishOperation(&pgSync, requestID, dumpName, err, "")
+			if updateErr := r.updateStatus(ctx, &pgSync); updateErr != nil {
+				logger.Error(updateErr, "failed to update PostgresSync status")
+			}
 			return ctrl.Result{}, err
 		}
 
 		// Update status
-		pgSync.Status.Phase = PhaseSucceeded
-		pgSync.Status.Message = "Database dump created successfully"
-		pgSync.Status.LastSyncTime = metav1.Now()
-		if err := r.Status().Update(ctx, &pgSync); err != nil {
+		markDumpSucceeded(&pgSync, result, "Database dump created successfully", metav1.Now())
+		finishOperation(&pgSync, requestID, dumpName, nil, pgSync.Status.Message)
+		if err := r.updateStatus(ctx, &pgSync); err != nil {
 			logger.Error(err, "unable to update PostgresSync status")
 			return ctrl.Result{}, err
 		}
@@ -151,265 +248,335 @@ func (r *PostgresSyncReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		logger.Info("Database dump completed successfully")
 	}
 
+	// Handle scheduled dumps if a schedule is configured
+	if pgSync.Spec.Schedule != nil {
+		return r.reconcileSchedule(ctx, &pgSync)
+	}
+
+	// Nothing else to do, make sure the status reflects the current generation
+	if pgSync.Status.ObservedGeneration != pgSync.Generation {
+		if err := r.updateStatus(ctx, &pgSync); err != nil {
+			logger.Error(err, "unable to update PostgresSync status")
+			return ctrl.Result{}, err
+		}
+	}
+
 	return ctrl.Result{}, nil
 }
 
-// findAndRestoreDump looks for dump.sql in the git repository and restores it if found
-func (r *PostgresSyncReconciler) findAndRestoreDump(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) (bool, error) {
+// findAndRestoreDump looks for a dump in the git repository, or the storage, at ref and restores it if found
+// If ref is empty, the ref of Spec.Restore is used
+func (r *PostgresSyncReconciler) findAndRestoreDump(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, ref string) (bool, error) {
 	logger := log.FromContext(ctx)
-	logger.Info("Looking for existing dump.sql", "namespace", pgSync.Namespace, "name", pgSync.Name)
-
-	// Get Git credentials
-	gitSecret := &corev1.Secret{}
-	gitSecretKey := types.NamespacedName{
-		Name:      pgSync.Spec.GitCredentials.SecretName,
-		Namespace: pgSync.Namespace,
-	}
-	if err := r.Get(ctx, gitSecretKey, gitSecret); err != nil {
-		logger.Error(err, "unable to fetch Git credentials")
-		return false, fmt.Errorf("failed to get Git credentials: %w", err)
-	}
-	gitUsername := string(gitSecret.Data["username"])
-	gitPassword := string(gitSecret.Data["password"])
+	logger.Info("Looking for existing dump", "namespace", pgSync.Namespace, "name", pgSync.Name)
 
-	// Clone repository
-	repoDir, err := r.cloneRepository(pgSync.Spec.RepositoryURL, gitUsername, gitPassword)
+	// Get the storage dumps are kept in
+	storage, err := r.dumpStorage(ctx, pgSync)
 	if err != nil {
-		logger.Error(err, "failed to clone Git repository")
-		return false, fmt.Errorf("failed to clone Git repository: %w", err)
+		logger.Error(err, "unable to load dump storage")
+		return false, err
 	}
 
-	defer func() {
-		if err := os.RemoveAll(repoDir); err != nil {
-			logger.Error(err, "Failed to remove repo directory")
-		}
-	}()
-
-	// Determine dump directory path based on GitOutputPath
-	dumpDir := "dumps" // Default path
-	if pgSync.Spec.DatabaseDumpPath != "" {
-		dumpDir = pgSync.Spec.DatabaseDumpPath
+	// Restore from a specific ref if requested
+	if ref == "" && pgSync.Spec.Restore != nil {
+		ref = pgSync.Spec.Restore.Ref
+	}
+	dumpsDir, release, err := storage.checkout(ctx, ref)
+	if err != nil {
+		return false, err
 	}
+	defer release()
 
-	// Create full path to dump directory
-	dumpsDir := filepath.Join(repoDir, dumpDir)
 	if _, err := os.Stat(dumpsDir); os.IsNotExist(err) {
-		logger.Info("No dumps directory found, creating it", "path", dumpsDir)
-		if err := os.MkdirAll(dumpsDir, 0755); err != nil {
-			logger.Error(err, "failed to create dumps directory")
-			return false, fmt.Errorf("failed to create dumps directory: %w", err)
-		}
+		logger.Info("No dumps directory found", "path", dumpsDir)
 		return false, nil // No dumps to restore
 	}
 
-	// Check if dump.sql exists
-	dumpFile := filepath.Join(dumpsDir, "dump.sql")
-	if _, err := os.Stat(dumpFile); os.IsNotExist(err) {
-		logger.Info("No dump.sql found")
+	// Check if a dump exists, in whichever format and layout it was taken
+	dumpFile, format := findDumpArtifact(dumpsDir, dumpFormat(pgSync))
+	perTable := hasTableManifest(dumpsDir) && (dumpFile == "" || dumpLayout(pgSync) == cevichev1alpha1.DumpLayoutPerTable)
+	if dumpFile == "" && !perTable {
+		logger.Info("No dump found")
 		return false, nil // No dumps to restore
 	}
 
-	// Get database credentials
-	dbSecret := &corev1.Secret{}
-	dbSecretKey := types.NamespacedName{
-		Name:      pgSync.Spec.DatabaseCredentials.SecretName,
-		Namespace: pgSync.Namespace,
-	}
-	if err := r.Get(ctx, dbSecretKey, dbSecret); err != nil {
-		return false, fmt.Errorf("failed to get database credentials: %w", err)
-	}
-
-	// Build connection parameters
-	// Now using the service and service namespace from the CRD
-	host := pgSync.Spec.DatabaseService.Name
-	if host == "" {
-		return false, fmt.Errorf("database service name is required")
-	}
-
-	// If service namespace is provided, use it for FQDN
-	if pgSync.Spec.DatabaseService.Namespace != "" {
-		host = fmt.Sprintf("%s.%s.svc.cluster.local", host, pgSync.Spec.DatabaseService.Namespace)
+	// Get database connection parameters
+	conn, err := r.getDatabaseConnection(ctx, pgSync)
+	if err != nil {
+		return false, databaseError{err}
 	}
 
-	port := string(dbSecret.Data["port"])
-	if port == "" {
-		port = "5432" // Default PostgreSQL port
+	// Per-table dumps are reassembled from their manifest
+	if perTable {
+		logger.Info("Restoring per-table dump")
+		if err := restoreTableDump(conn, dumpsDir); err != nil {
+			logger.Error(err, "failed to restore database")
+			return false, err
+		}
+		logger.Info("Database restore completed successfully")
+		return true, nil
 	}
 
-	dbName := string(dbSecret.Data["database"])
-	if dbName == "" {
-		return false, fmt.Errorf("database name is required in secret")
+	// Decrypted and decompressed dumps are written to a private directory, never to the checkout, which may be
+	// kept between operations
+	workDir, err := os.MkdirTemp("", "restore-*")
+	if err != nil {
+		return false, fmt.Errorf("failed to create temp dir: %w", err)
 	}
+	defer os.RemoveAll(workDir) //nolint:errcheck
 
-	dbUser := string(dbSecret.Data["username"])
-	if dbUser == "" {
-		return false, fmt.Errorf("database username is required in secret")
+	// Encrypted dumps are decrypted first, they were encrypted after compression
+	if encryption := detectEncryption(dumpFile); encryption != "" {
+		decrypt, err := r.dumpDecrypter(ctx, pgSync, encryption)
+		if err != nil {
+			logger.Error(err, "unable to load dump decryption key")
+			return false, err
+		}
+		dumpFile, err = decryptDump(dumpFile, workDir, encryption, decrypt)
+		if err != nil {
+			logger.Error(err, "failed to decrypt dump")
+			return false, err
+		}
 	}
 
-	dbPassword := string(dbSecret.Data["password"])
-	if dbPassword == "" {
-		return false, fmt.Errorf("database password is required in secret")
+	// Plain and tar dumps may be compressed, psql and pg_restore need them decompressed
+	if compressedInGo(format) {
+		dumpFile, err = decompressFile(dumpFile, workDir)
+		if err != nil {
+			logger.Error(err, "failed to decompress dump")
+			return false, err
+		}
 	}
 
-	// Execute psql to restore the database
-	restoreCmd := exec.Command("psql",
-		"-h", host,
-		"-p", port,
-		"-U", dbUser,
-		"-d", dbName,
-		"-f", dumpFile,
-	)
-	restoreCmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", dbPassword))
+	// Execute psql or pg_restore to restore the database
+	logger.Info("Restoring dump", "format", format)
+	restoreCmd := restoreCommand(pgSync, conn, dumpFile, format)
+	restoreCmd.Env = conn.env()
 
 	output, err := restoreCmd.CombinedOutput()
 	if err != nil {
 		logger.Error(err, "failed to restore database", "output", string(output))
-		return false, fmt.Errorf("failed to restore database: %w, output: %s", err, output)
+		return false, databaseError{fmt.Errorf("failed to restore database: %w, output: %s", err, output)}
 	}
 
 	logger.Info("Database restore completed successfully")
 	return true, nil
 }
 
-// createDatabaseDump creates a simple dump.sql and commits it to git
-func (r *PostgresSyncReconciler) createDatabaseDump(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) error {
+// createDatabaseDump dumps the database in the configured format and commits it to git, or stores it in the
+// configured storage
+// trigger is what started the dump, empty when unknown
+func (r *PostgresSyncReconciler) createDatabaseDump(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, trigger cevichev1alpha1.DumpTrigger) (*dumpResult, error) {
 	logger := log.FromContext(ctx)
 	logger.Info("Creating database dump", "namespace", pgSync.Namespace, "name", pgSync.Name)
 
-	// Get database connection credentials
-	dbSecret := &corev1.Secret{}
-	dbSecretKey := types.NamespacedName{
-		Name:      pgSync.Spec.DatabaseCredentials.SecretName,
-		Namespace: pgSync.Namespace,
+	// Check the schema and table filters before connecting to anything
+	if err := validateDumpFilters(pgSync); err != nil {
+		logger.Error(err, "invalid dump filters")
+		return nil, fmt.Errorf("invalid dump filters: %w", err)
 	}
-	if err := r.Get(ctx, dbSecretKey, dbSecret); err != nil {
+
+	// Get database connection parameters
+	conn, err := r.getDatabaseConnection(ctx, pgSync)
+	if err != nil {
 		logger.Error(err, "unable to fetch database credentials")
-		return fmt.Errorf("failed to get database credentials: %w", err)
+		return nil, databaseError{err}
 	}
 
-	// Build connection parameters
-	// Now using the service and service namespace from the CRD
-	host := pgSync.Spec.DatabaseService.Name
-	if host == "" {
-		return fmt.Errorf("database service name is required")
+	// Get the storage dumps are kept in
+	storage, err := r.dumpStorage(ctx, pgSync)
+	if err != nil {
+		logger.Error(err, "unable to load dump storage")
+		return nil, err
 	}
 
-	// If service namespace is provided, use it for FQDN
-	if pgSync.Spec.DatabaseService.Namespace != "" {
-		host = fmt.Sprintf("%s.%s.svc.cluster.local", host, pgSync.Spec.DatabaseService.Namespace)
+	// Load the encryption recipients before dumping, so a broken key doesn't cost a dump
+	encrypt, recipients, err := r.dumpEncrypter(ctx, pgSync)
+	if err != nil {
+		logger.Error(err, "unable to load dump encryption recipients")
+		return nil, err
 	}
 
-	port := string(dbSecret.Data["port"])
-	if port == "" {
-		port = "5432" // Default PostgreSQL port
+	// Likewise for the key of their checksums and the masking salt
+	checksumKey, err := r.dumpChecksumKey(ctx, pgSync)
+	if err != nil {
+		logger.Error(err, "unable to load dump checksum key")
+		return nil, err
 	}
 
-	dbName := string(dbSecret.Data["database"])
-	if dbName == "" {
-		return fmt.Errorf("database name is required in secret")
+	mask, err := r.dumpMasker(ctx, pgSync)
+	if err != nil {
+		logger.Error(err, "unable to load dump masking rules")
+		return nil, err
 	}
 
-	dbUser := string(dbSecret.Data["username"])
-	if dbUser == "" {
-		return fmt.Errorf("database username is required in secret")
+	// Fetch the previous dump to replace, the storage loads its own settings first
+	dumpsDir, release, err := storage.prepare(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer release()
 
-	dbPassword := string(dbSecret.Data["password"])
-	if dbPassword == "" {
-		return fmt.Errorf("database password is required in secret")
-	}
+	// Remember what the previous dump contained to tell whether anything changed
+	previous := readChecksums(dumpsDir)
 
-	// Get Git credentials
-	gitSecret := &corev1.Secret{}
-	gitSecretKey := types.NamespacedName{
-		Name:      pgSync.Spec.GitCredentials.SecretName,
-		Namespace: pgSync.Namespace,
+	// Replace the previous dump, whichever format it was taken in
+	if err := removeDumpArtifacts(dumpsDir); err != nil {
+		logger.Error(err, "failed to remove previous dump")
+		return nil, err
 	}
-	if err := r.Get(ctx, gitSecretKey, gitSecret); err != nil {
-		logger.Error(err, "unable to fetch Git credentials")
-		return fmt.Errorf("failed to get Git credentials: %w", err)
+	// Per-table dumps are written straight into the dumps directory
+	var dumpFile string
+	var checksums *dumpChecksums
+	if dumpLayout(pgSync) == cevichev1alpha1.DumpLayoutPerTable {
+		if err := createTableDump(ctx, pgSync, conn, dumpsDir, mask); err != nil {
+			logger.Error(err, "failed to create per-table dump")
+			return nil, err
+		}
+		if mask != nil {
+			if unmatched := mask.unmatchedTables(); len(unmatched) > 0 {
+				logger.Info("Masking rules matched no table in the dump", "tables", unmatched)
+			}
+		}
+		if checksums, err = tableChecksums(dumpsDir); err != nil {
+			logger.Error(err, "failed to checksum dump")
+			return nil, err
+		}
+		checksums.Files = []string{tableManifestFile}
+	} else {
+		dumpFile, checksums, err = createDumpFile(ctx, pgSync, conn, dumpsDir, mask, encrypt)
+		if err != nil {
+			return nil, err
+		}
+		checksums.Files = []string{dumpFile}
 	}
-	gitUsername := string(gitSecret.Data["username"])
-	gitPassword := string(gitSecret.Data["password"])
 
-	// Clone repository
-	repoDir, err := r.cloneRepository(pgSync.Spec.RepositoryURL, gitUsername, gitPassword)
-	if err != nil {
-		logger.Error(err, "failed to clone Git repository")
-		return fmt.Errorf("failed to clone Git repository: %w", err)
+	// Leave the storage alone when the dump holds the same data as the stored one, encrypted and masked alike
+	checksums.Settings = settingsFingerprint(recipients, mask)
+	if checksumKey != nil {
+		checksums.seal(checksumKey)
 	}
-
-	defer func() {
-		if err := os.RemoveAll(repoDir); err != nil {
-			logger.Error(err, "Failed to remove repo directory")
+	changes := checksums.compare(previous)
+	if !changes.Changed {
+		result, err := storage.describe(ctx, dumpsDir, dumpFile)
+		if err != nil {
+			return nil, err
 		}
-	}()
-
-	// Determine dump directory path based on GitOutputPath
-	dumpDir := "dumps" // Default path
-	if pgSync.Spec.DatabaseDumpPath != "" {
-		dumpDir = pgSync.Spec.DatabaseDumpPath
+		result.Unchanged = true
+		logger.Info("Database dump unchanged, skipping commit", "location", result.Location)
+		return result, nil
+	}
+	if err := writeChecksums(dumpsDir, checksums); err != nil {
+		logger.Error(err, "failed to write dump checksums")
+		return nil, err
+	}
+
+	// Commit and push changes, or store them
+	data := commitMessageData{
+		Namespace:     pgSync.Namespace,
+		Name:          pgSync.Name,
+		Database:      conn.name,
+		Trigger:       string(trigger),
+		Format:        string(dumpFormat(pgSync)),
+		Time:          time.Now().UTC(),
+		SchemaChanged: changes.Schema,
+		ChangedTables: changes.Tables,
+		RowCounts:     checksums.Rows,
+	}
+	result, err := storage.store(ctx, dumpsDir, storedDump{File: dumpFile, Data: data, Changes: changes})
+	if err != nil {
+		return nil, err
 	}
+	result.ChangedTables = changes.listedTables()
 
-	// Create full path to dump directory
-	dumpsDir := filepath.Join(repoDir, dumpDir)
-	if err := os.MkdirAll(dumpsDir, 0755); err != nil {
-		logger.Error(err, "failed to create dumps directory")
-		return fmt.Errorf("failed to create dumps directory: %w", err)
-	}
+	logger.Info("Successfully completed database dump", "location", result.Location, "changedTables", len(changes.Tables))
+	return result, nil
+}
+
+// createDumpFile dumps the database into a single file, or directory for the directory format, then
+// masks, compresses and encrypts it. It returns the name of the dump in dumpsDir and the checksums of its content
+func createDumpFile(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, conn *databaseConnection, dumpsDir string, mask *masker, encrypt encrypter) (string, *dumpChecksums, error) {
+	logger := log.FromContext(ctx)
 
-	// Create dump file named dump.sql
-	dumpFilePath := filepath.Join(dumpsDir, "dump.sql")
+	dumpFile := dumpArtifact(dumpFormat(pgSync))
+	dumpFilePath := filepath.Join(dumpsDir, dumpFile)
 
 	// Setup pg_dump command - now using the provided database user
-	dumpCmd := exec.Command("pg_dump",
-		"-h", host,
-		"-p", port,
-		"-U", dbUser,
-		"-d", dbName,
+	args := append(conn.args(),
 		"--clean",
 		"--if-exists",
 		"--no-owner",
 		"--no-privileges",
 		"-f", dumpFilePath,
 	)
-	dumpCmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", dbPassword))
+	dumpCmd := exec.Command("pg_dump", append(args, dumpArgs(pgSync)...)...)
+	dumpCmd.Env = conn.env()
 
 	// Run dump
 	if output, err := dumpCmd.CombinedOutput(); err != nil {
 		logger.Error(err, "failed to create dump", "output", string(output))
-		return fmt.Errorf("pg_dump failed: %w, output: %s", err, output)
+		return "", nil, databaseError{fmt.Errorf("pg_dump failed: %w, output: %s", err, output)}
 	}
 
-	// Commit and push changes
-	commitMsg := "Updated database dump"
-	if err := r.commitAndPushChanges(repoDir, gitUsername, gitPassword, commitMsg); err != nil {
-		logger.Error(err, "failed to commit and push changes")
-		return fmt.Errorf("failed to commit and push changes: %w", err)
+	// Mask sensitive columns before the dump leaves the operator in any form
+	if mask != nil {
+		if err := mask.maskFile(dumpFilePath); err != nil {
+			logger.Error(err, "failed to mask dump")
+			return "", nil, fmt.Errorf("failed to mask dump: %w", err)
+		}
+		if unmatched := mask.unmatchedTables(); len(unmatched) > 0 {
+			logger.Info("Masking rules matched no table in the dump", "tables", unmatched)
+		}
 	}
 
-	logger.Info("Successfully completed database dump")
-	return nil
+	// Checksum the content as committed, before compression and encryption make every dump differ
+	var checksums *dumpChecksums
+	var err error
+	if dumpFormat(pgSync) == cevichev1alpha1.DumpFormatPlain {
+		checksums, err = fileChecksums(dumpFilePath)
+	} else {
+		checksums, err = archiveChecksums(dumpFilePath)
+	}
+	if err != nil {
+		logger.Error(err, "failed to checksum dump")
+		return "", nil, fmt.Errorf("failed to checksum dump: %w", err)
+	}
+
+	// Compress plain and tar dumps, pg_dump compresses the other formats itself
+	if format := dumpFormat(pgSync); compressedInGo(format) {
+		compression, level := dumpCompression(pgSync)
+		compressedPath, err := compressFile(dumpFilePath, compression, level)
+		if err != nil {
+			logger.Error(err, "failed to compress dump")
+			return "", nil, err
+		}
+		dumpFile = filepath.Base(compressedPath)
+	}
+
+	// Encrypt the dump once compressed, ciphertext doesn't compress
+	if encrypt != nil {
+		encryption := dumpEncryption(pgSync)
+		encryptedPath, err := encryptDump(filepath.Join(dumpsDir, dumpFile), encryption, encrypt)
+		if err != nil {
+			logger.Error(err, "failed to encrypt dump")
+			return "", nil, err
+		}
+		dumpFile = filepath.Base(encryptedPath)
+	}
+
+	return dumpFile, checksums, nil
 }
 
 // cloneRepository clones the Git repository to a temporary directory
-func (r *PostgresSyncReconciler) cloneRepository(repoURL, username, password string) (string, error) {
+// When branch is set and doesn't exist on the remote yet, an orphan branch is prepared instead
+func (r *PostgresSyncReconciler) cloneRepository(repoURL, branch string, auth transport.AuthMethod, options cloneOptions) (string, error) {
 	// Create temporary directory
 	tempDir, err := os.MkdirTemp("", "git-repo-*")
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp dir: %w", err)
 	}
 
-	// Clone the repository
-	_, err = git.PlainClone(tempDir, false, &git.CloneOptions{
-		URL: repoURL,
-		Auth: &http.BasicAuth{
-			Username: username,
-			Password: password,
-		},
-	})
-
-	if err != nil {
+	if err := cloneInto(tempDir, repoURL, branch, auth, options); err != nil {
 		if errRemove := os.RemoveAll(tempDir); errRemove != nil {
 			_ = errRemove
 		}
@@ -419,8 +586,20 @@ func (r *PostgresSyncReconciler) cloneRepository(repoURL, username, password str
 	return tempDir, nil
 }
 
-// commitAndPushChanges commits and pushes changes to the Git repository
-func (r *PostgresSyncReconciler) commitAndPushChanges(repoDir, username, password, commitMessage string) error {
+// dumpCommit describes the commit of a dump and where it is pushed
+type dumpCommit struct {
+	// Branch is the branch pushed, the checked out branch when empty
+	Branch string
+	// Force replaces the remote branch instead of fast-forwarding it
+	Force   bool
+	Author  *object.Signature
+	Signer  git.Signer
+	Message string
+}
+
+// commitAndPushChanges commits and pushes changes to the Git repository. Pushes rejected because the branch
+// moved are retried with the commit replayed on the new remote head
+func (r *PostgresSyncReconciler) commitAndPushChanges(repoDir string, auth transport.AuthMethod, commit dumpCommit) error {
 	// Open the repository
 	repo, err := git.PlainOpen(repoDir)
 	if err != nil {
@@ -434,29 +613,50 @@ func (r *PostgresSyncReconciler) commitAndPushChanges(repoDir, username, passwor
 	}
 
 	// Add all changes
-	if _, err := worktree.Add("."); err != nil {
-		return fmt.Errorf("failed to add changes: %w", err)
+	if err := stageChanges(worktree); err != nil {
+		return err
 	}
 
 	// Commit changes
-	_, err = worktree.Commit(commitMessage, &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  "Ceviche DB Sync Operator",
-			Email: "operator@example.com",
-			When:  time.Now(),
-		},
+	_, err = worktree.Commit(commit.Message, &git.CommitOptions{
+		Author: commit.Author,
+		Signer: commit.Signer,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to commit changes: %w", err)
 	}
 
-	// Push changes
-	err = repo.Push(&git.PushOptions{
-		Auth: &http.BasicAuth{
-			Username: username,
-			Password: password,
-		},
-	})
+	// Push changes, only the branch committed to as other local branches may be stale
+	branch := commit.Branch
+	if branch == "" {
+		head, err := repo.Head()
+		if err != nil {
+			return fmt.Errorf("failed to get HEAD: %w", err)
+		}
+		branch = head.Name().Short()
+	}
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	pushOptions := &git.PushOptions{
+		Auth:     auth,
+		Force:    commit.Force,
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", branchRef, branchRef))},
+	}
+	err = repo.Push(pushOptions)
+
+	// Someone else pushed to the branch since the clone, replay the commit on top of their changes
+	backoff := pushBackoff
+	for err != nil && !commit.Force && isPushRejected(err) && backoff.Steps > 0 {
+		time.Sleep(backoff.Step())
+
+		replayed, errReplay := replayCommit(repo, branch, auth, commit)
+		if errReplay != nil {
+			return fmt.Errorf("failed to replay commit after rejected push: %w", errReplay)
+		}
+		if !replayed {
+			return nil
+		}
+		err = repo.Push(pushOptions)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to push changes: %w", err)
 	}
@@ -469,6 +669,7 @@ func (r *PostgresSyncReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&cevichev1alpha1.PostgresSync{}).
 		Owns(&appsv1.StatefulSet{}).
+		Owns(&batchv1.Job{}).
 		// Add watch for StatefulSet events to handle scale up/down
 		Watches(
 			&appsv1.StatefulSet{},
@@ -0,0 +1,533 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+const (
+	// defaultPullRequestBranchPrefix prefixes pull request branches when spec.git.pullRequest.branchPrefix is empty
+	defaultPullRequestBranchPrefix = "cevichedbsync/"
+
+	// defaultPullRequestTitle is the pull request title when spec.git.pullRequest.title is empty
+	defaultPullRequestTitle = "Update database dump of {{ .Namespace }}/{{ .Name }}"
+
+	// pullRequestTimeout bounds each call to the API of the Git hosting service
+	pullRequestTimeout = 30 * time.Second
+
+	// giteaPageSize is how many items are listed per page from the Gitea API
+	giteaPageSize = 50
+)
+
+// pullRequestMode reports whether dumps of a PostgresSync are proposed in pull requests
+func pullRequestMode(pgSync *cevichev1alpha1.PostgresSync) bool {
+	return pgSync.Spec.Git != nil && pgSync.Spec.Git.Mode == cevichev1alpha1.GitModePullRequest
+}
+
+// pullRequestBranch returns the branch the dumps of a PostgresSync are pushed to in pullRequest mode
+func pullRequestBranch(pgSync *cevichev1alpha1.PostgresSync) string {
+	prefix := defaultPullRequestBranchPrefix
+	if spec := pgSync.Spec.Git.PullRequest; spec != nil && spec.BranchPrefix != "" {
+		prefix = spec.BranchPrefix
+	}
+	return prefix + pgSync.Namespace + "/" + pgSync.Name
+}
+
+// pullRequestProposer opens the pull requests dumps of a PostgresSync are proposed in
+type pullRequestProposer struct {
+	provider  pullRequestProvider
+	branch    string
+	title     *template.Template
+	body      *template.Template
+	labels    []string
+	autoMerge bool
+}
+
+// dumpProposer loads the pull request settings of a PostgresSync, nil when dumps are pushed directly
+func (r *PostgresSyncReconciler) dumpProposer(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) (*pullRequestProposer, error) {
+	if !pullRequestMode(pgSync) {
+		return nil, nil
+	}
+	spec := pgSync.Spec.Git.PullRequest
+	if spec == nil {
+		return nil, fmt.Errorf("spec.git.pullRequest is required by the pullRequest mode")
+	}
+
+	proposer := &pullRequestProposer{
+		branch:    pullRequestBranch(pgSync),
+		labels:    spec.Labels,
+		autoMerge: spec.AutoMerge,
+	}
+
+	title := spec.Title
+	if title == "" {
+		title = defaultPullRequestTitle
+	}
+	var err error
+	if proposer.title, err = parseTemplate("spec.git.pullRequest.title", title); err != nil {
+		return nil, err
+	}
+	if spec.Body != "" {
+		if proposer.body, err = parseTemplate("spec.git.pullRequest.body", spec.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := r.pullRequestToken(ctx, pgSync)
+	if err != nil {
+		return nil, err
+	}
+	if proposer.provider, err = newPullRequestProvider(spec, pgSync.Spec.RepositoryURL, token); err != nil {
+		return nil, err
+	}
+	return proposer, nil
+}
+
+// pullRequestToken reads the API token of a PostgresSync, the password of its Git credentials by default
+func (r *PostgresSyncReconciler) pullRequestToken(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) (string, error) {
+	reference := cevichev1alpha1.SecretKeyReference{SecretName: pgSync.Spec.GitCredentials.SecretName, Key: gitPasswordKey}
+	if token := pgSync.Spec.Git.PullRequest.Token; token != nil {
+		reference = *token
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: reference.SecretName, Namespace: pgSync.Namespace}, secret); err != nil {
+		return "", fmt.Errorf("failed to get pull request token: %w", err)
+	}
+	token := strings.TrimSpace(string(secret.Data[reference.Key]))
+	if token == "" {
+		return "", fmt.Errorf("pull request token secret %s has no %q key", reference.SecretName, reference.Key)
+	}
+	return token, nil
+}
+
+// proposeDump pushes the dump committed in repoDir to the pull request branch, replacing it, and opens a pull
+// request against the checked out branch or updates the open one
+func (r *PostgresSyncReconciler) proposeDump(ctx context.Context, proposer *pullRequestProposer, repoDir string, auth transport.AuthMethod, commit dumpCommit, data commitMessageData) (*pullRequest, error) {
+	logger := log.FromContext(ctx)
+
+	base, err := checkoutNewBranch(repoDir, proposer.branch)
+	if err != nil {
+		return nil, gitError{err}
+	}
+	commit.Branch = proposer.branch
+	commit.Force = true
+	if err := r.commitAndPushChanges(repoDir, auth, commit); err != nil {
+		return nil, gitError{fmt.Errorf("failed to push pull request branch: %w", err)}
+	}
+
+	title, err := renderTemplate(proposer.title, data)
+	if err != nil {
+		return nil, err
+	}
+	body := commit.Message
+	if proposer.body != nil {
+		if body, err = renderTemplate(proposer.body, data); err != nil {
+			return nil, err
+		}
+	}
+
+	pr, err := proposer.provider.openPullRequest(ctx, pullRequestOptions{
+		Head:   proposer.branch,
+		Base:   base,
+		Title:  strings.TrimSpace(title),
+		Body:   body,
+		Labels: proposer.labels,
+	})
+	if err != nil {
+		return nil, gitError{fmt.Errorf("failed to open pull request: %w", err)}
+	}
+
+	// The dump is proposed either way, a pull request left to merge by hand is no reason to fail it
+	if proposer.autoMerge {
+		if err := proposer.provider.enableAutoMerge(ctx, pr); err != nil {
+			logger.Error(err, "failed to enable auto-merge", "pullRequest", pr.URL)
+		}
+	}
+	return pr, nil
+}
+
+// renderTemplate executes a template parsed with parseTemplate
+func renderTemplate(tmpl *template.Template, data commitMessageData) (string, error) {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", tmpl.Name(), err)
+	}
+	return b.String(), nil
+}
+
+// pullRequestOptions describes the pull request proposing a dump
+type pullRequestOptions struct {
+	Head   string
+	Base   string
+	Title  string
+	Body   string
+	Labels []string
+}
+
+// pullRequest is a pull or merge request opened for a dump
+type pullRequest struct {
+	// Number is the number of the pull request, the iid of GitLab merge requests
+	Number int
+	URL    string
+	// id is the GitHub node ID, used to enable auto-merge
+	id string
+}
+
+// pullRequestProvider opens pull requests through the API of a Git hosting service
+type pullRequestProvider interface {
+	// openPullRequest opens a pull request from options.Head to options.Base, or updates the open one
+	openPullRequest(ctx context.Context, options pullRequestOptions) (*pullRequest, error)
+	// enableAutoMerge merges the pull request once its checks pass
+	enableAutoMerge(ctx context.Context, pr *pullRequest) error
+}
+
+// newPullRequestProvider returns the client of the hosting service of the repository
+func newPullRequestProvider(spec *cevichev1alpha1.PullRequestSpec, repoURL, token string) (pullRequestProvider, error) {
+	host, path, err := repositoryPath(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	apiURL := strings.TrimSuffix(spec.APIURL, "/")
+	if apiURL == "" {
+		apiURL = defaultAPIURL(spec.Provider, host)
+	}
+
+	client := &http.Client{Timeout: pullRequestTimeout}
+	switch spec.Provider {
+	case cevichev1alpha1.GitProviderGitHub:
+		graphqlURL := apiURL + "/graphql"
+		if strings.HasSuffix(apiURL, "/api/v3") {
+			graphqlURL = strings.TrimSuffix(apiURL, "/v3") + "/graphql"
+		}
+		return &githubProvider{
+			api:        newRESTClient(client, apiURL, "Authorization", "Bearer "+token),
+			graphqlURL: graphqlURL,
+			repository: path,
+		}, nil
+	case cevichev1alpha1.GitProviderGitLab:
+		return &gitlabProvider{
+			api:     newRESTClient(client, apiURL, "PRIVATE-TOKEN", token),
+			project: url.PathEscape(path),
+		}, nil
+	case cevichev1alpha1.GitProviderGitea:
+		return &giteaProvider{
+			api:        newRESTClient(client, apiURL, "Authorization", "token "+token),
+			repository: path,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported pull request provider %q", spec.Provider)
+	}
+}
+
+// repositoryPath returns the host and the owner/name path of a repository URL
+func repositoryPath(repoURL string) (string, string, error) {
+	endpoint, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid repository URL: %w", err)
+	}
+	path := strings.TrimSuffix(strings.Trim(endpoint.Path, "/"), ".git")
+	if !strings.Contains(path, "/") {
+		return "", "", fmt.Errorf("repository URL %s has no owner/name path", repoURL)
+	}
+	return endpoint.Host, path, nil
+}
+
+// defaultAPIURL returns the REST API URL of a hosting service running at host
+func defaultAPIURL(provider cevichev1alpha1.GitProvider, host string) string {
+	switch provider {
+	case cevichev1alpha1.GitProviderGitHub:
+		if host == "github.com" {
+			return "https://api.github.com"
+		}
+		return "https://" + host + "/api/v3"
+	case cevichev1alpha1.GitProviderGitLab:
+		return "https://" + host + "/api/v4"
+	default:
+		return "https://" + host + "/api/v1"
+	}
+}
+
+// restClient calls a JSON REST API
+type restClient struct {
+	client  *http.Client
+	baseURL string
+	header  http.Header
+}
+
+// newRESTClient returns a client of the API at baseURL authenticating with the given header
+func newRESTClient(client *http.Client, baseURL, authHeader, authValue string) *restClient {
+	header := http.Header{}
+	header.Set(authHeader, authValue)
+	header.Set("Accept", "application/json")
+	return &restClient{client: client, baseURL: baseURL, header: header}
+}
+
+// do sends body encoded as JSON to path, relative to the base URL unless absolute, and decodes the
+// response into out unless it is nil
+func (c *restClient) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	target := path
+	if !strings.HasPrefix(path, "https://") && !strings.HasPrefix(path, "http://") {
+		target = c.baseURL + path
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = c.header.Clone()
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// githubProvider opens pull requests on GitHub
+type githubProvider struct {
+	api        *restClient
+	graphqlURL string
+	repository string
+}
+
+type githubPullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	NodeID  string `json:"node_id"`
+}
+
+func (p *githubProvider) openPullRequest(ctx context.Context, options pullRequestOptions) (*pullRequest, error) {
+	owner, _, _ := strings.Cut(p.repository, "/")
+	query := url.Values{"state": {"open"}, "head": {owner + ":" + options.Head}, "base": {options.Base}}
+	var open []githubPullRequest
+	if err := p.api.do(ctx, http.MethodGet, "/repos/"+p.repository+"/pulls?"+query.Encode(), nil, &open); err != nil {
+		return nil, err
+	}
+
+	var pr githubPullRequest
+	if len(open) > 0 {
+		update := map[string]string{"title": options.Title, "body": options.Body}
+		if err := p.api.do(ctx, http.MethodPatch, fmt.Sprintf("/repos/%s/pulls/%d", p.repository, open[0].Number), update, &pr); err != nil {
+			return nil, err
+		}
+	} else {
+		create := map[string]string{"title": options.Title, "body": options.Body, "head": options.Head, "base": options.Base}
+		if err := p.api.do(ctx, http.MethodPost, "/repos/"+p.repository+"/pulls", create, &pr); err != nil {
+			return nil, err
+		}
+	}
+
+	// Pull requests share labels with issues
+	if len(options.Labels) > 0 {
+		labels := map[string][]string{"labels": options.Labels}
+		if err := p.api.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/labels", p.repository, pr.Number), labels, nil); err != nil {
+			return nil, err
+		}
+	}
+	return &pullRequest{Number: pr.Number, URL: pr.HTMLURL, id: pr.NodeID}, nil
+}
+
+func (p *githubProvider) enableAutoMerge(ctx context.Context, pr *pullRequest) error {
+	// Auto-merge is only exposed through GraphQL
+	request := map[string]any{
+		"query":     "mutation($id: ID!) { enablePullRequestAutoMerge(input: {pullRequestId: $id}) { clientMutationId } }",
+		"variables": map[string]string{"id": pr.id},
+	}
+	var response struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := p.api.do(ctx, http.MethodPost, p.graphqlURL, request, &response); err != nil {
+		return err
+	}
+	if len(response.Errors) > 0 {
+		return fmt.Errorf("failed to enable auto-merge: %s", response.Errors[0].Message)
+	}
+	return nil
+}
+
+// gitlabProvider opens merge requests on GitLab
+type gitlabProvider struct {
+	api *restClient
+	// project is the URL encoded path of the project
+	project string
+}
+
+type gitlabMergeRequest struct {
+	IID    int    `json:"iid"`
+	WebURL string `json:"web_url"`
+}
+
+func (p *gitlabProvider) openPullRequest(ctx context.Context, options pullRequestOptions) (*pullRequest, error) {
+	query := url.Values{"state": {"opened"}, "source_branch": {options.Head}, "target_branch": {options.Base}}
+	var open []gitlabMergeRequest
+	if err := p.api.do(ctx, http.MethodGet, "/projects/"+p.project+"/merge_requests?"+query.Encode(), nil, &open); err != nil {
+		return nil, err
+	}
+
+	labels := strings.Join(options.Labels, ",")
+	var mr gitlabMergeRequest
+	if len(open) > 0 {
+		update := map[string]string{"title": options.Title, "description": options.Body, "add_labels": labels}
+		if err := p.api.do(ctx, http.MethodPut, fmt.Sprintf("/projects/%s/merge_requests/%d", p.project, open[0].IID), update, &mr); err != nil {
+			return nil, err
+		}
+	} else {
+		create := map[string]any{
+			"title":                options.Title,
+			"description":          options.Body,
+			"source_branch":        options.Head,
+			"target_branch":        options.Base,
+			"labels":               labels,
+			"remove_source_branch": true,
+		}
+		if err := p.api.do(ctx, http.MethodPost, "/projects/"+p.project+"/merge_requests", create, &mr); err != nil {
+			return nil, err
+		}
+	}
+	return &pullRequest{Number: mr.IID, URL: mr.WebURL}, nil
+}
+
+func (p *gitlabProvider) enableAutoMerge(ctx context.Context, pr *pullRequest) error {
+	merge := map[string]bool{"merge_when_pipeline_succeeds": true}
+	return p.api.do(ctx, http.MethodPut, fmt.Sprintf("/projects/%s/merge_requests/%d/merge", p.project, pr.Number), merge, nil)
+}
+
+// giteaProvider opens pull requests on Gitea and Forgejo
+type giteaProvider struct {
+	api        *restClient
+	repository string
+}
+
+type giteaPullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	Head    struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+func (p *giteaProvider) openPullRequest(ctx context.Context, options pullRequestOptions) (*pullRequest, error) {
+	// Gitea can't filter pull requests by branch, so look through the open ones
+	var existing *giteaPullRequest
+	for page := 1; existing == nil; page++ {
+		var open []giteaPullRequest
+		path := fmt.Sprintf("/repos/%s/pulls?state=open&limit=%d&page=%d", p.repository, giteaPageSize, page)
+		if err := p.api.do(ctx, http.MethodGet, path, nil, &open); err != nil {
+			return nil, err
+		}
+		for i := range open {
+			if open[i].Head.Ref == options.Head && open[i].Base.Ref == options.Base {
+				existing = &open[i]
+				break
+			}
+		}
+		if len(open) < giteaPageSize {
+			break
+		}
+	}
+
+	var pr giteaPullRequest
+	if existing != nil {
+		update := map[string]string{"title": options.Title, "body": options.Body}
+		if err := p.api.do(ctx, http.MethodPatch, fmt.Sprintf("/repos/%s/pulls/%d", p.repository, existing.Number), update, &pr); err != nil {
+			return nil, err
+		}
+	} else {
+		create := map[string]string{"title": options.Title, "body": options.Body, "head": options.Head, "base": options.Base}
+		if err := p.api.do(ctx, http.MethodPost, "/repos/"+p.repository+"/pulls", create, &pr); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(options.Labels) > 0 {
+		ids, err := p.labelIDs(ctx, options.Labels)
+		if err != nil {
+			return nil, err
+		}
+		labels := map[string][]int64{"labels": ids}
+		if err := p.api.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/labels", p.repository, pr.Number), labels, nil); err != nil {
+			return nil, err
+		}
+	}
+	return &pullRequest{Number: pr.Number, URL: pr.HTMLURL}, nil
+}
+
+// labelIDs resolves label names to their IDs, Gitea only labels by ID
+func (p *giteaProvider) labelIDs(ctx context.Context, names []string) ([]int64, error) {
+	byName := make(map[string]int64)
+	for page := 1; ; page++ {
+		var labels []struct {
+			ID   int64  `json:"id"`
+			Name string `json:"name"`
+		}
+		path := fmt.Sprintf("/repos/%s/labels?limit=%d&page=%d", p.repository, giteaPageSize, page)
+		if err := p.api.do(ctx, http.MethodGet, path, nil, &labels); err != nil {
+			return nil, err
+		}
+		for _, label := range labels {
+			byName[label.Name] = label.ID
+		}
+		if len(labels) < giteaPageSize {
+			break
+		}
+	}
+
+	ids := make([]int64, 0, len(names))
+	for _, name := range names {
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("label %q not found in repository %s", name, p.repository)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (p *giteaProvider) enableAutoMerge(ctx context.Context, pr *pullRequest) error {
+	merge := map[string]any{"Do": "merge", "merge_when_checks_succeed": true}
+	return p.api.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/pulls/%d/merge", p.repository, pr.Number), merge, nil)
+}
@@ -0,0 +1,237 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// fakeAPI is a REST API answering canned responses keyed by method and request URI, recording the requests
+type fakeAPI struct {
+	*httptest.Server
+	mu        sync.Mutex
+	responses map[string]string
+	requests  []string
+	bodies    map[string]map[string]any
+	headers   http.Header
+}
+
+func newFakeAPI(responses map[string]string) *fakeAPI {
+	api := &fakeAPI{responses: responses, bodies: make(map[string]map[string]any)}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+
+		key := r.Method + " " + r.URL.RequestURI()
+		api.requests = append(api.requests, key)
+		api.headers = r.Header.Clone()
+		if body, _ := io.ReadAll(r.Body); len(body) > 0 {
+			decoded := make(map[string]any)
+			_ = json.Unmarshal(body, &decoded)
+			api.bodies[key] = decoded
+		}
+
+		response, ok := api.responses[key]
+		if !ok {
+			http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, response)
+	}))
+	DeferCleanup(api.Close)
+	return api
+}
+
+var _ = Describe("Pull requests", func() {
+	ctx := context.Background()
+	options := pullRequestOptions{
+		Head:   "cevichedbsync/prod/orders",
+		Base:   "main",
+		Title:  "Update database dump of prod/orders",
+		Body:   "Updated database dump\n",
+		Labels: []string{"database"},
+	}
+
+	provider := func(kind migrationsv1alpha1.GitProvider, api *fakeAPI) pullRequestProvider {
+		p, err := newPullRequestProvider(&migrationsv1alpha1.PullRequestSpec{Provider: kind, APIURL: api.URL + "/"},
+			"https://git.example.com/acme/db.git", "s3cr3t")
+		Expect(err).NotTo(HaveOccurred())
+		return p
+	}
+
+	It("should derive the API from the repository URL", func() {
+		host, path, err := repositoryPath("git@gitlab.example.com:group/sub/db.git")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(host).To(Equal("gitlab.example.com"))
+		Expect(path).To(Equal("group/sub/db"))
+		_, _, err = repositoryPath("https://example.com/db")
+		Expect(err).To(HaveOccurred())
+
+		Expect(defaultAPIURL(migrationsv1alpha1.GitProviderGitHub, "github.com")).To(Equal("https://api.github.com"))
+		Expect(defaultAPIURL(migrationsv1alpha1.GitProviderGitHub, "ghe.corp")).To(Equal("https://ghe.corp/api/v3"))
+		Expect(defaultAPIURL(migrationsv1alpha1.GitProviderGitLab, "gitlab.com")).To(Equal("https://gitlab.com/api/v4"))
+		Expect(defaultAPIURL(migrationsv1alpha1.GitProviderGitea, "gitea.corp")).To(Equal("https://gitea.corp/api/v1"))
+
+		p, err := newPullRequestProvider(&migrationsv1alpha1.PullRequestSpec{Provider: migrationsv1alpha1.GitProviderGitHub},
+			"https://ghe.corp/acme/db.git", "token")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.(*githubProvider).graphqlURL).To(Equal("https://ghe.corp/api/graphql"))
+	})
+
+	It("should open GitHub pull requests and enable auto-merge", func() {
+		api := newFakeAPI(map[string]string{
+			"GET /repos/acme/db/pulls?base=main&head=acme%3Acevichedbsync%2Fprod%2Forders&state=open": `[]`,
+			"POST /repos/acme/db/pulls":           `{"number": 7, "html_url": "https://github.com/acme/db/pull/7", "node_id": "PR_7"}`,
+			"POST /repos/acme/db/issues/7/labels": `[]`,
+			"POST /graphql":                       `{"data": {}}`,
+		})
+		p := provider(migrationsv1alpha1.GitProviderGitHub, api)
+
+		pr, err := p.openPullRequest(ctx, options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pr.URL).To(Equal("https://github.com/acme/db/pull/7"))
+		Expect(api.bodies["POST /repos/acme/db/pulls"]).To(HaveKeyWithValue("head", "cevichedbsync/prod/orders"))
+		Expect(api.bodies["POST /repos/acme/db/issues/7/labels"]).To(HaveKeyWithValue("labels", ConsistOf("database")))
+		Expect(api.headers.Get("Authorization")).To(Equal("Bearer s3cr3t"))
+
+		Expect(p.enableAutoMerge(ctx, pr)).To(Succeed())
+		Expect(api.bodies["POST /graphql"]).To(HaveKeyWithValue("variables", HaveKeyWithValue("id", "PR_7")))
+	})
+
+	It("should update the open GitHub pull request", func() {
+		api := newFakeAPI(map[string]string{
+			"GET /repos/acme/db/pulls?base=main&head=acme%3Acevichedbsync%2Fprod%2Forders&state=open": `[{"number": 7}]`,
+			"PATCH /repos/acme/db/pulls/7":        `{"number": 7, "html_url": "https://github.com/acme/db/pull/7"}`,
+			"POST /repos/acme/db/issues/7/labels": `[]`,
+		})
+		pr, err := provider(migrationsv1alpha1.GitProviderGitHub, api).openPullRequest(ctx, options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pr.Number).To(Equal(7))
+		Expect(api.requests).NotTo(ContainElement("POST /repos/acme/db/pulls"))
+		Expect(api.bodies["PATCH /repos/acme/db/pulls/7"]).To(HaveKeyWithValue("title", options.Title))
+	})
+
+	It("should report GraphQL errors", func() {
+		api := newFakeAPI(map[string]string{"POST /graphql": `{"errors": [{"message": "auto-merge is not allowed"}]}`})
+		err := provider(migrationsv1alpha1.GitProviderGitHub, api).enableAutoMerge(ctx, &pullRequest{id: "PR_7"})
+		Expect(err).To(MatchError(ContainSubstring("auto-merge is not allowed")))
+	})
+
+	It("should open and update GitLab merge requests", func() {
+		list := "GET /projects/acme%2Fdb/merge_requests?source_branch=cevichedbsync%2Fprod%2Forders&state=opened&target_branch=main"
+		api := newFakeAPI(map[string]string{
+			list: `[]`,
+			"POST /projects/acme%2Fdb/merge_requests":        `{"iid": 3, "web_url": "https://gitlab.example.com/acme/db/-/merge_requests/3"}`,
+			"PUT /projects/acme%2Fdb/merge_requests/3/merge": `{}`,
+		})
+		p := provider(migrationsv1alpha1.GitProviderGitLab, api)
+		pr, err := p.openPullRequest(ctx, options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pr.URL).To(HaveSuffix("/merge_requests/3"))
+		Expect(api.bodies["POST /projects/acme%2Fdb/merge_requests"]).To(HaveKeyWithValue("labels", "database"))
+		Expect(api.headers.Get("PRIVATE-TOKEN")).To(Equal("s3cr3t"))
+		Expect(p.enableAutoMerge(ctx, pr)).To(Succeed())
+
+		api.responses[list] = `[{"iid": 3}]`
+		api.responses["PUT /projects/acme%2Fdb/merge_requests/3"] = `{"iid": 3, "web_url": "https://gitlab.example.com/acme/db/-/merge_requests/3"}`
+		pr, err = p.openPullRequest(ctx, options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pr.Number).To(Equal(3))
+		Expect(api.bodies["PUT /projects/acme%2Fdb/merge_requests/3"]).To(HaveKeyWithValue("description", options.Body))
+	})
+
+	It("should open Gitea pull requests with labels resolved to IDs", func() {
+		api := newFakeAPI(map[string]string{
+			"GET /repos/acme/db/pulls?state=open&limit=50&page=1": `[{"number": 1, "head": {"ref": "feature"}, "base": {"ref": "main"}}]`,
+			"POST /repos/acme/db/pulls":                           `{"number": 2, "html_url": "https://gitea.example.com/acme/db/pulls/2"}`,
+			"GET /repos/acme/db/labels?limit=50&page=1":           `[{"id": 11, "name": "database"}]`,
+			"POST /repos/acme/db/issues/2/labels":                 `[]`,
+			"POST /repos/acme/db/pulls/2/merge":                   `{}`,
+		})
+		p := provider(migrationsv1alpha1.GitProviderGitea, api)
+		pr, err := p.openPullRequest(ctx, options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pr.URL).To(Equal("https://gitea.example.com/acme/db/pulls/2"))
+		Expect(api.bodies["POST /repos/acme/db/issues/2/labels"]).To(HaveKeyWithValue("labels", ConsistOf(BeNumerically("==", 11))))
+		Expect(api.headers.Get("Authorization")).To(Equal("token s3cr3t"))
+		Expect(p.enableAutoMerge(ctx, pr)).To(Succeed())
+
+		_, err = p.openPullRequest(ctx, pullRequestOptions{Head: "other", Base: "main", Labels: []string{"missing"}})
+		Expect(err).To(MatchError(ContainSubstring(`label "missing" not found`)))
+	})
+
+	It("should push the dump to its own branch and propose it against the base branch", func() {
+		remoteDir := GinkgoT().TempDir()
+		_, err := git.PlainInit(remoteDir, true)
+		Expect(err).NotTo(HaveOccurred())
+
+		reconciler := &PostgresSyncReconciler{}
+		author := commitAuthor(&migrationsv1alpha1.PostgresSync{})
+		push := func(branch, content string) string {
+			repoDir, err := reconciler.cloneRepository(remoteDir, "main", nil, cloneOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(os.RemoveAll, repoDir)
+			Expect(os.WriteFile(filepath.Join(repoDir, "dump.sql"), []byte(content), 0644)).To(Succeed())
+			if branch == "main" {
+				Expect(reconciler.commitAndPushChanges(repoDir, nil, dumpCommit{Branch: "main", Author: author, Message: "Initial dump"})).To(Succeed())
+			}
+			return repoDir
+		}
+		push("main", "first")
+
+		api := newFakeAPI(map[string]string{
+			"GET /repos/acme/db/pulls?base=main&head=acme%3Acevichedbsync%2Fprod%2Forders&state=open": `[]`,
+			"POST /repos/acme/db/pulls": `{"number": 1, "html_url": "https://github.com/acme/db/pull/1"}`,
+		})
+		pgSync := &migrationsv1alpha1.PostgresSync{}
+		pgSync.Name, pgSync.Namespace = "orders", "prod"
+		pgSync.Spec.Git = &migrationsv1alpha1.GitSpec{Mode: migrationsv1alpha1.GitModePullRequest, PullRequest: &migrationsv1alpha1.PullRequestSpec{}}
+		title, err := parseTemplate("spec.git.pullRequest.title", defaultPullRequestTitle)
+		Expect(err).NotTo(HaveOccurred())
+		proposer := &pullRequestProposer{
+			provider: provider(migrationsv1alpha1.GitProviderGitHub, api),
+			branch:   pullRequestBranch(pgSync),
+			title:    title,
+		}
+
+		for _, content := range []string{"second", "third"} {
+			repoDir := push("", content)
+			pr, err := reconciler.proposeDump(ctx, proposer, repoDir, nil, dumpCommit{Author: author, Message: "Updated database dump\n"},
+				commitMessageData{Namespace: "prod", Name: "orders"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pr.URL).To(Equal("https://github.com/acme/db/pull/1"))
+		}
+		Expect(api.bodies["POST /repos/acme/db/pulls"]).To(And(
+			HaveKeyWithValue("title", "Update database dump of prod/orders"),
+			HaveKeyWithValue("body", "Updated database dump\n"),
+			HaveKeyWithValue("base", "main"),
+		))
+
+		// The base branch is untouched and the pull request branch holds a single dump commit on top of it
+		remote, err := git.PlainOpen(remoteDir)
+		Expect(err).NotTo(HaveOccurred())
+		base, err := remote.Reference(plumbing.NewBranchReferenceName("main"), true)
+		Expect(err).NotTo(HaveOccurred())
+		head, err := remote.Reference(plumbing.NewBranchReferenceName("cevichedbsync/prod/orders"), true)
+		Expect(err).NotTo(HaveOccurred())
+		commit, err := remote.CommitObject(head.Hash())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(commit.ParentHashes).To(Equal([]plumbing.Hash{base.Hash()}))
+		file, err := commit.File("dump.sql")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(file.Contents()).To(Equal("third"))
+	})
+})
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// errNoDumpFound is returned when an on-demand restore finds no dump at the requested ref
+var errNoDumpFound = errors.New("no dump found to restore")
+
+// reconcileRestoreRequest runs the restore requested in Spec.RestoreRequest. It is only reached once the
+// StatefulSet is ready and no Job is running, the same checks the initial restore goes through
+func (r *PostgresSyncReconciler) reconcileRestoreRequest(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	request := *pgSync.Spec.RestoreRequest
+	if request.ID == "" {
+		request.ID = operationName(pgSync, OperationRestore, time.Now())
+	}
+
+	// Clear the request first so the restore isn't started twice, a failed restore is reported by its
+	// operation instead of retried. The update returns the stored status so keep the one recorded so far
+	status := pgSync.Status
+	pgSync.Spec.RestoreRequest = nil
+	if err := r.Update(ctx, pgSync); err != nil {
+		logger.Error(err, "failed to update PostgresSync before restore")
+		return ctrl.Result{}, err
+	}
+	pgSync.Status = status
+
+	if runsInJob(pgSync) {
+		logger.Info("Restore requested, restoring dump in a Job", "ref", request.Ref)
+		if err := r.startJob(ctx, pgSync, OperationRestore, request.Ref, ""); err != nil {
+			logger.Error(err, "failed to start restore Job")
+			markRestoreRequestFailed(pgSync, err)
+			startOperation(pgSync, request.ID, OperationRestore, "")
+			finishOperation(pgSync, request.ID, "", err, "")
+			if updateErr := r.updateStatus(ctx, pgSync); updateErr != nil {
+				logger.Error(updateErr, "failed to update PostgresSync status")
+			}
+			return ctrl.Result{}, err
+		}
+		startOperation(pgSync, request.ID, OperationRestore, pgSync.Status.ActiveJob)
+		if err := r.updateStatus(ctx, pgSync); err != nil {
+			logger.Error(err, "unable to update PostgresSync status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Report the operation as in progress while the restore runs
+	logger.Info("Restore requested, restoring dump", "ref", request.Ref)
+	startOperation(pgSync, request.ID, OperationRestore, "")
+	markInProgress(pgSync, "Restoring dump from "+restoreDescription(request.Ref))
+	if err := r.updateStatus(ctx, pgSync); err != nil {
+		logger.Error(err, "unable to update PostgresSync status")
+		return ctrl.Result{}, err
+	}
+
+	restored, err := r.findAndRestoreDump(ctx, pgSync, request.Ref)
+	if err == nil && !restored {
+		err = errNoDumpFound
+	}
+	if err != nil {
+		logger.Error(err, "Failed to restore dump")
+		markRestoreRequestFailed(pgSync, err)
+		finishOperation(pgSync, request.ID, "", err, "")
+		if updateErr := r.updateStatus(ctx, pgSync); updateErr != nil {
+			logger.Error(updateErr, "Failed to update status")
+		}
+		return ctrl.Result{}, err
+	}
+
+	markRestored(pgSync, true)
+	finishOperation(pgSync, request.ID, "", nil, pgSync.Status.Message)
+	if err := r.updateStatus(ctx, pgSync); err != nil {
+		logger.Error(err, "unable to update PostgresSync status")
+		return ctrl.Result{}, err
+	}
+
+	logger.Info("Requested restore completed successfully")
+	return ctrl.Result{}, nil
+}
+
+// restoreDescription describes the ref a restore is taken from for status messages
+func restoreDescription(ref string) string {
+	if ref == "" {
+		return "the default ref"
+	}
+	return fmt.Sprintf("ref %s", ref)
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// Keys of the S3 credentials Secret
+const (
+	s3AccessKeyIDKey     = "accessKeyID"
+	s3SecretAccessKeyKey = "secretAccessKey"
+	s3SessionTokenKey    = "sessionToken"
+)
+
+// s3Store stores dumps in a bucket of an S3 compatible object storage
+type s3Store struct {
+	client *minio.Client
+	bucket string
+	sse    encrypt.ServerSide
+}
+
+// s3Store returns the store of the bucket of the PostgresSync, authenticated with its credentials Secret
+func (r *PostgresSyncReconciler) s3Store(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) (*s3Store, error) {
+	spec := pgSync.Spec.Storage.S3
+
+	// Without a Secret, fall back to the environment and the IAM role of the pod or instance, if the operator
+	// allows it
+	if spec.Credentials == nil && !r.AmbientS3Credentials {
+		return nil, fmt.Errorf("spec.storage.s3.credentials is required unless the operator runs with --s3-ambient-credentials")
+	}
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+	})
+	if spec.Credentials != nil {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Name: spec.Credentials.SecretName, Namespace: pgSync.Namespace}, secret); err != nil {
+			return nil, fmt.Errorf("failed to get S3 credentials: %w", err)
+		}
+		accessKeyID := strings.TrimSpace(string(secret.Data[s3AccessKeyIDKey]))
+		secretAccessKey := strings.TrimSpace(string(secret.Data[s3SecretAccessKeyKey]))
+		if accessKeyID == "" || secretAccessKey == "" {
+			return nil, fmt.Errorf("S3 credentials secret %s needs the %q and %q keys", spec.Credentials.SecretName, s3AccessKeyIDKey, s3SecretAccessKeyKey)
+		}
+		creds = credentials.NewStaticV4(accessKeyID, secretAccessKey, strings.TrimSpace(string(secret.Data[s3SessionTokenKey])))
+	}
+
+	return newS3Store(spec, creds)
+}
+
+// newS3Store returns the store of the bucket described by spec
+func newS3Store(spec *cevichev1alpha1.S3StorageSpec, creds *credentials.Credentials) (*s3Store, error) {
+	endpoint, secure := s3Endpoint(spec.Endpoint)
+	lookup := minio.BucketLookupDNS
+	if spec.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:        creds,
+		Secure:       secure,
+		Region:       spec.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %s: %w", spec.Endpoint, err)
+	}
+
+	store := &s3Store{client: client, bucket: spec.Bucket}
+	if sse := spec.ServerSideEncryption; sse != nil {
+		switch sse.Type {
+		case cevichev1alpha1.S3EncryptionAES256:
+			store.sse = encrypt.NewSSE()
+		case cevichev1alpha1.S3EncryptionKMS:
+			if store.sse, err = encrypt.NewSSEKMS(sse.KMSKeyID, nil); err != nil {
+				return nil, fmt.Errorf("invalid server-side encryption: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported server-side encryption %q", sse.Type)
+		}
+	}
+	return store, nil
+}
+
+// s3Endpoint splits the scheme off an endpoint and reports whether it is reached over HTTPS
+func s3Endpoint(endpoint string) (string, bool) {
+	if host, ok := strings.CutPrefix(endpoint, "http://"); ok {
+		return strings.TrimSuffix(host, "/"), false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(endpoint, "https://"), "/"), true
+}
+
+func (s *s3Store) list(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		keys = append(keys, object.Key)
+	}
+	return keys, nil
+}
+
+func (s *s3Store) download(ctx context.Context, key, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Objects encrypted with SSE-S3 or SSE-KMS are decrypted by the storage without any header
+	return s.client.FGetObject(ctx, s.bucket, key, path, minio.GetObjectOptions{})
+}
+
+func (s *s3Store) upload(ctx context.Context, path, key string) error {
+	// Large dumps are uploaded in parts
+	_, err := s.client.FPutObject(ctx, s.bucket, key, path, minio.PutObjectOptions{
+		ContentType:          "application/octet-stream",
+		ServerSideEncryption: s.sse,
+	})
+	return err
+}
+
+func (s *s3Store) remove(ctx context.Context, keys []string) error {
+	objects := make(chan minio.ObjectInfo, len(keys))
+	for _, key := range keys {
+		objects <- minio.ObjectInfo{Key: key}
+	}
+	close(objects)
+	// Drain every result so the removal finishes, keeping the first error
+	var err error
+	for result := range s.client.RemoveObjects(ctx, s.bucket, objects, minio.RemoveObjectsOptions{}) {
+		if result.Err != nil && err == nil {
+			err = fmt.Errorf("failed to delete %s: %w", result.ObjectName, result.Err)
+		}
+	}
+	return err
+}
+
+func (s *s3Store) location(key string) string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, key)
+}
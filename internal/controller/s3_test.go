@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// The S3 store is tested against a local MinIO when S3_TEST_ENDPOINT is set, such as one started with
+// docker run -p 9000:9000 minio/minio server /data, using its default credentials unless
+// S3_TEST_ACCESS_KEY_ID and S3_TEST_SECRET_ACCESS_KEY say otherwise
+var _ = Describe("S3 dump storage", func() {
+	ctx := context.Background()
+	var store *s3Store
+
+	BeforeEach(func() {
+		endpoint := os.Getenv("S3_TEST_ENDPOINT")
+		if endpoint == "" {
+			Skip("S3_TEST_ENDPOINT is not set")
+		}
+		accessKeyID, secretAccessKey := os.Getenv("S3_TEST_ACCESS_KEY_ID"), os.Getenv("S3_TEST_SECRET_ACCESS_KEY")
+		if accessKeyID == "" {
+			accessKeyID, secretAccessKey = "minioadmin", "minioadmin"
+		}
+
+		var err error
+		bucket := "ceviche-test-" + time.Now().Format("20060102150405")
+		store, err = newS3Store(&migrationsv1alpha1.S3StorageSpec{
+			Endpoint:  endpoint,
+			Bucket:    bucket,
+			PathStyle: true,
+		}, credentials.NewStaticV4(accessKeyID, secretAccessKey, ""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{})).To(Succeed())
+		DeferCleanup(func() {
+			keys, err := store.list(ctx, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store.remove(ctx, keys)).To(Succeed())
+			Expect(store.client.RemoveBucket(ctx, bucket)).To(Succeed())
+		})
+	})
+
+	It("should store, restore and delete dumps in the bucket", func() {
+		storage := &objectStorage{objects: store, root: "dumps", keep: 1}
+		for i, content := range []string{"first", "second"} {
+			dumpsDir, release, err := storage.prepare(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.WriteFile(filepath.Join(dumpsDir, "dump.sql"), []byte(content), 0644)).To(Succeed())
+			Expect(writeChecksums(dumpsDir, &dumpChecksums{Files: []string{"dump.sql"}, Schema: content})).To(Succeed())
+			taken := time.Date(2026, 10, 1+i, 3, 0, 0, 0, time.UTC)
+			result, err := storage.store(ctx, dumpsDir, storedDump{File: "dump.sql", Data: commitMessageData{Time: taken}})
+			release()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Location).To(Equal("s3://" + store.bucket + "/dumps/" + taken.Format(dumpNameLayout) + "/dump.sql"))
+		}
+
+		Expect(store.list(ctx, "")).To(Equal([]string{"dumps/20261002T030000Z/checksums.json", "dumps/20261002T030000Z/dump.sql"}))
+		dumpsDir, release, err := storage.checkout(ctx, "")
+		Expect(err).NotTo(HaveOccurred())
+		defer release()
+		Expect(os.ReadFile(filepath.Join(dumpsDir, "dump.sql"))).To(BeEquivalentTo("second"))
+	})
+})
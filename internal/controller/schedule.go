@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// dumpSchedule is a parsed Spec.Schedule bound to a single PostgresSync
+type dumpSchedule struct {
+	cron     cron.Schedule
+	location *time.Location
+	offset   time.Duration
+	deadline *time.Duration
+	policy   cevichev1alpha1.MissedRunPolicy
+}
+
+// scheduledRun describes the most recent scheduled time that became due
+type scheduledRun struct {
+	// scheduledTime is the cron slot, without jitter, or the starting deadline when only slots past it
+	// became due
+	scheduledTime time.Time
+	// missed is the number of slots that became due since the last evaluated one, not counting those past
+	// the starting deadline
+	missed int
+	// run is false when the slot must be skipped (deadline exceeded or MissedRunPolicy Skip)
+	run bool
+	// expired is true when slots were skipped for being past the starting deadline
+	expired bool
+}
+
+// newDumpSchedule parses the schedule of a PostgresSync
+func newDumpSchedule(pgSync *cevichev1alpha1.PostgresSync) (*dumpSchedule, error) {
+	spec := pgSync.Spec.Schedule
+	if spec == nil {
+		return nil, fmt.Errorf("schedule is not set")
+	}
+
+	sched, err := cron.ParseStandard(spec.Cron)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", spec.Cron, err)
+	}
+
+	location := time.UTC
+	if spec.TimeZone != "" {
+		location, err = time.LoadLocation(spec.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", spec.TimeZone, err)
+		}
+	}
+
+	s := &dumpSchedule{
+		cron:     sched,
+		location: location,
+		policy:   spec.MissedRunPolicy,
+	}
+	if s.policy == "" {
+		s.policy = cevichev1alpha1.MissedRunPolicyRunOnce
+	}
+
+	if spec.Jitter != nil && spec.Jitter.Duration > 0 {
+		// Derive a stable offset from the UID so the same sync always runs at the same point in its window
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(pgSync.UID))
+		s.offset = time.Duration(h.Sum64() % uint64(spec.Jitter.Duration))
+	}
+
+	if spec.StartingDeadlineSeconds != nil {
+		deadline := time.Duration(*spec.StartingDeadlineSeconds) * time.Second
+		s.deadline = &deadline
+	}
+
+	return s, nil
+}
+
+// next returns the next time a dump is due strictly after now, jitter included
+func (s *dumpSchedule) next(now time.Time) time.Time {
+	return s.cron.Next(now.Add(-s.offset).In(s.location)).Add(s.offset)
+}
+
+// due returns the most recent slot in (since, now] that became due, or nil if none did
+func (s *dumpSchedule) due(since, now time.Time) *scheduledRun {
+	// Slots older than the deadline can never run, so don't walk through them, only note they were skipped
+	expired := false
+	if s.deadline != nil {
+		if earliest := now.Add(-s.offset - *s.deadline); earliest.After(since) {
+			expired = !s.cron.Next(since.In(s.location)).After(earliest)
+			since = earliest
+		}
+	}
+
+	var run *scheduledRun
+	for t := s.cron.Next(since.In(s.location)); !t.Add(s.offset).After(now); t = s.cron.Next(t) {
+		if run == nil {
+			run = &scheduledRun{}
+		}
+		run.scheduledTime = t
+		run.missed++
+	}
+	if run == nil {
+		if !expired {
+			return nil
+		}
+		// Every slot that became due is past the deadline, evaluate them up to it
+		return &scheduledRun{scheduledTime: since, expired: true}
+	}
+
+	run.run = true
+	run.expired = expired
+	if s.deadline != nil && now.Sub(run.scheduledTime.Add(s.offset)) > *s.deadline {
+		run.run = false
+		run.expired = true
+	}
+	if s.policy == cevichev1alpha1.MissedRunPolicySkip && run.missed > 1 {
+		run.run = false
+	}
+
+	return run
+}
+
+// reconcileSchedule takes a dump when the schedule is due and requeues for the next scheduled time
+func (r *PostgresSyncReconciler) reconcileSchedule(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	schedule, err := newDumpSchedule(pgSync)
+	if err != nil {
+		// An invalid schedule won't fix itself, so report it without requeueing
+		logger.Error(err, "invalid schedule")
+		message := fmt.Sprintf("Invalid schedule: %v", err)
+		pgSync.Status.Phase = PhaseFailed
+		pgSync.Status.Message = message
+		pgSync.Status.NextScheduledTime = nil
+		setCondition(pgSync, cevichev1alpha1.ConditionReady, metav1.ConditionFalse, cevichev1alpha1.ReasonInvalidSchedule, message)
+		if err := r.updateStatus(ctx, pgSync); err != nil {
+			logger.Error(err, "unable to update PostgresSync status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	since := pgSync.CreationTimestamp.Time
+	if pgSync.Status.LastScheduledTime != nil {
+		since = pgSync.Status.LastScheduledTime.Time
+	}
+
+	if run := schedule.due(since, time.Now()); run != nil {
+		pgSync.Status.LastScheduledTime = &metav1.Time{Time: run.scheduledTime}
+		recordMissedSchedule(pgSync, run)
+
+		if run.run && runsInJob(pgSync) {
+			logger.Info("Scheduled dump is due, creating database dump in a Job", "scheduledTime", run.scheduledTime)
+
+			if err := r.startDumpJob(ctx, pgSync, cevichev1alpha1.DumpTriggerSchedule); err != nil {
+				logger.Error(err, "failed to start scheduled dump Job")
+				markDumpFailed(pgSync, err)
+				pgSync.Status.NextScheduledTime = &metav1.Time{Time: schedule.next(time.Now())}
+				if updateErr := r.updateStatus(ctx, pgSync); updateErr != nil {
+					logger.Error(updateErr, "failed to update PostgresSync status")
+				}
+				return ctrl.Result{}, err
+			}
+		} else if run.run {
+			logger.Info("Scheduled dump is due, creating database dump", "scheduledTime", run.scheduledTime)
+
+			_, result, err := r.runDump(ctx, pgSync, cevichev1alpha1.DumpTriggerSchedule)
+			if err != nil {
+				logger.Error(err, "failed to create scheduled database dump")
+				markDumpFailed(pgSync, err)
+				pgSync.Status.NextScheduledTime = &metav1.Time{Time: schedule.next(time.Now())}
+				if updateErr := r.updateStatus(ctx, pgSync); updateErr != nil {
+					logger.Error(updateErr, "failed to update PostgresSync status")
+				}
+				return ctrl.Result{}, err
+			}
+
+			markDumpSucceeded(pgSync, result, "Scheduled database dump created successfully", metav1.Now())
+		} else {
+			logger.Info("Skipping missed scheduled dump", "scheduledTime", run.scheduledTime, "missed", run.missed,
+				"expired", run.expired)
+			pgSync.Status.Message = meta.FindStatusCondition(pgSync.Status.Conditions, cevichev1alpha1.ConditionMissedSchedule).Message
+		}
+	}
+
+	next := schedule.next(time.Now())
+	pgSync.Status.NextScheduledTime = &metav1.Time{Time: next}
+	if err := r.updateStatus(ctx, pgSync); err != nil {
+		logger.Error(err, "unable to update PostgresSync status")
+		return ctrl.Result{}, err
+	}
+
+	// A dump can outlast the next slot, in which case requeue right away
+	requeueAfter := time.Until(next)
+	if requeueAfter < time.Second {
+		requeueAfter = time.Second
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// recordMissedSchedule records in the MissedSchedule condition whether scheduled dumps were skipped when run
+// became due, like the events of a CronJob
+func recordMissedSchedule(pgSync *cevichev1alpha1.PostgresSync, run *scheduledRun) {
+	scheduled := run.scheduledTime.Format(time.RFC3339)
+	switch {
+	case !run.run && run.expired:
+		setCondition(pgSync, cevichev1alpha1.ConditionMissedSchedule, metav1.ConditionTrue, cevichev1alpha1.ReasonDeadlineExceeded,
+			fmt.Sprintf("Skipped scheduled dump(s) past the starting deadline, up to %s", scheduled))
+	case !run.run:
+		setCondition(pgSync, cevichev1alpha1.ConditionMissedSchedule, metav1.ConditionTrue, cevichev1alpha1.ReasonMissedRunsSkipped,
+			fmt.Sprintf("Skipped %d missed scheduled dump(s), last scheduled at %s", run.missed, scheduled))
+	case run.expired:
+		setCondition(pgSync, cevichev1alpha1.ConditionMissedSchedule, metav1.ConditionTrue, cevichev1alpha1.ReasonDeadlineExceeded,
+			fmt.Sprintf("Skipped scheduled dump(s) past the starting deadline before the one scheduled at %s", scheduled))
+	default:
+		setCondition(pgSync, cevichev1alpha1.ConditionMissedSchedule, metav1.ConditionFalse, cevichev1alpha1.ReasonOnSchedule,
+			fmt.Sprintf("Started the dump scheduled at %s", scheduled))
+	}
+}
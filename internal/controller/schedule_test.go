@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Dump schedule", func() {
+	newSync := func(schedule migrationsv1alpha1.ScheduleSpec) *migrationsv1alpha1.PostgresSync {
+		return &migrationsv1alpha1.PostgresSync{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-schedule", Namespace: "default", UID: "0b5c1a52"},
+			Spec:       migrationsv1alpha1.PostgresSyncSpec{Schedule: &schedule},
+		}
+	}
+	at := func(value string) time.Time {
+		t, err := time.Parse(time.RFC3339, value)
+		Expect(err).NotTo(HaveOccurred())
+		return t
+	}
+
+	It("should reject invalid cron expressions and time zones", func() {
+		_, err := newDumpSchedule(newSync(migrationsv1alpha1.ScheduleSpec{Cron: "every day"}))
+		Expect(err).To(HaveOccurred())
+
+		_, err = newDumpSchedule(newSync(migrationsv1alpha1.ScheduleSpec{Cron: "0 3 * * *", TimeZone: "Mars/Olympus"}))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should evaluate the cron expression in the configured time zone", func() {
+		schedule, err := newDumpSchedule(newSync(migrationsv1alpha1.ScheduleSpec{
+			Cron:     "0 3 * * *",
+			TimeZone: "America/Mexico_City",
+		}))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(schedule.next(at("2025-03-01T00:00:00Z"))).To(BeTemporally("==", at("2025-03-01T09:00:00Z")))
+	})
+
+	It("should keep the jitter offset stable and within bounds", func() {
+		spec := migrationsv1alpha1.ScheduleSpec{Cron: "0 * * * *", Jitter: &metav1.Duration{Duration: 10 * time.Minute}}
+		first, err := newDumpSchedule(newSync(spec))
+		Expect(err).NotTo(HaveOccurred())
+		second, err := newDumpSchedule(newSync(spec))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(first.offset).To(Equal(second.offset))
+		Expect(first.offset).To(BeNumerically("<", 10*time.Minute))
+		Expect(first.next(at("2025-03-01T00:30:00Z"))).To(BeTemporally("==", at("2025-03-01T01:00:00Z").Add(first.offset)))
+	})
+
+	It("should run only the most recent of several missed slots", func() {
+		schedule, err := newDumpSchedule(newSync(migrationsv1alpha1.ScheduleSpec{Cron: "0 * * * *"}))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(schedule.due(at("2025-03-01T00:00:00Z"), at("2025-03-01T00:59:00Z"))).To(BeNil())
+
+		run := schedule.due(at("2025-03-01T00:00:00Z"), at("2025-03-01T03:10:00Z"))
+		Expect(run).NotTo(BeNil())
+		Expect(run.scheduledTime).To(BeTemporally("==", at("2025-03-01T03:00:00Z")))
+		Expect(run.missed).To(Equal(3))
+		Expect(run.run).To(BeTrue())
+	})
+
+	It("should skip slots past the starting deadline or missed with the Skip policy", func() {
+		deadline := int64(60)
+		schedule, err := newDumpSchedule(newSync(migrationsv1alpha1.ScheduleSpec{
+			Cron:                    "0 * * * *",
+			StartingDeadlineSeconds: &deadline,
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(schedule.due(at("2025-03-01T00:00:00Z"), at("2025-03-01T01:00:30Z")).run).To(BeTrue())
+		run := schedule.due(at("2025-03-01T00:00:00Z"), at("2025-03-01T01:05:00Z"))
+		Expect(run.run).To(BeFalse())
+		Expect(run.expired).To(BeTrue())
+
+		schedule, err = newDumpSchedule(newSync(migrationsv1alpha1.ScheduleSpec{
+			Cron:            "0 * * * *",
+			MissedRunPolicy: migrationsv1alpha1.MissedRunPolicySkip,
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(schedule.due(at("2025-03-01T00:00:00Z"), at("2025-03-01T01:05:00Z")).run).To(BeTrue())
+		Expect(schedule.due(at("2025-03-01T00:00:00Z"), at("2025-03-01T03:05:00Z")).run).To(BeFalse())
+	})
+
+	It("should record the runs skipped past the starting deadline", func() {
+		deadline := int64(60)
+		pgSync := newSync(migrationsv1alpha1.ScheduleSpec{Cron: "0 * * * *", StartingDeadlineSeconds: &deadline})
+		schedule, err := newDumpSchedule(pgSync)
+		Expect(err).NotTo(HaveOccurred())
+
+		// The operator was down for days, every slot is past the deadline
+		run := schedule.due(at("2025-03-01T00:00:00Z"), at("2025-03-04T00:30:00Z"))
+		Expect(run).NotTo(BeNil())
+		Expect(run.run).To(BeFalse())
+		Expect(run.scheduledTime).To(BeTemporally("==", at("2025-03-04T00:29:00Z")))
+		recordMissedSchedule(pgSync, run)
+		condition := meta.FindStatusCondition(pgSync.Status.Conditions, migrationsv1alpha1.ConditionMissedSchedule)
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Reason).To(Equal(migrationsv1alpha1.ReasonDeadlineExceeded))
+
+		// Evaluating from the deadline again only finds the next slot, which still runs
+		run = schedule.due(run.scheduledTime, at("2025-03-04T01:00:10Z"))
+		Expect(run.run).To(BeTrue())
+		Expect(run.expired).To(BeFalse())
+		recordMissedSchedule(pgSync, run)
+		condition = meta.FindStatusCondition(pgSync.Status.Conditions, migrationsv1alpha1.ConditionMissedSchedule)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+
+		// Slots past the deadline before the one that runs are recorded too
+		run = schedule.due(at("2025-03-04T01:00:00Z"), at("2025-03-04T03:00:30Z"))
+		Expect(run.run).To(BeTrue())
+		Expect(run.expired).To(BeTrue())
+		recordMissedSchedule(pgSync, run)
+		condition = meta.FindStatusCondition(pgSync.Status.Conditions, migrationsv1alpha1.ConditionMissedSchedule)
+		Expect(condition.Status).To(Equal(metav1.ConditionTrue))
+		Expect(condition.Message).To(ContainSubstring("2025-03-04T03:00:00Z"))
+	})
+})
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+const (
+	// sshSignatureNamespace is the namespace git signs and verifies commits in
+	sshSignatureNamespace = "git"
+
+	// sshSignatureLineLength is the width ssh-keygen wraps armored signatures at
+	sshSignatureLineLength = 70
+)
+
+// commitSigner loads the key dump commits of a PostgresSync are signed with, nil when they aren't signed
+func (r *PostgresSyncReconciler) commitSigner(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) (git.Signer, error) {
+	if pgSync.Spec.Git == nil || pgSync.Spec.Git.Signing == nil {
+		return nil, nil
+	}
+
+	signing := pgSync.Spec.Git.Signing
+	secret := &corev1.Secret{}
+	secretKey := types.NamespacedName{Name: signing.Key.SecretName, Namespace: pgSync.Namespace}
+	if err := r.Get(ctx, secretKey, secret); err != nil {
+		return nil, fmt.Errorf("failed to get commit signing key: %w", err)
+	}
+	key := secret.Data[signing.Key.Key]
+	if len(key) == 0 {
+		return nil, fmt.Errorf("signing key secret %s has no %q key", signing.Key.SecretName, signing.Key.Key)
+	}
+
+	return newCommitSigner(signing.Type, key, secret.Data[passphraseKey])
+}
+
+// newCommitSigner parses a signing key: an armored OpenPGP key ring, whose first private key signs, or
+// an OpenSSH private key, decrypted with the passphrase if it is protected
+func newCommitSigner(signingType cevichev1alpha1.CommitSigningType, key, passphrase []byte) (git.Signer, error) {
+	switch signingType {
+	case "", cevichev1alpha1.CommitSigningTypeOpenPGP:
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse OpenPGP signing key: %w", err)
+		}
+		for _, entity := range entities {
+			if entity.PrivateKey == nil {
+				continue
+			}
+			if entity.PrivateKey.Encrypted {
+				if len(passphrase) == 0 {
+					return nil, fmt.Errorf("OpenPGP signing key is protected, %q is required in the signing key secret", passphraseKey)
+				}
+				if err := entity.DecryptPrivateKeys(passphrase); err != nil {
+					return nil, fmt.Errorf("failed to unlock OpenPGP signing key: %w", err)
+				}
+			}
+			return openpgpSigner{entity: entity}, nil
+		}
+		return nil, fmt.Errorf("no OpenPGP private key found in signing key")
+	case cevichev1alpha1.CommitSigningTypeSSH:
+		var signer ssh.Signer
+		var err error
+		if len(passphrase) > 0 {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, passphrase)
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH signing key: %w", err)
+		}
+		return sshSigner{signer: signer}, nil
+	default:
+		return nil, fmt.Errorf("unsupported commit signing type %q", signingType)
+	}
+}
+
+// openpgpSigner signs commits with a detached armored OpenPGP signature
+type openpgpSigner struct {
+	entity *openpgp.Entity
+}
+
+func (s openpgpSigner) Sign(message io.Reader) ([]byte, error) {
+	var b bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&b, s.entity, message, nil); err != nil {
+		return nil, fmt.Errorf("failed to sign commit: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// sshSigner signs commits with an armored SSH signature in the format of ssh-keygen -Y sign, which is
+// what git writes with gpg.format=ssh
+type sshSigner struct {
+	signer ssh.Signer
+}
+
+func (s sshSigner) Sign(message io.Reader) ([]byte, error) {
+	h := sha512.New()
+	if _, err := io.Copy(h, message); err != nil {
+		return nil, fmt.Errorf("failed to read commit: %w", err)
+	}
+	signedData := sshSignedData(sshSignatureNamespace, h.Sum(nil))
+
+	// ssh-rsa signatures use SHA-1, which git refuses
+	var signature *ssh.Signature
+	var err error
+	if algorithmSigner, ok := s.signer.(ssh.AlgorithmSigner); ok && s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, signedData, ssh.KeyAlgoRSASHA512)
+	} else {
+		signature, err = s.signer.Sign(rand.Reader, signedData)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign commit: %w", err)
+	}
+
+	var blob bytes.Buffer
+	blob.WriteString("SSHSIG")
+	_ = binary.Write(&blob, binary.BigEndian, uint32(1))
+	writeSSHString(&blob, s.signer.PublicKey().Marshal())
+	writeSSHString(&blob, []byte(sshSignatureNamespace))
+	writeSSHString(&blob, nil)
+	writeSSHString(&blob, []byte("sha512"))
+	writeSSHString(&blob, ssh.Marshal(signature))
+
+	encoded := base64.StdEncoding.EncodeToString(blob.Bytes())
+	var armored bytes.Buffer
+	armored.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > sshSignatureLineLength {
+		armored.WriteString(encoded[:sshSignatureLineLength] + "\n")
+		encoded = encoded[sshSignatureLineLength:]
+	}
+	armored.WriteString(encoded + "\n")
+	armored.WriteString("-----END SSH SIGNATURE-----\n")
+	return armored.Bytes(), nil
+}
+
+// sshSignedData returns the blob an SSH signature covers for a message hashed with SHA-512
+func sshSignedData(namespace string, digest []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("SSHSIG")
+	writeSSHString(&b, []byte(namespace))
+	writeSSHString(&b, nil)
+	writeSSHString(&b, []byte("sha512"))
+	writeSSHString(&b, digest)
+	return b.Bytes()
+}
+
+// writeSSHString writes s in the length prefixed string encoding of the SSH wire format
+func writeSSHString(b *bytes.Buffer, s []byte) {
+	_ = binary.Write(b, binary.BigEndian, uint32(len(s)))
+	b.Write(s)
+}
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Commit signing", func() {
+	// pushSigned commits a dump signed by signer to a new remote and returns the pushed commit
+	pushSigned := func(signer git.Signer) (*git.Repository, plumbing.Hash) {
+		remoteDir := GinkgoT().TempDir()
+		_, err := git.PlainInit(remoteDir, true)
+		Expect(err).NotTo(HaveOccurred())
+
+		reconciler := &PostgresSyncReconciler{}
+		repoDir, err := reconciler.cloneRepository(remoteDir, "dumps", nil, cloneOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, repoDir)
+		Expect(os.WriteFile(filepath.Join(repoDir, "dump.sql"), []byte("SELECT 1;"), 0644)).To(Succeed())
+		Expect(reconciler.commitAndPushChanges(repoDir, nil, dumpCommit{Branch: "dumps", Author: commitAuthor(&migrationsv1alpha1.PostgresSync{}), Signer: signer, Message: "Updated database dump"})).To(Succeed())
+
+		remote, err := git.PlainOpen(remoteDir)
+		Expect(err).NotTo(HaveOccurred())
+		ref, err := remote.Reference(plumbing.NewBranchReferenceName("dumps"), true)
+		Expect(err).NotTo(HaveOccurred())
+		return remote, ref.Hash()
+	}
+
+	It("should sign commits with an OpenPGP key", func() {
+		entity, err := openpgp.NewEntity("Ceviche DB Sync Operator", "", "operator@example.com", nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		var public bytes.Buffer
+		writer, err := armor.Encode(&public, openpgp.PublicKeyType, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entity.Serialize(writer)).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+
+		passphrase := []byte("secret")
+		Expect(entity.EncryptPrivateKeys(passphrase, nil)).To(Succeed())
+		var private bytes.Buffer
+		writer, err = armor.Encode(&private, openpgp.PrivateKeyType, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entity.SerializePrivateWithoutSigning(writer, nil)).To(Succeed())
+		Expect(writer.Close()).To(Succeed())
+
+		_, err = newCommitSigner(migrationsv1alpha1.CommitSigningTypeOpenPGP, private.Bytes(), nil)
+		Expect(err).To(MatchError(ContainSubstring("passphrase")))
+		signer, err := newCommitSigner(migrationsv1alpha1.CommitSigningTypeOpenPGP, private.Bytes(), passphrase)
+		Expect(err).NotTo(HaveOccurred())
+
+		repo, hash := pushSigned(signer)
+		commit, err := repo.CommitObject(hash)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(commit.PGPSignature).To(HavePrefix("-----BEGIN PGP SIGNATURE-----"))
+		signedBy, err := commit.Verify(public.String())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(signedBy.PrimaryKey.KeyId).To(Equal(entity.PrimaryKey.KeyId))
+	})
+
+	It("should sign commits with an SSH key", func() {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		block, err := ssh.MarshalPrivateKey(privateKey, "")
+		Expect(err).NotTo(HaveOccurred())
+
+		signer, err := newCommitSigner(migrationsv1alpha1.CommitSigningTypeSSH, pem.EncodeToMemory(block), nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		repo, hash := pushSigned(signer)
+		commit, err := repo.CommitObject(hash)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(commit.PGPSignature).To(HavePrefix("-----BEGIN SSH SIGNATURE-----\n"))
+
+		// Verify the signature like ssh-keygen -Y verify does
+		armored := strings.TrimSpace(commit.PGPSignature)
+		armored = strings.TrimPrefix(armored, "-----BEGIN SSH SIGNATURE-----")
+		armored = strings.TrimSuffix(armored, "-----END SSH SIGNATURE-----")
+		blob, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(armored, "\n", ""))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(blob[:6])).To(Equal("SSHSIG"))
+		var sshsig struct {
+			Version       uint32
+			PublicKey     []byte
+			Namespace     string
+			Reserved      string
+			HashAlgorithm string
+			Signature     []byte
+		}
+		Expect(ssh.Unmarshal(blob[6:], &sshsig)).To(Succeed())
+		Expect(sshsig.Version).To(Equal(uint32(1)))
+		Expect(sshsig.Namespace).To(Equal("git"))
+		Expect(sshsig.HashAlgorithm).To(Equal("sha512"))
+
+		publicKey, err := ssh.ParsePublicKey(sshsig.PublicKey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(publicKey.Marshal()).To(Equal(signer.(sshSigner).signer.PublicKey().Marshal()))
+		signature := &ssh.Signature{}
+		Expect(ssh.Unmarshal(sshsig.Signature, signature)).To(Succeed())
+
+		unsigned := *commit
+		unsigned.PGPSignature = ""
+		encoded := &plumbing.MemoryObject{}
+		Expect(unsigned.EncodeWithoutSignature(encoded)).To(Succeed())
+		reader, err := encoded.Reader()
+		Expect(err).NotTo(HaveOccurred())
+		h := sha512.New()
+		_, err = io.Copy(h, reader)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(publicKey.Verify(sshSignedData("git", h.Sum(nil)), signature)).To(Succeed())
+	})
+
+	It("should reject unusable keys", func() {
+		_, err := newCommitSigner(migrationsv1alpha1.CommitSigningTypeSSH, []byte("not a key"), nil)
+		Expect(err).To(MatchError(ContainSubstring("SSH signing key")))
+		_, err = newCommitSigner(migrationsv1alpha1.CommitSigningTypeOpenPGP, []byte("not a key"), nil)
+		Expect(err).To(MatchError(ContainSubstring("OpenPGP signing key")))
+	})
+})
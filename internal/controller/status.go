@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// Messages of successful restores, shared with the Jobs running them
+const (
+	messageDumpRestored = "Database initialized from dump"
+	messageNoDumpFound  = "Ready - no existing dump found"
+)
+
+// messageDumpUnchanged reports a dump identical to the committed one
+const messageDumpUnchanged = "Database dump unchanged, nothing to commit"
+
+// gitError marks errors talking to the Git repository
+type gitError struct{ error }
+
+func (e gitError) Unwrap() error { return e.error }
+
+// storageError marks errors talking to the storage dumps are kept in instead of the Git repository
+type storageError struct{ error }
+
+func (e storageError) Unwrap() error { return e.error }
+
+// databaseError marks errors talking to the database
+type databaseError struct{ error }
+
+func (e databaseError) Unwrap() error { return e.error }
+
+// updateStatus writes the status of a PostgresSync along with the generation it reflects
+func (r *PostgresSyncReconciler) updateStatus(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) error {
+	pgSync.Status.ObservedGeneration = pgSync.Generation
+	return r.Status().Update(ctx, pgSync)
+}
+
+// setCondition sets a condition on the PostgresSync status
+func setCondition(pgSync *cevichev1alpha1.PostgresSync, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&pgSync.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: pgSync.Generation,
+	})
+}
+
+// setReachability records whether the database and the Git repository, or the storage dumps are kept in,
+// could be reached by an operation. Errors that can't be attributed to either leave the conditions untouched
+func setReachability(pgSync *cevichev1alpha1.PostgresSync, err error) {
+	var gitErr gitError
+	var storageErr storageError
+	var databaseErr databaseError
+	switch {
+	case err == nil && pgSync.Spec.Storage != nil:
+		setCondition(pgSync, cevichev1alpha1.ConditionStorageReachable, metav1.ConditionTrue,
+			cevichev1alpha1.ReasonConnected, "Dump storage is reachable")
+		setCondition(pgSync, cevichev1alpha1.ConditionDatabaseReachable, metav1.ConditionTrue,
+			cevichev1alpha1.ReasonConnected, "Database is reachable")
+	case err == nil:
+		setCondition(pgSync, cevichev1alpha1.ConditionGitReachable, metav1.ConditionTrue,
+			cevichev1alpha1.ReasonConnected, "Git repository is reachable")
+		setCondition(pgSync, cevichev1alpha1.ConditionDatabaseReachable, metav1.ConditionTrue,
+			cevichev1alpha1.ReasonConnected, "Database is reachable")
+	case errors.As(err, &gitErr):
+		setCondition(pgSync, cevichev1alpha1.ConditionGitReachable, metav1.ConditionFalse,
+			cevichev1alpha1.ReasonConnectionFailed, gitErr.Error())
+	case errors.As(err, &storageErr):
+		setCondition(pgSync, cevichev1alpha1.ConditionStorageReachable, metav1.ConditionFalse,
+			cevichev1alpha1.ReasonConnectionFailed, storageErr.Error())
+	case errors.As(err, &databaseErr):
+		setCondition(pgSync, cevichev1alpha1.ConditionDatabaseReachable, metav1.ConditionFalse,
+			cevichev1alpha1.ReasonConnectionFailed, databaseErr.Error())
+	}
+}
+
+// needsInitialRestore reports whether the database still has to be initialized from the repository
+func needsInitialRestore(pgSync *cevichev1alpha1.PostgresSync) bool {
+	if condition := meta.FindStatusCondition(pgSync.Status.Conditions, cevichev1alpha1.ConditionRestored); condition != nil {
+		return condition.Status != metav1.ConditionTrue
+	}
+	// Syncs created before conditions existed only have their phase
+	return pgSync.Status.Phase != PhaseSucceeded
+}
+
+// markPending records that the PostgresSync is waiting for its StatefulSet
+func markPending(pgSync *cevichev1alpha1.PostgresSync, reason, message string) {
+	pgSync.Status.Phase = PhasePending
+	pgSync.Status.Message = message
+	setCondition(pgSync, cevichev1alpha1.ConditionReady, metav1.ConditionFalse, reason, message)
+	setCondition(pgSync, cevichev1alpha1.ConditionDatabaseReachable, metav1.ConditionFalse, reason, message)
+}
+
+// markInProgress records that an operation is running in a Job
+func markInProgress(pgSync *cevichev1alpha1.PostgresSync, message string) {
+	pgSync.Status.Phase = PhaseInProgress
+	pgSync.Status.Message = message
+	if needsInitialRestore(pgSync) {
+		setCondition(pgSync, cevichev1alpha1.ConditionReady, metav1.ConditionFalse, cevichev1alpha1.ReasonJobRunning, message)
+	}
+}
+
+// markRestored records a successful initial restore, restored is false when there was no dump to restore
+func markRestored(pgSync *cevichev1alpha1.PostgresSync, restored bool) {
+	reason, message := cevichev1alpha1.ReasonDumpRestored, messageDumpRestored
+	if !restored {
+		reason, message = cevichev1alpha1.ReasonNoDumpFound, messageNoDumpFound
+	}
+
+	pgSync.Status.Phase = PhaseSucceeded
+	pgSync.Status.Message = message
+	setCondition(pgSync, cevichev1alpha1.ConditionRestored, metav1.ConditionTrue, reason, message)
+	setCondition(pgSync, cevichev1alpha1.ConditionReady, metav1.ConditionTrue, reason, message)
+	setReachability(pgSync, nil)
+}
+
+// markRestoreFailed records a failed restore
+func markRestoreFailed(pgSync *cevichev1alpha1.PostgresSync, err error) {
+	message := fmt.Sprintf("Failed to restore dump: %v", err)
+	pgSync.Status.Phase = PhaseFailed
+	pgSync.Status.Message = message
+	setCondition(pgSync, cevichev1alpha1.ConditionRestored, metav1.ConditionFalse, cevichev1alpha1.ReasonRestoreFailed, message)
+	setCondition(pgSync, cevichev1alpha1.ConditionReady, metav1.ConditionFalse, cevichev1alpha1.ReasonRestoreFailed, message)
+	setReachability(pgSync, err)
+}
+
+// markRestoreRequestFailed records a failed on-demand restore. Unlike markRestoreFailed it leaves the
+// Restored condition alone, so a failed request doesn't trigger the initial restore again
+func markRestoreRequestFailed(pgSync *cevichev1alpha1.PostgresSync, err error) {
+	message := fmt.Sprintf("Failed to restore dump: %v", err)
+	pgSync.Status.Phase = PhaseFailed
+	pgSync.Status.Message = message
+	setCondition(pgSync, cevichev1alpha1.ConditionReady, metav1.ConditionFalse, cevichev1alpha1.ReasonRestoreFailed, message)
+	setReachability(pgSync, err)
+}
+
+// markDumpSucceeded records a successful dump, message is replaced when the dump described by result
+// was identical to the committed one
+func markDumpSucceeded(pgSync *cevichev1alpha1.PostgresSync, result *dumpResult, message string, completionTime metav1.Time) {
+	reason := cevichev1alpha1.ReasonDumpSucceeded
+	if result != nil && result.Unchanged {
+		reason, message = cevichev1alpha1.ReasonDumpUnchanged, messageDumpUnchanged
+	}
+
+	if result != nil && result.PullRequestURL != "" {
+		pgSync.Status.PullRequestURL = result.PullRequestURL
+	}
+
+	pgSync.Status.Phase = PhaseSucceeded
+	pgSync.Status.Message = message
+	pgSync.Status.LastSyncTime = completionTime
+	setCondition(pgSync, cevichev1alpha1.ConditionLastDumpSucceeded, metav1.ConditionTrue, reason, message)
+	setCondition(pgSync, cevichev1alpha1.ConditionReady, metav1.ConditionTrue, reason, message)
+	setReachability(pgSync, nil)
+}
+
+// markDumpFailed records a failed dump
+func markDumpFailed(pgSync *cevichev1alpha1.PostgresSync, err error) {
+	message := fmt.Sprintf("Failed to dump database: %v", err)
+	pgSync.Status.Phase = PhaseFailed
+	pgSync.Status.Message = message
+	setCondition(pgSync, cevichev1alpha1.ConditionLastDumpSucceeded, metav1.ConditionFalse, cevichev1alpha1.ReasonDumpFailed, message)
+	setCondition(pgSync, cevichev1alpha1.ConditionReady, metav1.ConditionFalse, cevichev1alpha1.ReasonDumpFailed, message)
+	setReachability(pgSync, err)
+}
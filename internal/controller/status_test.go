@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("PostgresSync status", func() {
+	var pgSync *migrationsv1alpha1.PostgresSync
+
+	BeforeEach(func() {
+		pgSync = &migrationsv1alpha1.PostgresSync{ObjectMeta: metav1.ObjectMeta{Generation: 3}}
+	})
+
+	conditionStatus := func(conditionType string) metav1.ConditionStatus {
+		condition := meta.FindStatusCondition(pgSync.Status.Conditions, conditionType)
+		if condition == nil {
+			return metav1.ConditionUnknown
+		}
+		return condition.Status
+	}
+
+	It("should only restore until the database has been initialized", func() {
+		Expect(needsInitialRestore(pgSync)).To(BeTrue())
+
+		markRestoreFailed(pgSync, errors.New("boom"))
+		Expect(needsInitialRestore(pgSync)).To(BeTrue())
+
+		markRestored(pgSync, false)
+		Expect(needsInitialRestore(pgSync)).To(BeFalse())
+		Expect(pgSync.Status.Conditions[0].ObservedGeneration).To(Equal(int64(3)))
+
+		// A failed dump doesn't restore the database again
+		markDumpFailed(pgSync, errors.New("boom"))
+		Expect(needsInitialRestore(pgSync)).To(BeFalse())
+		Expect(conditionStatus(migrationsv1alpha1.ConditionReady)).To(Equal(metav1.ConditionFalse))
+		Expect(conditionStatus(migrationsv1alpha1.ConditionLastDumpSucceeded)).To(Equal(metav1.ConditionFalse))
+	})
+
+	It("should attribute failures to the database or the Git repository", func() {
+		markDumpSucceeded(pgSync, nil, "done", metav1.Now())
+		Expect(conditionStatus(migrationsv1alpha1.ConditionGitReachable)).To(Equal(metav1.ConditionTrue))
+		Expect(conditionStatus(migrationsv1alpha1.ConditionDatabaseReachable)).To(Equal(metav1.ConditionTrue))
+
+		markDumpFailed(pgSync, gitError{errors.New("authentication required")})
+		Expect(conditionStatus(migrationsv1alpha1.ConditionGitReachable)).To(Equal(metav1.ConditionFalse))
+		Expect(conditionStatus(migrationsv1alpha1.ConditionDatabaseReachable)).To(Equal(metav1.ConditionTrue))
+
+		markDumpFailed(pgSync, databaseError{errors.New("connection refused")})
+		Expect(conditionStatus(migrationsv1alpha1.ConditionDatabaseReachable)).To(Equal(metav1.ConditionFalse))
+	})
+
+	It("should attribute failures to the storage dumps are kept in", func() {
+		pgSync.Spec.Storage = &migrationsv1alpha1.StorageSpec{S3: &migrationsv1alpha1.S3StorageSpec{Bucket: "dumps"}}
+		markDumpSucceeded(pgSync, nil, "done", metav1.Now())
+		Expect(conditionStatus(migrationsv1alpha1.ConditionStorageReachable)).To(Equal(metav1.ConditionTrue))
+		Expect(conditionStatus(migrationsv1alpha1.ConditionGitReachable)).To(Equal(metav1.ConditionUnknown))
+
+		markDumpFailed(pgSync, storageError{errors.New("access denied")})
+		Expect(conditionStatus(migrationsv1alpha1.ConditionStorageReachable)).To(Equal(metav1.ConditionFalse))
+		Expect(conditionStatus(migrationsv1alpha1.ConditionDatabaseReachable)).To(Equal(metav1.ConditionTrue))
+	})
+})
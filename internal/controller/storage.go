@@ -0,0 +1,279 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// defaultDumpDirectory is where dumps are kept when the spec doesn't say otherwise
+const defaultDumpDirectory = "dumps"
+
+// dumpStorage keeps the dumps of a PostgresSync. Dumps are read and written in a local dumps directory laid
+// out the same whatever the storage, one storage is used for a single operation
+type dumpStorage interface {
+	// checkout returns a local dumps directory holding the dump at ref, the latest dump when empty, along with
+	// a function releasing it once done. The directory is missing or empty when there is no dump
+	checkout(ctx context.Context, ref string) (string, func(), error)
+
+	// prepare returns the local dumps directory a new dump is written to, along with a function releasing it
+	// once done. It holds the checksums of the latest dump, which the new dump replaces
+	prepare(ctx context.Context) (string, func(), error)
+
+	// store stores the new dump written to the dumps directory returned by prepare and describes it
+	store(ctx context.Context, dumpsDir string, dump storedDump) (*dumpResult, error)
+
+	// describe describes the latest dump, identical to the new dump written to the dumps directory returned
+	// by prepare
+	describe(ctx context.Context, dumpsDir, file string) (*dumpResult, error)
+}
+
+// storedDump describes a new dump to store
+type storedDump struct {
+	// File is the dump in the dumps directory, empty for per-table dumps made of the whole directory
+	File    string
+	Data    commitMessageData
+	Changes dumpChanges
+}
+
+// dumpDirectory returns the path dumps of the PostgresSync are kept at in its Git repository
+func dumpDirectory(pgSync *cevichev1alpha1.PostgresSync) string {
+	if pgSync.Spec.DatabaseDumpPath != "" {
+		return pgSync.Spec.DatabaseDumpPath
+	}
+	return defaultDumpDirectory
+}
+
+// dumpStorage returns the storage the dumps of the PostgresSync are kept in, its Git repository by default
+func (r *PostgresSyncReconciler) dumpStorage(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync) (dumpStorage, error) {
+	storage := pgSync.Spec.Storage
+	if storage == nil {
+		auth, err := r.getGitAuth(ctx, pgSync)
+		if err != nil {
+			return nil, gitError{err}
+		}
+		return &gitStorage{r: r, pgSync: pgSync, auth: auth}, nil
+	}
+
+	if storage.OCI != nil {
+		oci, err := r.ociStorage(ctx, pgSync)
+		if err != nil {
+			return nil, storageError{err}
+		}
+		return oci, nil
+	}
+
+	var store objectStore
+	var err error
+	switch {
+	case storage.S3 != nil:
+		store, err = r.s3Store(ctx, pgSync)
+	case storage.PVC != nil:
+		store, err = r.volumeStore(pgSync)
+	default:
+		err = fmt.Errorf("no dump storage configured")
+	}
+	if err != nil {
+		return nil, storageError{err}
+	}
+
+	objects := &objectStorage{objects: store, root: objectKey(storageKeyPrefix(storage), objectDumpDirectory(pgSync))}
+	if storage.Keep != nil {
+		objects.keep = int(*storage.Keep)
+	}
+	return objects, nil
+}
+
+// objectDumpDirectory returns the directory of the dumps of the PostgresSync in an object store: its
+// databaseDumpPath, or its namespace and name so PostgresSyncs sharing a bucket or volume keep apart
+func objectDumpDirectory(pgSync *cevichev1alpha1.PostgresSync) string {
+	if pgSync.Spec.DatabaseDumpPath != "" {
+		return pgSync.Spec.DatabaseDumpPath
+	}
+	return path.Join(pgSync.Namespace, pgSync.Name)
+}
+
+// storageKeyPrefix returns the prefix of the keys of dumps in the storage
+func storageKeyPrefix(storage *cevichev1alpha1.StorageSpec) string {
+	switch {
+	case storage.S3 != nil:
+		return storage.S3.Prefix
+	case storage.PVC != nil:
+		return storage.PVC.SubPath
+	}
+	return ""
+}
+
+// dumpSize returns the size of the dump at path, directory dumps are as large as all their files
+func dumpSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat dump: %w", err)
+	}
+	return size, nil
+}
+
+// gitStorage keeps dumps in the Git repository of the PostgresSync, committed to its branch or proposed in
+// pull requests
+type gitStorage struct {
+	r      *PostgresSyncReconciler
+	pgSync *cevichev1alpha1.PostgresSync
+	auth   transport.AuthMethod
+
+	// Set by prepare for store
+	repoDir    string
+	commitTmpl *commitTemplate
+	signer     git.Signer
+	proposer   *pullRequestProposer
+	// held checks the branch lock is still held
+	held func(context.Context) error
+}
+
+// checkout clones the repository, checking out ref when set
+func (s *gitStorage) checkout(ctx context.Context, ref string) (string, func(), error) {
+	logger := log.FromContext(ctx)
+
+	repoDir, release, err := s.r.checkoutRepository(ctx, s.pgSync, s.auth)
+	if err != nil {
+		logger.Error(err, "failed to clone Git repository")
+		return "", nil, gitError{fmt.Errorf("failed to clone Git repository: %w", err)}
+	}
+
+	// Restore from a specific branch, tag or commit if requested
+	if ref != "" {
+		logger.Info("Checking out restore ref", "ref", ref)
+		if err := checkoutRef(repoDir, ref, s.auth); err != nil {
+			release()
+			logger.Error(err, "failed to checkout restore ref")
+			return "", nil, gitError{fmt.Errorf("failed to checkout restore ref: %w", err)}
+		}
+	}
+
+	return filepath.Join(repoDir, dumpDirectory(s.pgSync)), release, nil
+}
+
+// prepare loads the commit settings, then locks the branch and clones the repository
+func (s *gitStorage) prepare(ctx context.Context) (string, func(), error) {
+	logger := log.FromContext(ctx)
+
+	// Load the commit settings before dumping, so a broken template or key doesn't cost a dump
+	var err error
+	if s.commitTmpl, err = parseCommitTemplate(s.pgSync); err != nil {
+		logger.Error(err, "invalid commit message template")
+		return "", nil, err
+	}
+	if s.signer, err = s.r.commitSigner(ctx, s.pgSync); err != nil {
+		logger.Error(err, "unable to load commit signing key")
+		return "", nil, err
+	}
+	if s.proposer, err = s.r.dumpProposer(ctx, s.pgSync); err != nil {
+		logger.Error(err, "unable to load pull request settings")
+		return "", nil, err
+	}
+
+	// Take turns with other dumps to the branch
+	unlock, held, err := s.r.lockBranch(ctx, s.pgSync, s.auth)
+	if err != nil {
+		logger.Error(err, "failed to lock Git branch")
+		return "", nil, gitError{fmt.Errorf("failed to lock Git branch: %w", err)}
+	}
+
+	// Clone repository
+	repoDir, release, err := s.r.checkoutRepository(ctx, s.pgSync, s.auth)
+	if err != nil {
+		unlock()
+		logger.Error(err, "failed to clone Git repository")
+		return "", nil, gitError{fmt.Errorf("failed to clone Git repository: %w", err)}
+	}
+	done := func() {
+		release()
+		unlock()
+	}
+
+	dumpsDir := filepath.Join(repoDir, dumpDirectory(s.pgSync))
+	if err := os.MkdirAll(dumpsDir, 0755); err != nil {
+		done()
+		logger.Error(err, "failed to create dumps directory")
+		return "", nil, fmt.Errorf("failed to create dumps directory: %w", err)
+	}
+	s.repoDir = repoDir
+	s.held = held
+	return dumpsDir, done, nil
+}
+
+// store commits and pushes the dump, or proposes it in a pull request
+func (s *gitStorage) store(ctx context.Context, dumpsDir string, dump storedDump) (*dumpResult, error) {
+	logger := log.FromContext(ctx)
+
+	commitMsg, err := s.commitTmpl.render(dump.Data, dump.Changes.commitMessage())
+	if err != nil {
+		logger.Error(err, "failed to render commit message")
+		return nil, err
+	}
+	commit := dumpCommit{
+		Branch:  gitBranch(s.pgSync),
+		Author:  commitAuthor(s.pgSync),
+		Signer:  s.signer,
+		Message: commitMsg,
+	}
+	// Only write while the branch is still ours, another dump may have taken the lock over
+	if err := s.held(ctx); err != nil {
+		logger.Error(err, "failed to hold the Git branch lock")
+		return nil, gitError{err}
+	}
+	var pr *pullRequest
+	if s.proposer != nil {
+		// Propose the dump against the branch instead of pushing to it
+		if pr, err = s.r.proposeDump(ctx, s.proposer, s.repoDir, s.auth, commit, dump.Data); err != nil {
+			logger.Error(err, "failed to propose dump in a pull request")
+			return nil, err
+		}
+		logger.Info("Proposed database dump", "pullRequest", pr.URL)
+	} else if err := s.r.commitAndPushChanges(s.repoDir, s.auth, commit); err != nil {
+		logger.Error(err, "failed to commit and push changes")
+		return nil, gitError{fmt.Errorf("failed to commit and push changes: %w", err)}
+	}
+
+	// Describe the pushed dump for its PostgresSyncDump
+	result, err := s.describe(ctx, dumpsDir, dump.File)
+	if err != nil {
+		return nil, err
+	}
+	if pr != nil {
+		result.PullRequestURL = pr.URL
+	}
+	return result, nil
+}
+
+// describe describes the dump as of the HEAD commit of the clone
+func (s *gitStorage) describe(ctx context.Context, _, file string) (*dumpResult, error) {
+	result, err := describeDump(s.repoDir, s.pgSync.Spec.RepositoryURL, filepath.Join(dumpDirectory(s.pgSync), file))
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to describe dump")
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,239 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	migrationsv1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// memoryStore is an objectStore keeping objects in memory
+type memoryStore map[string][]byte
+
+func (m memoryStore) list(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for key := range m {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m memoryStore) download(_ context.Context, key, path string) error {
+	data, ok := m[key]
+	if !ok {
+		return fmt.Errorf("no object %s", key)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (m memoryStore) upload(_ context.Context, path, key string) error {
+	data, err := os.ReadFile(path)
+	m[key] = data
+	return err
+}
+
+func (m memoryStore) remove(_ context.Context, keys []string) error {
+	for _, key := range keys {
+		delete(m, key)
+	}
+	return nil
+}
+
+func (m memoryStore) location(key string) string {
+	return "memory://" + key
+}
+
+var _ = Describe("Dump storage", func() {
+	ctx := context.Background()
+
+	Context("in an object store", func() {
+		var objects memoryStore
+		var storage *objectStorage
+
+		BeforeEach(func() {
+			objects = memoryStore{}
+			storage = &objectStorage{objects: objects, root: objectKey("/backups/", "db")}
+		})
+
+		// storeDump stores a dump taken at the given time through a fresh storage, as each operation does
+		storeDump := func(taken time.Time, content string) *dumpResult {
+			storage := &objectStorage{objects: storage.objects, root: storage.root, keep: storage.keep}
+			dumpsDir, release, err := storage.prepare(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			defer release()
+
+			Expect(os.MkdirAll(filepath.Join(dumpsDir, "dump"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dumpsDir, "dump", "toc.dat"), []byte(content), 0644)).To(Succeed())
+			Expect(writeChecksums(dumpsDir, &dumpChecksums{Files: []string{"dump"}, Schema: content})).To(Succeed())
+			result, err := storage.store(ctx, dumpsDir, storedDump{File: "dump", Data: commitMessageData{Time: taken}})
+			Expect(err).NotTo(HaveOccurred())
+			return result
+		}
+
+		checkout := func(ref string) (string, error) {
+			dumpsDir, release, err := storage.checkout(ctx, ref)
+			if err != nil {
+				return "", err
+			}
+			defer release()
+			data, err := os.ReadFile(filepath.Join(dumpsDir, "dump", "toc.dat"))
+			if os.IsNotExist(err) {
+				return "", nil
+			}
+			return string(data), err
+		}
+
+		It("should keep each dump under its own name and restore the latest or a named one", func() {
+			Expect(checkout("")).To(BeEmpty())
+
+			first := time.Date(2026, 10, 1, 3, 0, 0, 0, time.UTC)
+			result := storeDump(first, "first")
+			Expect(result.Location).To(Equal("memory://backups/db/20261001T030000Z/dump"))
+			Expect(result.Size).To(BeEquivalentTo(len("first")))
+			Expect(objects).To(HaveKey("backups/db/20261001T030000Z/dump/toc.dat"))
+			Expect(objects).To(HaveKey("backups/db/20261001T030000Z/checksums.json"))
+
+			// The previous checksums are all a new dump needs
+			dumpsDir, release, err := storage.prepare(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(readChecksums(dumpsDir).Schema).To(Equal("first"))
+			Expect(filepath.Join(dumpsDir, "dump")).NotTo(BeADirectory())
+			described, err := storage.describe(ctx, dumpsDir, "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(described.Location).To(Equal("memory://backups/db/20261001T030000Z"))
+			release()
+			Expect(dumpsDir).NotTo(BeADirectory())
+
+			storeDump(first.Add(24*time.Hour), "second")
+			Expect(checkout("")).To(Equal("second"))
+			Expect(checkout("20261001T030000Z")).To(Equal("first"))
+			_, err = checkout("20260101T000000Z")
+			Expect(err).To(MatchError(ContainSubstring("no dump named 20260101T000000Z")))
+
+			// Dumps are only complete once their checksums are stored
+			objects["backups/db/20261003T030000Z/dump/toc.dat"] = []byte("partial")
+			Expect(checkout("")).To(Equal("second"))
+
+			// Files of a dump can't lead out of the dumps directory
+			objects["backups/db/20261002T030000Z/../../../escaped.sql"] = []byte("escaped")
+			_, err = checkout("20261002T030000Z")
+			Expect(err).To(MatchError(ContainSubstring("invalid file")))
+		})
+
+		It("should delete the dumps beyond the ones to keep", func() {
+			storage.keep = 2
+			first := time.Date(2026, 10, 1, 3, 0, 0, 0, time.UTC)
+			objects["backups/db/20260930T030000Z/dump/toc.dat"] = []byte("partial")
+			// Keys that aren't dumps aren't the storage's to delete, nor ever the latest dump
+			objects["backups/db/0-manual/checksums.json"] = []byte("{}")
+			objects["backups/db/~notes/checksums.json"] = []byte("{}")
+			storeDump(first, "first")
+			storeDump(first.Add(24*time.Hour), "second")
+			Expect(objects).To(HaveKey("backups/db/20260930T030000Z/dump/toc.dat"))
+
+			storeDump(first.Add(48*time.Hour), "third")
+			Expect(checkout("")).To(Equal("third"))
+			Expect(objects.list(ctx, "")).To(Equal([]string{
+				"backups/db/0-manual/checksums.json",
+				"backups/db/20261002T030000Z/checksums.json",
+				"backups/db/20261002T030000Z/dump/toc.dat",
+				"backups/db/20261003T030000Z/checksums.json",
+				"backups/db/20261003T030000Z/dump/toc.dat",
+				"backups/db/~notes/checksums.json",
+			}))
+		})
+	})
+
+	It("should keep dumps as files of a mounted PersistentVolumeClaim", func() {
+		volumeDir := GinkgoT().TempDir()
+		pgSync := &migrationsv1alpha1.PostgresSync{Spec: migrationsv1alpha1.PostgresSyncSpec{
+			DatabaseDumpPath: "db",
+			Storage: &migrationsv1alpha1.StorageSpec{
+				PVC:  &migrationsv1alpha1.PVCStorageSpec{ClaimName: "dumps", SubPath: "staging"},
+				Keep: func() *int32 { keep := int32(1); return &keep }(),
+			},
+		}}
+		_, err := (&PostgresSyncReconciler{}).dumpStorage(ctx, pgSync)
+		Expect(err).To(MatchError(ContainSubstring("--volume-dir")))
+		_, err = (&PostgresSyncReconciler{VolumeDir: volumeDir}).dumpStorage(ctx, pgSync)
+		Expect(err).To(MatchError(ContainSubstring("is not mounted at " + filepath.Join(volumeDir, "dumps"))))
+
+		Expect(os.Mkdir(filepath.Join(volumeDir, "dumps"), 0755)).To(Succeed())
+		storage, err := (&PostgresSyncReconciler{VolumeDir: volumeDir}).dumpStorage(ctx, pgSync)
+		Expect(err).NotTo(HaveOccurred())
+		for i, content := range []string{"first", "second"} {
+			dumpsDir, release, err := storage.prepare(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.WriteFile(filepath.Join(dumpsDir, "dump.sql"), []byte(content), 0644)).To(Succeed())
+			Expect(writeChecksums(dumpsDir, &dumpChecksums{Files: []string{"dump.sql"}, Schema: content})).To(Succeed())
+			taken := time.Date(2026, 10, 1+i, 3, 0, 0, 0, time.UTC)
+			result, err := storage.store(ctx, dumpsDir, storedDump{File: "dump.sql", Data: commitMessageData{Time: taken}})
+			release()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Location).To(Equal("pvc://dumps/staging/db/" + taken.Format(dumpNameLayout) + "/dump.sql"))
+		}
+
+		// Older dumps are deleted along with their directories
+		Expect(os.ReadFile(filepath.Join(volumeDir, "dumps", "staging", "db", "20261002T030000Z", "dump.sql"))).To(BeEquivalentTo("second"))
+		Expect(filepath.Join(volumeDir, "dumps", "staging", "db", "20261001T030000Z")).NotTo(BeADirectory())
+		Expect(os.ReadDir(filepath.Join(volumeDir, "dumps", "staging", "db", "20261002T030000Z"))).To(HaveLen(2))
+
+		dumpsDir, release, err := storage.checkout(ctx, "20261002T030000Z")
+		Expect(err).NotTo(HaveOccurred())
+		defer release()
+		Expect(os.ReadFile(filepath.Join(dumpsDir, "dump.sql"))).To(BeEquivalentTo("second"))
+		Expect(jobArgs(pgSync, OperationRestore, "", "", false)).To(ContainElement("--volume-dir=" + jobVolumeDir))
+		Expect(jobArgs(pgSync, OperationRestore, "", "", true)).To(ContainElement("--s3-ambient-credentials"))
+	})
+
+	It("should keep dumps in the Git repository unless another storage is configured", func() {
+		pgSync := &migrationsv1alpha1.PostgresSync{Spec: migrationsv1alpha1.PostgresSyncSpec{
+			DatabaseDumpPath: "db",
+			Storage: &migrationsv1alpha1.StorageSpec{
+				S3: &migrationsv1alpha1.S3StorageSpec{
+					Endpoint:  "http://minio.minio.svc:9000",
+					Bucket:    "dumps",
+					Prefix:    "staging",
+					PathStyle: true,
+				},
+				Keep: func() *int32 { keep := int32(3); return &keep }(),
+			},
+		}}
+		// The role of the operator is only used for buckets without credentials when it allows it
+		_, err := (&PostgresSyncReconciler{}).dumpStorage(ctx, pgSync)
+		Expect(err).To(MatchError(ContainSubstring("spec.storage.s3.credentials is required")))
+		Expect(err).To(BeAssignableToTypeOf(storageError{}))
+		storage, err := (&PostgresSyncReconciler{AmbientS3Credentials: true}).dumpStorage(ctx, pgSync)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(storage).To(BeAssignableToTypeOf(&objectStorage{}))
+		Expect(storage.(*objectStorage).root).To(Equal("staging/db"))
+		Expect(storage.(*objectStorage).keep).To(Equal(3))
+		Expect(storage.(*objectStorage).objects.location("staging/db")).To(Equal("s3://dumps/staging/db"))
+
+		Expect(dumpDirectory(&migrationsv1alpha1.PostgresSync{})).To(Equal("dumps"))
+
+		// Without a path, PostgresSyncs sharing a bucket keep their dumps apart
+		pgSync.Name, pgSync.Namespace, pgSync.Spec.DatabaseDumpPath = "orders", "prod", ""
+		storage, err = (&PostgresSyncReconciler{AmbientS3Credentials: true}).dumpStorage(ctx, pgSync)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(storage.(*objectStorage).root).To(Equal("staging/prod/orders"))
+		Expect(s3Endpoint("http://minio:9000/")).To(Equal("minio:9000"))
+		host, secure := s3Endpoint("s3.eu-west-1.amazonaws.com")
+		Expect(host).To(Equal("s3.eu-west-1.amazonaws.com"))
+		Expect(secure).To(BeTrue())
+	})
+})
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+// volumeStore stores dumps as files in a mounted PersistentVolumeClaim, keys being paths relative to its mount
+type volumeStore struct {
+	claim string
+	dir   string
+}
+
+// volumeStore returns the store of the PersistentVolumeClaim of the PostgresSync, mounted under the VolumeDir
+// of the operator or of its Job
+func (r *PostgresSyncReconciler) volumeStore(pgSync *cevichev1alpha1.PostgresSync) (*volumeStore, error) {
+	claim := pgSync.Spec.Storage.PVC.ClaimName
+	if r.VolumeDir == "" {
+		return nil, fmt.Errorf("PersistentVolumeClaim %s is not mounted, set the operator's --volume-dir or run in Jobs", claim)
+	}
+	dir := filepath.Join(r.VolumeDir, claim)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("PersistentVolumeClaim %s is not mounted at %s", claim, dir)
+	}
+	return &volumeStore{claim: claim, dir: dir}, nil
+}
+
+func (s *volumeStore) list(_ context.Context, prefix string) ([]string, error) {
+	// Only walk the directory holding the prefix instead of the whole volume
+	start := filepath.Join(s.dir, filepath.FromSlash(path.Dir(prefix)))
+	if strings.HasSuffix(prefix, "/") {
+		start = filepath.Join(s.dir, filepath.FromSlash(prefix))
+	}
+
+	var keys []string
+	err := filepath.WalkDir(start, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, file)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return keys, err
+}
+
+func (s *volumeStore) download(_ context.Context, key, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return copyFile(s.path(key), path)
+}
+
+func (s *volumeStore) upload(_ context.Context, path, key string) error {
+	target := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// Write next to the target and rename, so a dump interrupted halfway never leaves a truncated file
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := copyFile(path, tmp.Name()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+func (s *volumeStore) remove(_ context.Context, keys []string) error {
+	for _, key := range keys {
+		if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+		// Drop the directories left empty, up to the mount
+		for dir := path.Dir(key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if os.Remove(s.path(dir)) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (s *volumeStore) location(key string) string {
+	return fmt.Sprintf("pvc://%s/%s", s.claim, key)
+}
+
+// path returns the file of the volume at key
+func (s *volumeStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+// copyFile copies the file at src to dst, flushed to disk before returning
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close() //nolint:errcheck
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close() //nolint:errcheck
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return out.Close()
+}
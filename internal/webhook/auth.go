@@ -0,0 +1,165 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+const (
+	// Headers of signed requests
+	signatureHeader = "X-Ceviche-Signature"
+	timestampHeader = "X-Ceviche-Timestamp"
+
+	// signaturePrefix precedes the hex encoded signature in the signature header
+	signaturePrefix = "sha256="
+
+	// tokenKey is the key of the token or HMAC key in the webhook credentials Secret
+	tokenKey = "token"
+
+	// defaultTimestampTolerance is how old signed requests may be when the spec doesn't say otherwise
+	defaultTimestampTolerance = 5 * time.Minute
+)
+
+var (
+	// errUnauthenticated is returned for requests without the credentials the PostgresSync expects
+	errUnauthenticated = errors.New("missing credentials")
+
+	// errForbidden is returned for requests whose credentials don't grant access to the PostgresSync
+	errForbidden = errors.New("forbidden")
+)
+
+// authenticator checks webhook requests against the credentials of the targeted PostgresSync
+type authenticator struct {
+	client client.Client
+	now    func() time.Time
+
+	// seen holds the signatures accepted so far until their timestamp expires, to reject replays
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// newAuthenticator creates an authenticator reading webhook credentials with the given client
+func newAuthenticator(c client.Client) *authenticator {
+	return &authenticator{
+		client: c,
+		now:    time.Now,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// hasCredentials reports whether a request carries any credentials at all
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get(signatureHeader) != ""
+}
+
+// authenticate checks a request for the PostgresSync. It returns an error wrapping errUnauthenticated
+// when the expected credentials are missing and errForbidden when they are wrong
+func (a *authenticator) authenticate(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, r *http.Request, body []byte) error {
+	if pgSync.Spec.Webhook == nil {
+		return fmt.Errorf("%w: webhook authentication is not configured", errForbidden)
+	}
+
+	secret := &corev1.Secret{}
+	secretKey := types.NamespacedName{
+		Name:      pgSync.Spec.Webhook.Credentials.SecretName,
+		Namespace: pgSync.Namespace,
+	}
+	if err := a.client.Get(ctx, secretKey, secret); err != nil {
+		return fmt.Errorf("failed to get webhook credentials: %w", err)
+	}
+	token := secret.Data[tokenKey]
+	if len(token) == 0 {
+		return fmt.Errorf("webhook credentials secret %s has no %q key", secretKey.Name, tokenKey)
+	}
+
+	switch pgSync.Spec.Webhook.Authentication {
+	case cevichev1alpha1.WebhookAuthenticationHMAC:
+		tolerance := defaultTimestampTolerance
+		if pgSync.Spec.Webhook.TimestampTolerance != nil {
+			tolerance = pgSync.Spec.Webhook.TimestampTolerance.Duration
+		}
+		return a.checkSignature(r, body, token, tolerance)
+	default:
+		return checkBearerToken(r, token)
+	}
+}
+
+// checkBearerToken checks the bearer token of a request
+func checkBearerToken(r *http.Request, token []byte) error {
+	scheme, credentials, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || credentials == "" {
+		return fmt.Errorf("%w: expected an Authorization: Bearer header", errUnauthenticated)
+	}
+	if subtle.ConstantTimeCompare([]byte(credentials), token) != 1 {
+		return fmt.Errorf("%w: invalid token", errForbidden)
+	}
+	return nil
+}
+
+// checkSignature checks the HMAC-SHA256 signature of a request and rejects stale or replayed ones
+func (a *authenticator) checkSignature(r *http.Request, body, key []byte, tolerance time.Duration) error {
+	signature, found := strings.CutPrefix(r.Header.Get(signatureHeader), signaturePrefix)
+	timestamp := r.Header.Get(timestampHeader)
+	if !found || signature == "" || timestamp == "" {
+		return fmt.Errorf("%w: expected %s and %s headers", errUnauthenticated, signatureHeader, timestampHeader)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp", errUnauthenticated)
+	}
+	signedAt := time.Unix(seconds, 0)
+	now := a.now()
+	if signedAt.Before(now.Add(-tolerance)) || signedAt.After(now.Add(tolerance)) {
+		return fmt.Errorf("%w: timestamp is outside the %s tolerance", errForbidden, tolerance)
+	}
+
+	expected := sign(key, timestamp, r.Method, r.URL.Path, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("%w: invalid signature", errForbidden)
+	}
+
+	// Reads are safe to repeat, only requests triggering operations can't be replayed
+	if r.Method == http.MethodGet {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for seen, expiry := range a.seen {
+		if now.After(expiry) {
+			delete(a.seen, seen)
+		}
+	}
+	if _, replayed := a.seen[signature]; replayed {
+		return fmt.Errorf("%w: request was already received", errForbidden)
+	}
+	a.seen[signature] = signedAt.Add(tolerance)
+
+	return nil
+}
+
+// sign returns the hex encoded HMAC-SHA256 of a request, computed over
+// "<timestamp>\n<method>\n<path>\n<body>"
+func sign(key []byte, timestamp, method, path string, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n", timestamp, method, path)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
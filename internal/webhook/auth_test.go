@@ -0,0 +1,99 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Webhook authentication", func() {
+	var server *WebhookServer
+
+	newServer := func(webhook *cevichev1alpha1.WebhookSpec) {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(cevichev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&cevichev1alpha1.PostgresSync{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "db"},
+				Spec:       cevichev1alpha1.PostgresSyncSpec{Webhook: webhook},
+			},
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhook", Namespace: "db"},
+				Data:       map[string][]byte{"token": []byte("s3cret")},
+			},
+		).Build()
+		server = NewWebhookServer(":0", c)
+	}
+
+	dump := func(path string, headers map[string]string) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		server.handleDumpRequest(rec, req)
+		return rec.Code
+	}
+
+	Context("with a bearer token", func() {
+		BeforeEach(func() {
+			newServer(&cevichev1alpha1.WebhookSpec{
+				Credentials: cevichev1alpha1.CredentialReference{SecretName: "webhook"},
+			})
+		})
+
+		It("should only accept the configured token", func() {
+			Expect(dump("/dump/db/app", nil)).To(Equal(http.StatusUnauthorized))
+			Expect(dump("/dump/db/app", map[string]string{"Authorization": "Basic s3cret"})).To(Equal(http.StatusUnauthorized))
+			Expect(dump("/dump/db/app", map[string]string{"Authorization": "Bearer wrong"})).To(Equal(http.StatusForbidden))
+			Expect(dump("/dump/db/missing", map[string]string{"Authorization": "Bearer s3cret"})).To(Equal(http.StatusForbidden))
+			Expect(dump("/dump/db/app", map[string]string{"Authorization": "Bearer s3cret"})).To(Equal(http.StatusAccepted))
+		})
+	})
+
+	Context("with HMAC signatures", func() {
+		BeforeEach(func() {
+			newServer(&cevichev1alpha1.WebhookSpec{
+				Authentication: cevichev1alpha1.WebhookAuthenticationHMAC,
+				Credentials:    cevichev1alpha1.CredentialReference{SecretName: "webhook"},
+			})
+		})
+
+		signed := func(path string, signedAt time.Time) map[string]string {
+			timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+			return map[string]string{
+				timestampHeader: timestamp,
+				signatureHeader: signaturePrefix + sign([]byte("s3cret"), timestamp, http.MethodPost, path, nil),
+			}
+		}
+
+		It("should accept a valid signature once", func() {
+			headers := signed("/dump/db/app", time.Now())
+			Expect(dump("/dump/db/app", headers)).To(Equal(http.StatusAccepted))
+			Expect(dump("/dump/db/app", headers)).To(Equal(http.StatusForbidden))
+		})
+
+		It("should reject stale, misdirected and unsigned requests", func() {
+			Expect(dump("/dump/db/app", signed("/dump/db/app", time.Now().Add(-10*time.Minute)))).To(Equal(http.StatusForbidden))
+			Expect(dump("/dump/db/app", signed("/dump/db/other", time.Now()))).To(Equal(http.StatusForbidden))
+			Expect(dump("/dump/db/app", map[string]string{"Authorization": "Bearer s3cret"})).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	It("should reject every request when authentication isn't configured", func() {
+		newServer(nil)
+		Expect(dump("/dump/db/app", map[string]string{"Authorization": "Bearer s3cret"})).To(Equal(http.StatusForbidden))
+	})
+})
@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+	"cevichedbsync-operator/internal/controller"
+)
+
+// operationView is an operation as reported by the API
+type operationView struct {
+	ID             string       `json:"id"`
+	Namespace      string       `json:"namespace"`
+	Name           string       `json:"name"`
+	Type           string       `json:"type"`
+	Phase          string       `json:"phase"`
+	Message        string       `json:"message,omitempty"`
+	Job            string       `json:"job,omitempty"`
+	Dump           string       `json:"dump,omitempty"`
+	CommitSHA      string       `json:"commitSHA,omitempty"`
+	Location       string       `json:"location,omitempty"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// syncView is a PostgresSync as reported by the API
+type syncView struct {
+	Namespace string                             `json:"namespace"`
+	Name      string                             `json:"name"`
+	Status    cevichev1alpha1.PostgresSyncStatus `json:"status"`
+}
+
+// newSyncView returns the API representation of a PostgresSync
+func newSyncView(pgSync *cevichev1alpha1.PostgresSync) syncView {
+	return syncView{
+		Namespace: pgSync.Namespace,
+		Name:      pgSync.Name,
+		Status:    pgSync.Status,
+	}
+}
+
+// operationID returns the ID of a new operation on the PostgresSync. Requests with the same idempotency
+// key get the same ID, requests without one get a random ID
+func operationID(pgSync *cevichev1alpha1.PostgresSync, idempotencyKey string) (string, error) {
+	if idempotencyKey != "" {
+		sum := sha256.Sum256([]byte(pgSync.Namespace + "/" + pgSync.Name + "/" + idempotencyKey))
+		return hex.EncodeToString(sum[:16]), nil
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate operation ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// findOperation returns the operation with the given ID on the PostgresSync, whether it is still
+// pending in the spec or already reported in the status
+func findOperation(pgSync *cevichev1alpha1.PostgresSync, id string) (*operationView, bool) {
+	for _, operationType := range []string{controller.OperationDump, controller.OperationRestore} {
+		if pending := pendingOperation(pgSync, operationType); pending != nil && pending.ID == id {
+			return pending, true
+		}
+	}
+
+	for _, operation := range pgSync.Status.Operations {
+		if operation.ID == id {
+			return &operationView{
+				ID:             operation.ID,
+				Namespace:      pgSync.Namespace,
+				Name:           pgSync.Name,
+				Type:           operation.Type,
+				Phase:          operation.Phase,
+				Message:        operation.Message,
+				Job:            operation.Job,
+				Dump:           operation.Dump,
+				StartTime:      operation.StartTime,
+				CompletionTime: operation.CompletionTime,
+			}, true
+		}
+	}
+	return nil, false
+}
+
+// pendingOperation returns the operation of the given type requested in the spec of the PostgresSync
+// that the operator hasn't picked up yet, or nil
+func pendingOperation(pgSync *cevichev1alpha1.PostgresSync, operationType string) *operationView {
+	operation := &operationView{
+		Namespace: pgSync.Namespace,
+		Name:      pgSync.Name,
+		Type:      operationType,
+		Phase:     controller.PhasePending,
+	}
+
+	switch {
+	case operationType == controller.OperationDump && pgSync.Spec.DumpOnWebhook:
+		operation.ID = pgSync.Spec.DumpRequestID
+		operation.Message = "Waiting for the operator to start the dump"
+	case operationType == controller.OperationRestore && pgSync.Spec.RestoreRequest != nil:
+		operation.ID = pgSync.Spec.RestoreRequest.ID
+		operation.Message = "Waiting for the operator to start the restore"
+	default:
+		return nil
+	}
+	return operation
+}
@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+)
+
+var _ = Describe("Operation tracking", func() {
+	ctx := context.Background()
+
+	var c client.Client
+	var handler http.Handler
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(cevichev1alpha1.AddToScheme(scheme)).To(Succeed())
+
+		webhook := func(secretName string) *cevichev1alpha1.WebhookSpec {
+			return &cevichev1alpha1.WebhookSpec{Credentials: cevichev1alpha1.CredentialReference{SecretName: secretName}}
+		}
+		c = fake.NewClientBuilder().WithScheme(scheme).
+			WithStatusSubresource(&cevichev1alpha1.PostgresSync{}).
+			WithObjects(
+				&cevichev1alpha1.PostgresSync{
+					ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "db"},
+					Spec:       cevichev1alpha1.PostgresSyncSpec{Webhook: webhook("app-webhook")},
+				},
+				&cevichev1alpha1.PostgresSync{
+					ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "db"},
+					Spec:       cevichev1alpha1.PostgresSyncSpec{Webhook: webhook("other-webhook")},
+				},
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "app-webhook", Namespace: "db"},
+					Data:       map[string][]byte{"token": []byte("app-token")},
+				},
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "other-webhook", Namespace: "db"},
+					Data:       map[string][]byte{"token": []byte("other-token")},
+				},
+			).Build()
+		handler = NewWebhookServer(":0", c).routes()
+	})
+
+	call := func(method, path, token string, headers map[string]string, into any) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if into != nil && rec.Code < 300 {
+			Expect(json.Unmarshal(rec.Body.Bytes(), into)).To(Succeed())
+		}
+		return rec.Code
+	}
+
+	It("should return the same operation for retried requests", func() {
+		var first, retried, other operationView
+		key := map[string]string{idempotencyKeyHeader: "pipeline-42"}
+		Expect(call(http.MethodPost, "/dump/db/app", "app-token", key, &first)).To(Equal(http.StatusAccepted))
+		Expect(first.Phase).To(Equal("Pending"))
+		Expect(call(http.MethodPost, "/dump/db/app", "app-token", key, &retried)).To(Equal(http.StatusOK))
+		Expect(retried.ID).To(Equal(first.ID))
+
+		// A dump that is still pending serves other requests as well
+		Expect(call(http.MethodPost, "/dump/db/app", "app-token", nil, &other)).To(Equal(http.StatusOK))
+		Expect(other.ID).To(Equal(first.ID))
+	})
+
+	It("should report the progress of an operation from the PostgresSync status", func() {
+		var operation operationView
+		Expect(call(http.MethodPost, "/dump/db/app", "app-token", nil, &operation)).To(Equal(http.StatusAccepted))
+		Expect(call(http.MethodGet, "/operations/"+operation.ID, "other-token", nil, nil)).To(Equal(http.StatusForbidden))
+		Expect(call(http.MethodGet, "/operations/unknown", "app-token", nil, nil)).To(Equal(http.StatusNotFound))
+
+		// The operator picks the request up and finishes the dump
+		var pgSync cevichev1alpha1.PostgresSync
+		Expect(c.Get(ctx, types.NamespacedName{Namespace: "db", Name: "app"}, &pgSync)).To(Succeed())
+		pgSync.Spec.DumpOnWebhook = false
+		pgSync.Spec.DumpRequestID = ""
+		Expect(c.Update(ctx, &pgSync)).To(Succeed())
+		pgSync.Status.Operations = []cevichev1alpha1.OperationStatus{{
+			ID: operation.ID, Type: "dump", Phase: "Succeeded", Message: "Database dump created successfully",
+		}}
+		Expect(c.Status().Update(ctx, &pgSync)).To(Succeed())
+
+		Expect(call(http.MethodGet, "/operations/"+operation.ID, "app-token", nil, &operation)).To(Equal(http.StatusOK))
+		Expect(operation.Phase).To(Equal("Succeeded"))
+
+		var view syncView
+		Expect(call(http.MethodGet, "/syncs/db/app", "app-token", nil, &view)).To(Equal(http.StatusOK))
+		Expect(view.Status.Operations).To(HaveLen(1))
+	})
+
+	It("should request restores from the given ref", func() {
+		req := httptest.NewRequest(http.MethodPost, "/restore/db/app", strings.NewReader(`{"ref":"v1.2.0"}`))
+		req.Header.Set("Authorization", "Bearer app-token")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusAccepted))
+
+		var operation operationView
+		Expect(json.Unmarshal(rec.Body.Bytes(), &operation)).To(Succeed())
+		Expect(operation.Type).To(Equal("restore"))
+
+		var pgSync cevichev1alpha1.PostgresSync
+		Expect(c.Get(ctx, types.NamespacedName{Namespace: "db", Name: "app"}, &pgSync)).To(Succeed())
+		Expect(pgSync.Spec.RestoreRequest).To(Equal(&cevichev1alpha1.RestoreRequest{ID: operation.ID, Ref: "v1.2.0"}))
+		Expect(pgSync.Spec.DumpOnWebhook).To(BeFalse())
+
+		// Restores and dumps are tracked separately
+		var dump operationView
+		Expect(call(http.MethodPost, "/dump/db/app", "app-token", nil, &dump)).To(Equal(http.StatusAccepted))
+		Expect(dump.ID).NotTo(Equal(operation.ID))
+	})
+
+	It("should only list the PostgresSyncs the request is authenticated for", func() {
+		var list map[string][]syncView
+		Expect(call(http.MethodGet, "/syncs", "other-token", nil, &list)).To(Equal(http.StatusOK))
+		Expect(list["items"]).To(HaveLen(1))
+		Expect(list["items"][0].Name).To(Equal("other"))
+	})
+})
@@ -1,23 +1,37 @@
 package webhook
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	cevichev1alpha1 "cevichedbsync-operator/api/v1alpha1"
+	"cevichedbsync-operator/internal/controller"
 )
 
-// WebhookServer handles on-demand database dumps
+// maxBodySize bounds the request bodies read for signature checks
+const maxBodySize = 1 << 20
+
+// idempotencyKeyHeader carries a client chosen key that makes retried dump requests return the same operation
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// WebhookServer handles on-demand database dumps and restores and reports their progress
 type WebhookServer struct {
 	Addr   string
 	Client client.Client
+
+	auth *authenticator
 }
 
 // NewWebhookServer creates a new webhook server
@@ -25,16 +39,27 @@ func NewWebhookServer(addr string, client client.Client) *WebhookServer {
 	return &WebhookServer{
 		Addr:   addr,
 		Client: client,
+		auth:   newAuthenticator(client),
 	}
 }
 
 // Start starts the webhook server
 func (s *WebhookServer) Start() error {
-	http.HandleFunc("/dump/", s.handleDumpRequest)
-	return http.ListenAndServe(s.Addr, nil)
+	return http.ListenAndServe(s.Addr, s.routes())
 }
 
-// handleDumpRequest handles database dump requests
+// routes returns the handler serving the webhook endpoints
+func (s *WebhookServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dump/", s.handleDumpRequest)
+	mux.HandleFunc("POST /restore/{namespace}/{name}", s.handleRestoreRequest)
+	mux.HandleFunc("GET /operations/{id}", s.handleOperationRequest)
+	mux.HandleFunc("GET /syncs", s.handleSyncsRequest)
+	mux.HandleFunc("GET /syncs/{namespace}/{name}", s.handleSyncRequest)
+	return mux
+}
+
+// handleDumpRequest handles database dump requests and returns the operation tracking the dump
 // Path format: /dump/{namespace}/{name}
 func (s *WebhookServer) handleDumpRequest(w http.ResponseWriter, r *http.Request) {
 	logger := log.Log.WithName("webhook-server")
@@ -54,38 +79,288 @@ func (s *WebhookServer) handleDumpRequest(w http.ResponseWriter, r *http.Request
 	namespace := parts[2]
 	name := parts[3]
 
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	pgSync, ok := s.getAuthorizedSync(w, r, body, namespace, name)
+	if !ok {
+		return
+	}
+
 	// Trigger dump
-	if err := s.triggerDatabaseDump(namespace, name); err != nil {
+	operation, created, err := s.requestOperation(r.Context(), pgSync, controller.OperationDump, r.Header.Get(idempotencyKeyHeader), "")
+	if err != nil {
 		logger.Error(err, "Failed to trigger database dump", "namespace", namespace, "name", name)
 		http.Error(w, fmt.Sprintf("Failed to trigger database dump: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Return success
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(map[string]string{
-		"status":  "success",
-		"message": fmt.Sprintf("Database dump for %s/%s triggered", namespace, name),
-	}); err != nil {
-		logger.Error(err, "Failed to encode JSON response")
+	// Return the operation, retried requests get the existing one
+	status := http.StatusOK
+	if created {
+		status = http.StatusAccepted
 	}
+	w.Header().Set("Location", "/operations/"+operation.ID)
+	writeJSON(w, status, operation)
 }
 
-// triggerDatabaseDump triggers an on-demand database dump
-func (s *WebhookServer) triggerDatabaseDump(namespace, name string) error {
-	ctx := context.Background()
+// restoreRequestBody is the optional body of restore requests
+type restoreRequestBody struct {
+	// Ref is the branch, tag or commit SHA to restore from
+	Ref string `json:"ref,omitempty"`
+}
+
+// handleRestoreRequest handles database restore requests and returns the operation tracking the restore
+// Path format: /restore/{namespace}/{name}
+func (s *WebhookServer) handleRestoreRequest(w http.ResponseWriter, r *http.Request) {
+	logger := log.Log.WithName("webhook-server")
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	pgSync, ok := s.getAuthorizedSync(w, r, body, namespace, name)
+	if !ok {
+		return
+	}
+
+	var request restoreRequestBody
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &request); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Trigger restore
+	operation, created, err := s.requestOperation(r.Context(), pgSync, controller.OperationRestore, r.Header.Get(idempotencyKeyHeader), request.Ref)
+	if err != nil {
+		logger.Error(err, "Failed to trigger database restore", "namespace", namespace, "name", name)
+		http.Error(w, fmt.Sprintf("Failed to trigger database restore: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusAccepted
+	}
+	w.Header().Set("Location", "/operations/"+operation.ID)
+	writeJSON(w, status, operation)
+}
+
+// handleOperationRequest reports the progress of an operation
+// Path format: /operations/{id}
+func (s *WebhookServer) handleOperationRequest(w http.ResponseWriter, r *http.Request) {
+	logger := log.Log.WithName("webhook-server")
+	ctx := r.Context()
+	id := r.PathValue("id")
+
+	if !hasCredentials(r) {
+		unauthorized(w)
+		return
+	}
+
+	// Operation IDs are unguessable, so looking them up before authenticating reveals nothing
+	var pgSyncs cevichev1alpha1.PostgresSyncList
+	if err := s.Client.List(ctx, &pgSyncs); err != nil {
+		logger.Error(err, "Failed to list PostgresSyncs")
+		http.Error(w, "Failed to list PostgresSyncs", http.StatusInternalServerError)
+		return
+	}
+	for i := range pgSyncs.Items {
+		pgSync := &pgSyncs.Items[i]
+		operation, found := findOperation(pgSync, id)
+		if !found {
+			continue
+		}
+
+		if !s.authorize(w, r, nil, pgSync) {
+			return
+		}
+		s.addDumpDetails(ctx, pgSync.Namespace, operation)
+		writeJSON(w, http.StatusOK, operation)
+		return
+	}
+
+	http.Error(w, "Operation not found", http.StatusNotFound)
+}
+
+// handleSyncRequest reports the status of a PostgresSync
+// Path format: /syncs/{namespace}/{name}
+func (s *WebhookServer) handleSyncRequest(w http.ResponseWriter, r *http.Request) {
+	pgSync, ok := s.getAuthorizedSync(w, r, nil, r.PathValue("namespace"), r.PathValue("name"))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, newSyncView(pgSync))
+}
+
+// handleSyncsRequest reports the status of every PostgresSync the request is authenticated for
+// Path format: /syncs
+func (s *WebhookServer) handleSyncsRequest(w http.ResponseWriter, r *http.Request) {
+	logger := log.Log.WithName("webhook-server")
+	ctx := r.Context()
+
+	if !hasCredentials(r) {
+		unauthorized(w)
+		return
+	}
+
+	var pgSyncs cevichev1alpha1.PostgresSyncList
+	if err := s.Client.List(ctx, &pgSyncs); err != nil {
+		logger.Error(err, "Failed to list PostgresSyncs")
+		http.Error(w, "Failed to list PostgresSyncs", http.StatusInternalServerError)
+		return
+	}
+
+	items := []syncView{}
+	for i := range pgSyncs.Items {
+		pgSync := &pgSyncs.Items[i]
+		if err := s.auth.authenticate(ctx, pgSync, r, nil); err != nil {
+			if !errors.Is(err, errUnauthenticated) && !errors.Is(err, errForbidden) {
+				logger.Error(err, "Failed to authenticate webhook request", "namespace", pgSync.Namespace, "name", pgSync.Name)
+			}
+			continue
+		}
+		items = append(items, newSyncView(pgSync))
+	}
+
+	writeJSON(w, http.StatusOK, map[string][]syncView{"items": items})
+}
+
+// getAuthorizedSync gets the PostgresSync targeted by a request and authenticates the request against it,
+// writing the error response and returning false when it fails
+func (s *WebhookServer) getAuthorizedSync(w http.ResponseWriter, r *http.Request, body []byte, namespace, name string) (*cevichev1alpha1.PostgresSync, bool) {
+	logger := log.Log.WithName("webhook-server")
+
+	// Requests without credentials are rejected before revealing whether the PostgresSync exists
+	if !hasCredentials(r) {
+		unauthorized(w)
+		return nil, false
+	}
 
 	// Get the PostgresSync resource
 	pgSync := &cevichev1alpha1.PostgresSync{}
-	if err := s.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, pgSync); err != nil {
-		return fmt.Errorf("failed to get PostgresSync resource: %w", err)
+	if err := s.Client.Get(r.Context(), types.NamespacedName{Namespace: namespace, Name: name}, pgSync); err != nil {
+		if apierrors.IsNotFound(err) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return nil, false
+		}
+		logger.Error(err, "Failed to get PostgresSync", "namespace", namespace, "name", name)
+		http.Error(w, "Failed to get PostgresSync", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !s.authorize(w, r, body, pgSync) {
+		return nil, false
+	}
+	return pgSync, true
+}
+
+// authorize authenticates a request against the credentials of the PostgresSync, writing the error
+// response and returning false when it fails
+func (s *WebhookServer) authorize(w http.ResponseWriter, r *http.Request, body []byte, pgSync *cevichev1alpha1.PostgresSync) bool {
+	logger := log.Log.WithName("webhook-server")
+
+	err := s.auth.authenticate(r.Context(), pgSync, r, body)
+	if err == nil {
+		return true
+	}
+
+	logger.Info("Rejected webhook request", "namespace", pgSync.Namespace, "name", pgSync.Name, "reason", err.Error())
+	switch {
+	case errors.Is(err, errUnauthenticated):
+		unauthorized(w)
+	case errors.Is(err, errForbidden):
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	default:
+		logger.Error(err, "Failed to authenticate webhook request", "namespace", pgSync.Namespace, "name", pgSync.Name)
+		http.Error(w, "Failed to authenticate request", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// requestOperation requests a dump or restore of the PostgresSync and returns the operation tracking it.
+// It returns false when the request is served by an existing operation, either the one with the same
+// idempotency key or an operation of the same type that is still pending
+func (s *WebhookServer) requestOperation(ctx context.Context, pgSync *cevichev1alpha1.PostgresSync, operationType, idempotencyKey, ref string) (*operationView, bool, error) {
+	id, err := operationID(pgSync, idempotencyKey)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var operation *operationView
+	var created bool
+	key := client.ObjectKeyFromObject(pgSync)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := s.Client.Get(ctx, key, pgSync); err != nil {
+			return fmt.Errorf("failed to get PostgresSync resource: %w", err)
+		}
+
+		if existing, found := findOperation(pgSync, id); found {
+			operation, created = existing, false
+			return nil
+		}
+		if pending := pendingOperation(pgSync, operationType); pending != nil && pending.ID != "" {
+			operation, created = pending, false
+			return nil
+		}
+
+		switch operationType {
+		case controller.OperationRestore:
+			// Set the restore request for the reconciler
+			pgSync.Spec.RestoreRequest = &cevichev1alpha1.RestoreRequest{ID: id, Ref: ref}
+		default:
+			// Set the DumpOnWebhook flag to trigger dump in reconciler
+			pgSync.Spec.DumpOnWebhook = true
+			pgSync.Spec.DumpRequestID = id
+		}
+		if err := s.Client.Update(ctx, pgSync); err != nil {
+			return err
+		}
+		operation, created = pendingOperation(pgSync, operationType), true
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to update PostgresSync: %w", err)
 	}
 
-	// Set the DumpOnWebhook flag to trigger dump in reconciler
-	pgSync.Spec.DumpOnWebhook = true
-	if err := s.Client.Update(ctx, pgSync); err != nil {
-		return fmt.Errorf("failed to update PostgresSync: %w", err)
+	return operation, created, nil
+}
+
+// addDumpDetails adds the commit of the dump recorded for an operation, if any
+func (s *WebhookServer) addDumpDetails(ctx context.Context, namespace string, operation *operationView) {
+	if operation.Dump == "" {
+		return
 	}
 
-	return nil
+	dump := &cevichev1alpha1.PostgresSyncDump{}
+	if err := s.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: operation.Dump}, dump); err != nil {
+		// The dump may have been pruned from the history already
+		return
+	}
+	operation.CommitSHA = dump.Status.CommitSHA
+	operation.Location = dump.Status.Location
+}
+
+// unauthorized writes the response to requests without credentials
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Log.WithName("webhook-server").Error(err, "Failed to encode JSON response")
+	}
 }